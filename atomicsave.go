@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/xyproto/env"
+)
+
+// Keep a "filename~" copy of the previous version when saving, if O_BACKUP is set
+var keepBackup = env.Bool("O_BACKUP")
+
+// The file mode creation mask of the process, which is applied to the permissions of new files
+var umask = currentUmask()
+
+// currentUmask returns the file mode creation mask, without changing it
+func currentUmask() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
+}
+
+// resolveSymlinks returns the file that the given filename points to, if it is a symlink.
+// If the filename is not a symlink, or it does not exist yet, the filename is returned as it is.
+func resolveSymlinks(filename string) string {
+	target, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return filename
+	}
+	return target
+}
+
+// fileModeAndOwner returns the permission bits, the owner and the group of the given file.
+// If the file does not exist, false is returned.
+func fileModeAndOwner(filename string) (os.FileMode, int, int, bool) {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return 0, -1, -1, false
+	}
+	uid, gid := -1, -1
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		uid = int(stat.Uid)
+		gid = int(stat.Gid)
+	}
+	return fileInfo.Mode().Perm(), uid, gid, true
+}
+
+// backupFile makes "filename~" a copy of the given file. A hard link is tried first,
+// since the original file is about to be replaced by a rename anyway.
+func backupFile(filename string) error {
+	backupFilename := filename + "~"
+	os.Remove(backupFilename)
+	if err := os.Link(filename, backupFilename); err == nil { // success
+		return nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	perm, _, _, _ := fileModeAndOwner(filename)
+	return ioutil.WriteFile(backupFilename, data, perm)
+}
+
+// saveFile writes data to the given filename by writing a temporary file next to it and renaming it.
+// Symlinks are followed, so that the link stays a link. The given mode is used for the file,
+// and the owner and group are kept, if possible. If the directory is not writable, but the file
+// is, the file is written to directly instead. If a backup was requested, but could not be made,
+// the file is still saved and the reason is returned as backupErr.
+func saveFile(filename string, data []byte, fileMode os.FileMode, backup bool) (backupErr, err error) {
+	target := resolveSymlinks(filename)
+	_, uid, gid, found := fileModeAndOwner(target)
+	if found && backup {
+		backupErr = backupFile(target)
+	}
+	err = writeFileAtomic(target, data, fileMode, uid, gid)
+	if err != nil && found {
+		// Could not write a temporary file in the same directory, try writing the file directly
+		if err2 := ioutil.WriteFile(target, data, fileMode); err2 == nil { // success
+			err = os.Chmod(target, fileMode)
+		}
+	}
+	return backupErr, err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	realFilename := filepath.Join(dir, "real.txt")
+	linkFilename := filepath.Join(dir, "link.txt")
+	if err := ioutil.WriteFile(realFilename, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(realFilename, 0660)
+	if err := os.Symlink("real.txt", linkFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	perm, _, _, _ := fileModeAndOwner(realFilename)
+	if backupErr, err := saveFile(linkFilename, []byte("new\n"), perm, true); err != nil || backupErr != nil {
+		t.Fatal(err, backupErr)
+	}
+
+	// The link should still be a link
+	if fileInfo, err := os.Lstat(linkFilename); err != nil || fileInfo.Mode()&os.ModeSymlink == 0 {
+		t.Error("the symlink was replaced")
+	}
+	// The file it points to should have the new contents and the same permissions
+	data, err := ioutil.ReadFile(realFilename)
+	if err != nil || string(data) != "new\n" {
+		t.Errorf("expected the new contents, got %q", string(data))
+	}
+	if perm, _, _, _ := fileModeAndOwner(realFilename); perm != 0660 {
+		t.Errorf("expected permissions 0660, got %o", perm)
+	}
+	// There should be a backup with the old contents
+	data, err = ioutil.ReadFile(realFilename + "~")
+	if err != nil || string(data) != "old\n" {
+		t.Errorf("expected a backup with the old contents, got %q", string(data))
+	}
+}
+
+func TestSaveFileBackupFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(filename, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A non-empty directory where the backup should go makes the backup fail
+	if err := os.MkdirAll(filepath.Join(filename+"~", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	backupErr, err := saveFile(filename, []byte("new\n"), 0644, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backupErr == nil {
+		t.Error("expected the backup to fail")
+	}
+	// The file should be saved anyway
+	if data, err := ioutil.ReadFile(filename); err != nil || string(data) != "new\n" {
+		t.Errorf("expected the new contents, got %q", string(data))
+	}
+}
+
+func TestSaveNewFileUmask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(mask os.FileMode) { umask = mask }(umask)
+	umask = 077
+
+	e := NewSimpleEditor(80)
+	e.filename = filepath.Join(dir, "new.txt")
+	e.InsertStringAndMove(nil, "hello")
+	if err := e.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	if perm, _, _, _ := fileModeAndOwner(e.filename); perm != 0600 {
+		t.Errorf("expected permissions 0600 for a new file with umask 077, got %o", perm)
+	}
+
+	// The permissions of an existing file are kept as they are
+	os.Chmod(e.filename, 0664)
+	e.InsertStringAndMove(nil, "!")
+	if err := e.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	if perm, _, _, _ := fileModeAndOwner(e.filename); perm != 0664 {
+		t.Errorf("expected permissions 0664 for an existing file, got %o", perm)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/xyproto/env"
+	"github.com/xyproto/vt100"
+)
+
+// defaultAutoPairs are the runes that are paired when typed, as pairs of opening and closing runes
+const defaultAutoPairs = `()[]{}""''`
+
+// autoPairing is true if brackets and quotes should be paired when typed.
+// It can be enabled with O_AUTOPAIR=1 or toggled in the ctrl-o menu.
+var autoPairing = env.Bool("O_AUTOPAIR")
+
+// autoPairsPerMode are the pairs for modes where the defaults do not fit
+var autoPairsPerMode = map[Mode]string{
+	modeLisp:     `()[]{}""`,     // ' is used for quoting
+	modeMarkdown: "()[]{}\"\"``", // ' is used in text, ` is used for code
+	modeText:     `()[]{}""`,
+	modeBlank:    `()[]{}""`,
+	modeGit:      `()[]{}""`,
+	modeGo:       defaultAutoPairs + "``",
+	modeShell:    defaultAutoPairs + "``",
+	modeRust:     `()[]{}""`, // ' is used for lifetimes
+	modeOCaml:    `()[]{}""`, // ' is used for type variables
+	modeVim:      `()[]{}''`, // " starts a comment
+	modeHTML:     defaultAutoPairs + "<>",
+	modeXML:      defaultAutoPairs + "<>",
+}
+
+// autoPairsFilename is a file where users can change which runes are paired, per mode, like this:
+//
+//	go = ()[]{}""''``
+//	default = ()[]{}""
+//
+// The names of the modes are the same as for the snippet files. The default is used for
+// modes that are not listed, neither in the file nor in autoPairsPerMode.
+const autoPairsFilename = "~/.config/o/autopairs.conf"
+
+var (
+	userAutoPairs     map[string]string
+	userAutoPairsOnce sync.Once
+)
+
+// parseAutoPairs parses the pairs per mode name from the contents of a configuration file.
+// Lines without "=", and lines with an odd number of runes after "=", are skipped.
+func parseAutoPairs(text string) map[string]string {
+	pairs := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		pos := strings.Index(trimmed, "=")
+		if pos < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(trimmed[:pos]))
+		value := strings.TrimSpace(trimmed[pos+1:])
+		if name == "" || len([]rune(value))%2 != 0 {
+			continue
+		}
+		pairs[name] = value
+	}
+	return pairs
+}
+
+// AutoPairs returns the opening and closing runes that are paired in the current mode
+func (e *Editor) AutoPairs() map[rune]rune {
+	userAutoPairsOnce.Do(func() {
+		if data, err := ioutil.ReadFile(expandUser(autoPairsFilename)); err == nil {
+			userAutoPairs = parseAutoPairs(string(data))
+		}
+	})
+	pairs := defaultAutoPairs
+	if userPairs, found := userAutoPairs[e.mode.Name()]; found {
+		pairs = userPairs
+	} else if modePairs, found := autoPairsPerMode[e.mode]; found {
+		pairs = modePairs
+	} else if userPairs, found := userAutoPairs["default"]; found {
+		pairs = userPairs
+	}
+	runes := []rune(pairs)
+	m := make(map[rune]rune, len(runes)/2)
+	for i := 0; i+1 < len(runes); i += 2 {
+		m[runes[i]] = runes[i+1]
+	}
+	return m
+}
+
+// QuoteStateAtCursor returns the quote state at the cursor, for knowing if the cursor is within a string or a comment
+func (e *Editor) QuoteStateAtCursor() *QuoteState {
+	var (
+		q                  = NewQuoteState(e.SingleLineCommentMarker(), e.mode)
+		ignoreSingleQuotes = e.mode == modeLisp
+		y                  = e.DataY()
+	)
+	for i := LineIndex(0); i < y; i++ {
+		q.Process(strings.TrimSpace(e.Line(i)), ignoreSingleQuotes)
+	}
+	runes := e.lines[int(y)]
+	x, err := e.DataX()
+	if err != nil || x > len(runes) {
+		x = len(runes)
+	}
+	q.Process(strings.TrimLeft(string(runes[:x]), " \t"), ignoreSingleQuotes)
+	return q
+}
+
+// AutoPair handles typing the given rune when auto-pairing is enabled.
+// If the rune is a closing rune and the same rune is under the cursor, the cursor moves past it.
+// If the rune is an opening rune, and the cursor is not within a string or a comment,
+// the closing rune is inserted after the cursor.
+// Returns true if the rune was handled, or false if it should be inserted as usual.
+func (e *Editor) AutoPair(c *vt100.Canvas, r rune) bool {
+	if !autoPairing {
+		return false
+	}
+	pairs := e.AutoPairs()
+	under := e.Rune()
+
+	// Type over the closing rune
+	for _, closer := range pairs {
+		if r == closer && under == r {
+			e.Next(c)
+			return true
+		}
+	}
+
+	closer, found := pairs[r]
+	if !found {
+		return false
+	}
+	// Only pair if the next rune is not a part of a word, to avoid pairing when wrapping existing text
+	if under != 0 && !unicode.IsSpace(under) && !strings.ContainsRune(`)]}>,;:"'`+"`", under) {
+		return false
+	}
+	if r == closer {
+		// For quotes, avoid pairing after a letter, like in "don't"
+		if left := e.LeftRune(); isIdentifierRune(left) || left == '\\' {
+			return false
+		}
+	}
+	if !e.QuoteStateAtCursor().None() {
+		return false
+	}
+	e.InsertRune(c, r)
+	e.WriteRune(c)
+	e.Next(c)
+	e.InsertRune(c, closer)
+	e.WriteRune(c)
+	return true
+}
+
+// AutoPairBackspace deletes the closing rune under the cursor, if the rune to the left is the
+// opening rune of the same pair, so that backspace between a pair deletes both runes.
+// The opening rune should be deleted as usual afterwards.
+func (e *Editor) AutoPairBackspace() {
+	if !autoPairing {
+		return
+	}
+	if closer, found := e.AutoPairs()[e.LeftRune()]; found && e.Rune() == closer {
+		e.Delete()
+	}
+}
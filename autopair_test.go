@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAutoPair(t *testing.T) {
+	autoPairing = true
+	defer func() {
+		autoPairing = false
+	}()
+
+	e := NewSimpleEditor(80)
+	e.mode = modeGo
+	e.InsertStringAndMove(nil, "f")
+	if !e.AutoPair(nil, '(') {
+		t.Fatal("( should be paired")
+	}
+	if s := e.String(); s != "f()\n" {
+		t.Errorf("expected f(), got %q", s)
+	}
+	if !e.AutoPair(nil, ')') || e.String() != "f()\n" || e.Rune() != 0 {
+		t.Errorf("expected ) to be typed over, got %q", e.String())
+	}
+
+	// Nothing is paired within strings
+	e = NewSimpleEditor(80)
+	e.mode = modeGo
+	e.InsertStringAndMove(nil, `x := "abc `)
+	if e.AutoPair(nil, '(') {
+		t.Error("( should not be paired within a string")
+	}
+
+	// Backspace between a pair deletes both
+	e = NewSimpleEditor(80)
+	e.mode = modeGo
+	e.AutoPair(nil, '[')
+	e.AutoPairBackspace()
+	if s := e.String(); s != "[\n" {
+		t.Errorf("expected the closing ] to be deleted, got %q", s)
+	}
+
+	// Lisp does not pair single quotes
+	e = NewSimpleEditor(80)
+	e.mode = modeLisp
+	if e.AutoPair(nil, '\'') {
+		t.Error("' should not be paired in Lisp")
+	}
+}
+
+func TestParseAutoPairs(t *testing.T) {
+	pairs := parseAutoPairs("# comment\ngo = ()[]{}\n Default=()\nodd = ()[\nno equals sign\n= ()\n")
+	if len(pairs) != 2 || pairs["go"] != "()[]{}" || pairs["default"] != "()" {
+		t.Errorf("expected the pairs for go and the default, got %v", pairs)
+	}
+}
+
+func TestAutoPairsOverrides(t *testing.T) {
+	// Make sure that the configuration file of the current user is not read
+	userAutoPairsOnce.Do(func() {})
+	defer func(pairs map[string]string) { userAutoPairs = pairs }(userAutoPairs)
+	userAutoPairs = map[string]string{"go": "()", "default": "<>"}
+
+	e := NewSimpleEditor(80)
+	// The pairs for a mode in the configuration file replace the built-in pairs for that mode
+	e.mode = modeGo
+	if pairs := e.AutoPairs(); len(pairs) != 1 || pairs['('] != ')' {
+		t.Errorf("expected only () for Go, got %v", pairs)
+	}
+	// The built-in pairs for a mode are used before the default in the configuration file
+	e.mode = modeRust
+	if pairs := e.AutoPairs(); len(pairs) != 4 || pairs['\''] != 0 {
+		t.Errorf("expected the built-in pairs for Rust, got %v", pairs)
+	}
+	// The default in the configuration file is used for other modes
+	e.mode = modeC
+	if pairs := e.AutoPairs(); len(pairs) != 1 || pairs['<'] != '>' {
+		t.Errorf("expected only <> for C, got %v", pairs)
+	}
+}
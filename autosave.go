@@ -0,0 +1,249 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xyproto/env"
+	"github.com/xyproto/vt100"
+)
+
+const swapDirectory = "swap" // in the cache directory
+
+var (
+	// Write a swap file every N seconds, if there are unsaved changes. 0 disables the timer.
+	autosaveInterval = time.Duration(env.Int("O_AUTOSAVE_SECONDS", 20)) * time.Second
+
+	// Write a swap file every N keystrokes, if there are unsaved changes. 0 disables the counter.
+	autosaveKeystrokes = env.Int("O_AUTOSAVE_KEYSTROKES", 200)
+)
+
+// Autosave periodically writes a swap copy of the contents of an editor that has unsaved changes,
+// so that the changes can be recovered if the terminal or the editor dies.
+// The main loop holds the lock while handling a keypress, and the autosave goroutine
+// holds the lock while taking a copy of the editor contents.
+type Autosave struct {
+	e        *Editor
+	mut      *sync.Mutex
+	trigger  chan bool
+	quit     chan bool       // closed to stop the autosave goroutine
+	wg       *sync.WaitGroup // for waiting until the autosave goroutine has stopped
+	written  string          // the absolute filename of the swap file this session is responsible for, if any
+	keyCount int             // keystrokes since the last swap file was written
+	dirty    bool            // has there been a keypress since the last swap file was written?
+	running  bool            // has the autosave goroutine been started?
+	disabled bool            // don't write swap files for this session
+}
+
+// NewAutosave creates a new Autosave struct for the given editor, but does not start it
+func NewAutosave(e *Editor) *Autosave {
+	return &Autosave{e, &sync.Mutex{}, make(chan bool, 1), make(chan bool), &sync.WaitGroup{}, "", 0, false, false, false}
+}
+
+// swapFilename returns the swap filename for the given absolute filename
+func swapFilename(absFilename string) string {
+	return filepath.Join(cachePath(swapDirectory), url.QueryEscape(absFilename)+".swp")
+}
+
+// HasSwap checks if there is a swap file for the given absolute filename
+func HasSwap(absFilename string) bool {
+	return exists(swapFilename(absFilename))
+}
+
+// LoadSwap returns the contents of the swap file for the given absolute filename,
+// together with the time the swap file was last written.
+func LoadSwap(absFilename string) ([]byte, time.Time, error) {
+	var modTime time.Time
+	fn := swapFilename(absFilename)
+	fileInfo, err := os.Stat(fn)
+	if err != nil {
+		return nil, modTime, err
+	}
+	modTime = fileInfo.ModTime()
+	data, err := ioutil.ReadFile(fn)
+	return data, modTime, err
+}
+
+// WriteSwap writes the given data to the swap file for the given absolute filename
+func WriteSwap(absFilename string, data []byte) error {
+	fn := swapFilename(absFilename)
+	// First create the folder for the swap files, if needed. Only the current user should be able to read them.
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(fn, data, 0600, -1, -1)
+}
+
+// RemoveSwap removes the swap file for the given absolute filename, if it exists
+func RemoveSwap(absFilename string) error {
+	return os.Remove(swapFilename(absFilename))
+}
+
+// Lock is called by the main loop before handling a keypress
+func (a *Autosave) Lock() {
+	a.mut.Lock()
+}
+
+// Unlock is called by the main loop after a keypress has been handled
+func (a *Autosave) Unlock() {
+	a.mut.Unlock()
+}
+
+// Keypress registers that a key was pressed. Must be called while holding the lock.
+// If enough keys have been pressed, the autosave goroutine is asked to write a swap file.
+func (a *Autosave) Keypress() {
+	a.dirty = true
+	a.keyCount++
+	if autosaveKeystrokes > 0 && a.keyCount >= autosaveKeystrokes {
+		a.keyCount = 0
+		// Ask the goroutine to write a swap file, but don't block if it's already been asked
+		select {
+		case a.trigger <- true:
+		default:
+		}
+	}
+}
+
+// prepare takes a copy of the editor contents if there are unsaved changes that are not in the
+// swap file yet. If all changes have been saved, the swap file is removed. Must be called while
+// holding the lock. Returns the absolute filename and the data to write, or nil if there is nothing to write.
+func (a *Autosave) prepare() (string, []byte) {
+	absFilename, err := a.e.AbsFilename()
+	if err != nil {
+		return "", nil
+	}
+	if !a.e.changed {
+		// Everything is saved, so the swap file is no longer needed. Ignore errors.
+		if a.written == absFilename {
+			RemoveSwap(absFilename)
+			a.written = ""
+		}
+		a.dirty = false
+		return absFilename, nil
+	}
+	if !a.dirty {
+		// Nothing new to write
+		return absFilename, nil
+	}
+	a.dirty = false
+	a.written = absFilename
+	return absFilename, []byte(a.e.String())
+}
+
+// Start launches the autosave goroutine
+func (a *Autosave) Start() {
+	if a.disabled || (autosaveInterval <= 0 && autosaveKeystrokes <= 0) {
+		// Autosave has been disabled
+		return
+	}
+	a.running = true
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		var tick <-chan time.Time
+		if autosaveInterval > 0 {
+			ticker := time.NewTicker(autosaveInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-a.quit:
+				return
+			case <-tick:
+			case <-a.trigger:
+			}
+			// Take a copy of the contents while the main loop is waiting for a keypress
+			a.mut.Lock()
+			absFilename, data := a.prepare()
+			a.mut.Unlock()
+			if data != nil {
+				// Write the swap file without holding up the main loop. Errors are ignored,
+				// since there is not much that can be done about them here.
+				WriteSwap(absFilename, data)
+			}
+		}
+	}()
+}
+
+// Done removes the swap file this session is responsible for, if any.
+// Called when the editor quits normally.
+func (a *Autosave) Done() {
+	a.Stop()
+	if a.written != "" {
+		RemoveSwap(a.written)
+		a.written = ""
+	}
+}
+
+// Stop stops the autosave goroutine, if it is running, and waits for it to finish
+// writing any swap file. Must not be called while holding the lock.
+func (a *Autosave) Stop() {
+	if !a.running {
+		return
+	}
+	a.running = false
+	close(a.quit)
+	a.wg.Wait()
+}
+
+// WriteNow writes a swap file right away, without checking for changes. Used when crashing.
+func (a *Autosave) WriteNow() error {
+	absFilename, err := a.e.AbsFilename()
+	if err != nil {
+		return err
+	}
+	return WriteSwap(absFilename, []byte(a.e.String()))
+}
+
+// Recover checks if there is a swap file for the current file, left behind by an editor
+// session that did not end properly. If the contents differ from what was loaded, a menu is
+// presented where the unsaved changes can be recovered or discarded.
+// Returns a status message (possibly empty).
+func (a *Autosave) Recover(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY) string {
+	e := a.e
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return ""
+	}
+	data, modTime, err := LoadSwap(absFilename)
+	if err != nil {
+		// No swap file, or one that can not be read
+		return ""
+	}
+	if string(data) == e.String() {
+		// Nothing to recover
+		RemoveSwap(absFilename)
+		return ""
+	}
+	choices := []string{
+		"Recover the unsaved changes from " + modTime.Format("2006-01-02 15:04"),
+		"Discard the unsaved changes",
+		"Keep the swap file and disable autosave for now",
+	}
+	title := "Found unsaved changes for " + filepath.Base(absFilename)
+	selected := e.Menu(status, tty, title, choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+	e.redraw = true
+	e.redrawCursor = true
+	switch selected {
+	case 0: // recover
+		undo.Snapshot(e)
+		e.LoadBytes(data)
+		if e.AfterEndOfLine() {
+			e.End(c)
+		}
+		// Keep the swap file until the recovered contents are saved
+		a.written = absFilename
+		return "Recovered unsaved changes, press ctrl-s to save them"
+	case 1: // discard
+		RemoveSwap(absFilename)
+		return "Discarded unsaved changes"
+	}
+	// Leave the swap file alone, and don't overwrite it in this session
+	a.disabled = true
+	return "Autosave is disabled, the unsaved changes are kept in " + swapFilename(absFilename)
+}
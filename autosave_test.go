@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAutosave(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "o_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", cacheDir)
+	defer func(n int) { autosaveKeystrokes = n }(autosaveKeystrokes)
+	autosaveKeystrokes = 1
+
+	filename := filepath.Join(cacheDir, "main.txt")
+	if err := ioutil.WriteFile(filename, []byte("saved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := NewSimpleEditor(80)
+	e.filename = filename
+	e.InsertStringAndMove(nil, "unsaved")
+	e.changed = true
+
+	// Write a swap file by pressing a key
+	a := NewAutosave(e)
+	a.Start()
+	a.Lock()
+	a.Keypress()
+	a.Unlock()
+	for i := 0; i < 100 && !HasSwap(filename); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !HasSwap(filename) {
+		t.Fatal("expected a swap file to be written")
+	}
+	if !exists(filepath.Join(cacheDir, "o", "swap")) {
+		t.Error("expected the swap file to be in XDG_CACHE_HOME")
+	}
+
+	// Restore the unsaved changes from the swap file
+	data, _, err := LoadSwap(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2 := NewSimpleEditor(80)
+	e2.LoadBytes(data)
+	if e2.String() != e.String() {
+		t.Errorf("expected the swap file to contain %q, got %q", e.String(), e2.String())
+	}
+
+	// A clean exit removes the swap file, and the stopped goroutine does not write it again
+	a.Done()
+	if HasSwap(filename) {
+		t.Error("expected the swap file to be removed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if HasSwap(filename) {
+		t.Error("expected no swap file to be written after Done")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+const bookmarksFilename = "bookmarks.txt" // in the cache directory
+
+// The color of the bookmark markers in the rightmost column
+var bookmarkMarkerColor = vt100.LightMagenta
+
+// Bookmark is a named line in the current file
+type Bookmark struct {
+	name  string
+	index LineIndex
+}
+
+// Bookmarks is a list of bookmarks, sorted by line index.
+// Bookmarks is never modified in place, so that undo snapshots of the editor keep their own bookmarks.
+type Bookmarks []Bookmark
+
+// At returns the name of the bookmark at the given line index, if any
+func (bs Bookmarks) At(index LineIndex) (string, bool) {
+	for _, b := range bs {
+		if b.index == index {
+			return b.name, true
+		}
+	}
+	return "", false
+}
+
+// Add returns a new list of bookmarks, where the given bookmark is added
+// or replaces a bookmark with the same name
+func (bs Bookmarks) Add(name string, index LineIndex) Bookmarks {
+	bs2 := make(Bookmarks, 0, len(bs)+1)
+	for _, b := range bs {
+		if b.name != name && b.index != index {
+			bs2 = append(bs2, b)
+		}
+	}
+	bs2 = append(bs2, Bookmark{name, index})
+	sort.SliceStable(bs2, func(i, j int) bool {
+		return bs2[i].index < bs2[j].index
+	})
+	return bs2
+}
+
+// Remove returns a new list of bookmarks, without the bookmark at the given line index
+func (bs Bookmarks) Remove(index LineIndex) Bookmarks {
+	bs2 := make(Bookmarks, 0, len(bs))
+	for _, b := range bs {
+		if b.index != index {
+			bs2 = append(bs2, b)
+		}
+	}
+	return bs2
+}
+
+// Shift returns a new list of bookmarks where bookmarks at or after the given line index
+// are moved by delta lines. Used when lines are inserted or deleted.
+// Bookmarks on deleted lines stay where they are, on the line that takes their place.
+func (bs Bookmarks) Shift(from LineIndex, delta int) Bookmarks {
+	if len(bs) == 0 {
+		return bs
+	}
+	// The new line index of a bookmark that is moved. Not above the first deleted line, if lines are deleted.
+	shifted := func(index LineIndex) LineIndex {
+		if index += LineIndex(delta); index < from+LineIndex(delta) {
+			return from + LineIndex(delta)
+		}
+		return index
+	}
+	// The line indices that moved bookmarks end up on
+	taken := make(map[LineIndex]bool)
+	for _, b := range bs {
+		if b.index >= from {
+			taken[shifted(b.index)] = true
+		}
+	}
+	bs2 := make(Bookmarks, 0, len(bs))
+	for _, b := range bs {
+		if b.index >= from {
+			b.index = shifted(b.index)
+		} else if taken[b.index] {
+			// A bookmark on a deleted line gives way to the bookmark that moved into its place
+			continue
+		}
+		// If two bookmarks still end up on the same line, keep the first one
+		if _, found := bs2.At(b.index); !found {
+			bs2 = append(bs2, b)
+		}
+	}
+	return bs2
+}
+
+// NextFreeName returns the lowest number, from 1 and up, that is not used as a bookmark name
+func (bs Bookmarks) NextFreeName() string {
+	for i := 1; ; i++ {
+		name := strconv.Itoa(i)
+		found := false
+		for _, b := range bs {
+			if b.name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return name
+		}
+	}
+}
+
+// String returns the bookmarks as a space separated list of name=line number
+func (bs Bookmarks) String() string {
+	fields := make([]string, len(bs))
+	for i, b := range bs {
+		fields[i] = b.name + "=" + b.index.LineNumber().String()
+	}
+	return strings.Join(fields, " ")
+}
+
+// parseBookmarks parses a space separated list of name=line number
+func parseBookmarks(s string) Bookmarks {
+	var bs Bookmarks
+	for _, field := range strings.Fields(s) {
+		pos := strings.LastIndex(field, "=")
+		if pos < 1 {
+			continue
+		}
+		lineNumber, err := strconv.Atoi(field[pos+1:])
+		if err != nil || lineNumber < 1 {
+			continue
+		}
+		bs = bs.Add(field[:pos], LineNumber(lineNumber).LineIndex())
+	}
+	return bs
+}
+
+// LoadAllBookmarks loads the bookmarks for all files from the given bookmarks file.
+// The format of the file is, per line: "filename": name=line name=line
+func LoadAllBookmarks(bookmarksFile string) (map[string]Bookmarks, error) {
+	allBookmarks := make(map[string]Bookmarks)
+	data, err := ioutil.ReadFile(bookmarksFile)
+	if err != nil {
+		return allBookmarks, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		pos := strings.LastIndex(line, "\":")
+		if !strings.HasPrefix(line, "\"") || pos < 1 {
+			continue
+		}
+		if bs := parseBookmarks(line[pos+2:]); len(bs) > 0 {
+			allBookmarks[line[1:pos]] = bs
+		}
+	}
+	return allBookmarks, nil
+}
+
+// LoadBookmarks returns the saved bookmarks for the given absolute filename
+func LoadBookmarks(absFilename string) Bookmarks {
+	allBookmarks, _ := LoadAllBookmarks(cachePath(bookmarksFilename))
+	return allBookmarks[absFilename]
+}
+
+// SaveBookmarks saves the bookmarks for the given absolute filename,
+// while keeping the bookmarks for other files
+func SaveBookmarks(absFilename string, bs Bookmarks) error {
+	bookmarksFile := cachePath(bookmarksFilename)
+	allBookmarks, _ := LoadAllBookmarks(bookmarksFile)
+	if len(bs) == 0 {
+		if _, found := allBookmarks[absFilename]; !found {
+			// Nothing to save or remove
+			return nil
+		}
+		delete(allBookmarks, absFilename)
+	} else {
+		allBookmarks[absFilename] = bs
+	}
+	if len(allBookmarks) > maxLocationHistoryEntries {
+		// Cull the bookmarks for the other files
+		allBookmarks = map[string]Bookmarks{absFilename: bs}
+	}
+
+	// First create the folder, if needed, in a best effort attempt
+	os.MkdirAll(filepath.Dir(bookmarksFile), os.ModePerm)
+
+	var sb strings.Builder
+	for k, v := range allBookmarks {
+		sb.WriteString(fmt.Sprintf("\"%s\": %s\n", k, v))
+	}
+	return ioutil.WriteFile(bookmarksFile, []byte(sb.String()), 0600)
+}
+
+// ToggleBookmark adds a numbered bookmark at the current line, or removes it if there already is one.
+// Returns a status message.
+func (e *Editor) ToggleBookmark() string {
+	y := e.DataY()
+	if name, found := e.bookmarks.At(y); found {
+		e.bookmarks = e.bookmarks.Remove(y)
+		return "Removed bookmark " + name + " at line " + e.LineNumber().String()
+	}
+	name := e.bookmarks.NextFreeName()
+	e.bookmarks = e.bookmarks.Add(name, y)
+	return "Added bookmark " + name + " at line " + e.LineNumber().String()
+}
+
+// BookmarkMenu shows a menu where a bookmark can be added at the current line, or where
+// one of the existing bookmarks can be selected for jumping to it. Returns a status message.
+func (e *Editor) BookmarkMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY) string {
+	lineNumber := e.LineNumber()
+	choices := []string{
+		"Add a bookmark at line " + lineNumber.String(),
+		"Add a named bookmark at line " + lineNumber.String(),
+	}
+	for _, b := range e.bookmarks {
+		preview := strings.TrimSpace(e.Line(b.index))
+		if len([]rune(preview)) > 50 {
+			preview = string([]rune(preview)[:50]) + "..."
+		}
+		choices = append(choices, fmt.Sprintf("%s: line %s: %s", b.name, b.index.LineNumber(), preview))
+	}
+	// Select the first bookmark by default, so that ctrl-b and return jumps to it
+	selected := e.Menu(status, tty, "Bookmarks", choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 2, false)
+	e.redraw = true
+	e.redrawCursor = true
+	switch {
+	case selected == 0:
+		return e.ToggleBookmark()
+	case selected == 1:
+		name, ok := e.UserInput(c, tty, status, "Bookmark name:")
+		if name = strings.Join(strings.Fields(name), "_"); !ok || name == "" {
+			return ""
+		}
+		e.bookmarks = e.bookmarks.Add(name, e.DataY())
+		return "Added bookmark " + name + " at line " + lineNumber.String()
+	case selected > 1:
+		b := e.bookmarks[selected-2]
+		e.RecordJump(e.DataY(), b.index)
+		e.GoToLineNumber(b.index.LineNumber(), c, status, true)
+		return "Jumped to bookmark " + b.name + " at line " + b.index.LineNumber().String()
+	}
+	return ""
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/xyproto/vt100"
@@ -81,18 +80,45 @@ func (e *Editor) exportAdoc(c *vt100.Canvas, manFilename string) error {
 	return nil
 }
 
+// goToError moves the cursor to the given error, if it is in the current file, and returns the error message.
+// If the error is in another file, the cursor is not moved and the filename is added to the message.
+func (e *Editor) goToError(c *vt100.Canvas, status *StatusBar, entry QuickfixEntry, absFilename string) string {
+	if entry.filename != "" && entry.filename != absFilename {
+		return "In " + filepath.Base(entry.filename) + ": " + entry.message
+	}
+	if entry.line > 0 {
+		// Go to Y:X, and let ctrl-l ↓ continue from this error
+		e.RecordJump(e.DataY(), LineNumber(entry.line).LineIndex())
+		e.redraw = e.GoTo(LineNumber(entry.line).LineIndex(), c, status)
+		e.redrawCursor = e.redraw
+		if entry.col > 0 {
+			e.goToColumn(c, entry.col)
+			e.Center(c)
+		}
+		quickfix.Select(quickfix.Index(entry))
+	}
+	return entry.message
+}
+
 // BuildOrExport will try to build the source code or export the document.
 // Returns a status message and then true if an action was performed and another true if compilation/testing worked out.
-func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename string) (string, bool, bool) {
+func (e *Editor) BuildOrExport(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, filename string) (string, bool, bool) {
 	if status != nil {
 		status.Clear(c)
 	}
 
 	ext := filepath.Ext(filename)
 
+	// A build or test command in the project configuration file takes precedence over everything else
+	projectKind := projectBuild
+	if strings.HasSuffix(filename, "_test.go") {
+		projectKind = projectTest
+	}
+	projectCommand, _ := e.ProjectCommand(projectKind, filename)
+
 	// scdoc
 	manFilename := "out.1"
-	if ext == ".scd" || ext == ".scdoc" {
+	if projectCommand == nil && (ext == ".scd" || ext == ".scdoc") {
 		if err := e.exportScdoc(manFilename); err != nil {
 			return err.Error(), true, false
 		}
@@ -100,7 +126,7 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	}
 
 	// asciidoctor
-	if ext == ".adoc" {
+	if projectCommand == nil && ext == ".adoc" {
 		if err := e.exportAdoc(c, manFilename); err != nil {
 			return err.Error(), true, false
 		}
@@ -108,7 +134,7 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	}
 
 	// pandoc
-	if pandocPath := which("pandoc"); e.mode == modeMarkdown && pandocPath != "" {
+	if pandocPath := which("pandoc"); projectCommand == nil && e.mode == modeMarkdown && pandocPath != "" {
 		pdfFilename := strings.Replace(filepath.Base(filename), ".", "_", -1) + ".pdf"
 		// Export to PDF using pandoc. The function handles its own status messages.
 		// TODO: Don't ignore the error
@@ -138,6 +164,7 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	// For building a .jar file that can not be run with "java -jar main.jar" but with "scala main.jar": scalac -jar main.jar Hello.scala
 
 	// TODO: Change the map to not use file extensions, but rather rely on the modes from ftdetect.go
+	//       Until then, commands per mode can be given in the project configuration file.
 
 	// Set up a few variables
 	var (
@@ -147,7 +174,7 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 			exec.Command("cxx"):                                                              {".cpp", ".cc", ".cxx", ".h", ".hpp", ".c++", ".h++", ".c"}, // C++ and C
 			exec.Command("zig", "build"):                                                     {".zig"},                                                    // Zig
 			exec.Command("v", filename):                                                      {".v"},                                                      // V
-			exec.Command("cargo", "build"):                                                   {".rs"},                                                     // Rust
+			exec.Command("cargo", "build", "--message-format=json"):                          {".rs"},                                                     // Rust
 			exec.Command("ghc", "-dynamic", filename):                                        {".hs"},                                                     // Haskell
 			exec.Command("python", "-m", "py_compile", filename):                             {".py"},                                                     // Python, compile to .pyc
 			exec.Command("ocamlopt", "-o", exeFirstName, filename):                           {".ml"},                                                     // OCaml
@@ -163,10 +190,14 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 		}
 	)
 
-	// Check if one of the build commands are applicable for this filename
+	// Check if one of the build commands are applicable for this filename,
+	// unless there is a command in the project configuration file
 	baseFilename := filepath.Base(filename)
-	var foundCommand *exec.Cmd
+	foundCommand := projectCommand
 	for command, exts := range build {
+		if projectCommand != nil {
+			break
+		}
 		for _, ext := range exts {
 			if strings.HasSuffix(filename, ext) || baseFilename == ext {
 				foundCommand = command
@@ -187,6 +218,7 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 		progressStatusMessage = "Building"
 		testingInstead        bool
 		kotlinNative          bool
+		coverageProfile       string // for refreshing the coverage, if it is shown
 	)
 
 	if e.mode == modeHTML || e.mode == modeXML {
@@ -194,7 +226,13 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	}
 
 	// Special per-language considerations
-	if e.mode == modeRust && (!exists("Cargo.toml") && !exists("../Cargo.toml")) {
+	if projectCommand != nil {
+		// Use the command from the project configuration file as it is
+		if projectKind == projectTest {
+			progressStatusMessage = "Testing"
+			testingInstead = true
+		}
+	} else if e.mode == modeRust && (!exists("Cargo.toml") && !exists("../Cargo.toml")) {
 		// Use rustc instead of cargo if Cargo.toml is missing and the extension is .rs
 		if which("rustc") != "" {
 			baseDirName := exeFirstName
@@ -225,7 +263,7 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 			if err == nil { // success
 				sourceCode = string(sourceData)
 			}
-			cmd = exec.Command("zig", "build-exe", "-lc", filename, "--name", baseDirName, "--cache-dir", expandUser("~/.cache/o/zig"))
+			cmd = exec.Command("zig", "build-exe", "-lc", filename, "--name", baseDirName, "--cache-dir", cachePath("zig"))
 			// TODO: Find a better way than this
 			if strings.Contains(sourceCode, "SDL2/SDL.h") {
 				cmd.Args = append(cmd.Args, "-lSDL2")
@@ -240,7 +278,8 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	} else if strings.HasSuffix(filename, "_test.go") {
 		// If it's a test-file, run the test instead of building
 		if which("go") != "" {
-			cmd = exec.Command("go", "test", "-failfast")
+			cmd = exec.Command("go", "test", "-json", "-failfast")
+			coverageProfile = addCoverageProfile(cmd)
 		}
 		progressStatusMessage = "Testing"
 		testingInstead = true
@@ -259,13 +298,25 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	// Save the command in a temporary file
 	saveCommand(cmd)
 
-	// Run the command and fetch the combined output from stderr and stdout.
+	// Run the command and fetch the combined output from stderr and stdout,
+	// while showing a spinner and letting the user cancel the build or look at the output.
 	// Ignore the status code / error, only look at the output.
-	output, err := cmd.CombinedOutput()
-	//panic(cmd.String() + "OUTPUT: " + string(output))
+	output, err := e.RunBuildCommand(c, tty, status, cmd, progressStatusMessage)
+	refreshCoverage(coverageProfile)
+	if err == errBuildCancelled {
+		return progressStatusMessage + " " + err.Error(), true, false
+	}
 
 	outputString := string(bytes.TrimSpace(output))
 
+	// Collect all errors and warnings, so that they can be stepped through afterwards
+	outputDir := cmd.Dir
+	if outputDir == "" {
+		outputDir, _ = os.Getwd()
+	}
+	entries := ParseDiagnostics(string(output), outputDir, e.mode)
+	quickfix.Set(entries)
+
 	if err != nil && len(outputString) == 0 {
 		errorMessage := "Error: no output"
 		// TODO: Also add checks for other executables
@@ -284,13 +335,6 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 
 	// NOTE: Don't do anything with the output and err variables here, let the if below handle it.
 
-	errorMarker := "error:"
-	if testingInstead {
-		errorMarker = ": "
-	} else if e.mode == modeCrystal || e.mode == modeObjectPascal {
-		errorMarker = "Error:"
-	}
-
 	if e.mode == modeZig && bytes.Contains(output, []byte("nrecognized glibc version")) {
 		byteLines := bytes.Split(output, []byte("\n"))
 		fields := strings.Split(string(byteLines[0]), ":")
@@ -301,280 +345,28 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 		return errorMessage, true, false
 	}
 
-	if e.mode == modeGo {
-		switch {
-		case bytes.Contains(output, []byte(": undefined")):
-			errorMarker = "undefined"
-		case bytes.Contains(output, []byte(": error")):
-			errorMarker = "error"
-		case bytes.Count(output, []byte(":")) >= 2:
-			errorMarker = ":"
-		}
-	} else if e.mode == modeOdin {
-		switch {
-		case bytes.Contains(output, []byte(") ")):
-			errorMarker = ") "
-		}
-	} else if err == nil && (e.mode == modeHTML || e.mode == modeXML) {
+	if err == nil && (e.mode == modeHTML || e.mode == modeXML) {
 		return "Success", true, true
 	}
 
-	// Did the command return a non-zero status code, or does the output contain "error:"?
-	if err != nil || bytes.Contains(output, []byte(errorMarker)) { // failed tests also end up here
-
-		// This is not for Go, since the word "error:" may not appear when there are errors
-
+	// Did the command return a non-zero status code, or does the output contain an error?
+	absFilename, _ := filepath.Abs(filename)
+	firstError, foundError := FirstError(entries, absFilename)
+	if err != nil || foundError { // failed tests also end up here
 		errorMessage := "Build error"
 		if testingInstead {
 			errorMessage = "Test error"
 		}
-
-		if e.mode == modePython {
-			if errorLine, errorMessage := ParsePythonError(string(output), filepath.Base(filename)); errorLine != -1 {
-				e.redraw = e.GoTo(LineIndex(errorLine-1), c, status)
-				return "Error: " + errorMessage, true, false
-			}
-		}
-
-		// Find the first error message
-		var (
-			lines               = strings.Split(string(output), "\n")
-			prevLine            string
-			crystalLocationLine string
-		)
-		for _, line := range lines {
-			if e.mode == modeHaskell {
-				if strings.Contains(prevLine, errorMarker) {
-					if errorMessage = strings.TrimSpace(line); strings.HasPrefix(errorMessage, "• ") {
-						errorMessage = string([]rune(errorMessage)[2:])
-						break
-					}
-				}
-			} else if e.mode == modeCrystal {
-				if strings.HasPrefix(line, "Error:") {
-					errorMessage = line[6:]
-					if len(crystalLocationLine) > 0 {
-						break
-					}
-				} else if strings.HasPrefix(line, "In ") {
-					crystalLocationLine = line
-				}
-			} else if e.mode == modeOdin {
-				errorMessage = ""
-				if strings.Contains(line, errorMarker) {
-					whereAndWhat := strings.SplitN(line, errorMarker, 2)
-					where := whereAndWhat[0]
-					errorMessage = whereAndWhat[1]
-					filenameAndLoc := strings.SplitN(where, "(", 2)
-					errorFilename := filenameAndLoc[0]
-					baseErrorFilename := filepath.Base(errorFilename)
-					loc := filenameAndLoc[1]
-					locCol := strings.SplitN(loc, ":", 2)
-					lineNumberString := locCol[0]
-					lineColumnString := locCol[1]
-
-					// Move to (x, y), line number first and then column number
-					if i, err := strconv.Atoi(lineNumberString); err == nil {
-						foundY := LineIndex(i - 1)
-						e.redraw = e.GoTo(foundY, c, status)
-						e.redrawCursor = e.redraw
-						if x, err := strconv.Atoi(lineColumnString); err == nil { // no error
-							foundX := x - 1
-							tabs := strings.Count(e.Line(foundY), "\t")
-							e.pos.sx = foundX + (tabs * (e.tabs.spacesPerTab - 1))
-							e.Center(c)
-						}
-					}
-
-					// Return the error message
-					if baseErrorFilename != baseFilename {
-						return "In " + baseErrorFilename + ": " + errorMessage, true, false
-					}
-					return errorMessage, true, false
-				}
-			} else if e.mode == modeObjectPascal {
-				errorMessage = ""
-				if strings.Contains(line, " Error: ") {
-					pos := strings.Index(line, " Error: ")
-					errorMessage = line[pos+8:]
-				} else if strings.Contains(line, " Fatal: ") {
-					pos := strings.Index(line, " Fatal: ")
-					errorMessage = line[pos+8:]
-				}
-				if len(errorMessage) > 0 {
-					parts := strings.SplitN(line, "(", 2)
-					errorFilename, rest := parts[0], parts[1]
-					baseErrorFilename := filepath.Base(errorFilename)
-					parts = strings.SplitN(rest, ",", 2)
-					lineNumberString, rest := parts[0], parts[1]
-					parts = strings.SplitN(rest, ")", 2)
-					lineColumnString, rest := parts[0], parts[1]
-					errorMessage = rest
-
-					// Move to (x, y), line number first and then column number
-					if i, err := strconv.Atoi(lineNumberString); err == nil {
-						foundY := LineIndex(i - 1)
-						e.redraw = e.GoTo(foundY, c, status)
-						e.redrawCursor = e.redraw
-						if x, err := strconv.Atoi(lineColumnString); err == nil { // no error
-							foundX := x - 1
-							tabs := strings.Count(e.Line(foundY), "\t")
-							e.pos.sx = foundX + (tabs * (e.tabs.spacesPerTab - 1))
-							e.Center(c)
-						}
-					}
-
-					// Return the error message
-					if baseErrorFilename != baseFilename {
-						return "In " + baseErrorFilename + ": " + errorMessage, true, false
-					}
-					return errorMessage, true, false
-				}
-			} else if e.mode == modeLua {
-				if strings.Contains(line, " error near ") && strings.Count(line, ":") >= 3 {
-					parts := strings.SplitN(line, ":", 4)
-					errorMessage = parts[3]
-
-					if i, err := strconv.Atoi(parts[2]); err == nil {
-						foundY := LineIndex(i - 1)
-						e.redraw = e.GoTo(foundY, c, status)
-						e.redrawCursor = e.redraw
-					}
-
-					baseErrorFilename := filepath.Base(parts[1])
-					if baseErrorFilename != baseFilename {
-						return "In " + baseErrorFilename + ": " + errorMessage, true, false
-					}
-					return errorMessage, true, false
-				}
-				break
-			} else if e.mode == modeGo && errorMarker == ":" && strings.Count(line, ":") >= 2 {
-				parts := strings.SplitN(line, ":", 2)
-				errorMessage = strings.Join(parts[2:], ":")
-				break
-			} else if strings.Contains(line, errorMarker) {
-				parts := strings.SplitN(line, errorMarker, 2)
-				if errorMarker == "undefined" {
-					errorMessage = errorMarker + strings.TrimSpace(parts[1])
-				} else {
-					errorMessage = strings.TrimSpace(parts[1])
-				}
-				if testingInstead {
-					e.redrawCursor = true
-				}
-				break
-			}
-			prevLine = line
+		if foundError {
+			errorMessage = e.goToError(c, status, firstError, absFilename)
 		}
-
 		if testingInstead {
-			errorMessage = "Test failed: " + errorMessage
-			return errorMessage, true, false
+			return "Test failed: " + errorMessage, true, false
 		}
-
-		if e.mode == modeCrystal {
-			// Crystal has the location on a different line from the error message
-			fields := strings.Split(crystalLocationLine, ":")
-			if len(fields) != 3 {
-				return errorMessage, true, false
-			}
-			if y, err := strconv.Atoi(fields[1]); err == nil { // no error
-
-				foundY := LineIndex(y - 1)
-				e.redraw = e.GoTo(foundY, c, status)
-				e.redrawCursor = e.redraw
-
-				if x, err := strconv.Atoi(fields[2]); err == nil { // no error
-					foundX := x - 1
-					tabs := strings.Count(e.Line(foundY), "\t")
-					e.pos.sx = foundX + (tabs * (e.tabs.spacesPerTab - 1))
-					e.Center(c)
-				}
-
-			}
+		if foundError {
 			return errorMessage, true, false
 		}
-
-		// NOTE: Don't return here even if errorMessage contains an error message
-
-		// Analyze all lines
-		for i, line := range lines {
-			// Go, C++, Haskell, Kotlin and more
-			if strings.Count(line, ":") >= 3 && (strings.Contains(line, "error:") || strings.Contains(line, errorMarker)) {
-				fields := strings.SplitN(line, ":", 4)
-				baseErrorFilename := filepath.Base(fields[0])
-				// Check if the filenames are matching, or if the error is in a different file
-				if baseErrorFilename != baseFilename {
-					return "In " + baseErrorFilename + ": " + strings.TrimSpace(fields[3]), true, false
-				}
-				// Go to Y:X, if available
-				var foundY LineIndex
-				if y, err := strconv.Atoi(fields[1]); err == nil { // no error
-					foundY = LineIndex(y - 1)
-					e.redraw = e.GoTo(foundY, c, status)
-					e.redrawCursor = e.redraw
-					foundX := -1
-					if x, err := strconv.Atoi(fields[2]); err == nil { // no error
-						foundX = x - 1
-					}
-					if foundX != -1 {
-
-						tabs := strings.Count(e.Line(foundY), "\t")
-						e.pos.sx = foundX + (tabs * (e.tabs.spacesPerTab - 1))
-						e.Center(c)
-
-						// Use the error message as the status message
-						if len(fields) >= 4 {
-							if ext != ".hs" {
-								return strings.Join(fields[3:], " "), true, false
-							}
-							return errorMessage, true, false
-						}
-					}
-				}
-				return errorMessage, true, false
-			} else if (i > 0) && i < (len(lines)-1) {
-				// Rust
-				if msgLine := lines[i-1]; strings.Contains(line, " --> ") && strings.Count(line, ":") == 2 && strings.Count(msgLine, ":") >= 1 {
-					errorFields := strings.SplitN(msgLine, ":", 2)                  // Already checked for 2 colons
-					errorMessage := strings.TrimSpace(errorFields[1])               // There will always be 3 elements in errorFields, so [1] is fine
-					locationFields := strings.SplitN(line, ":", 3)                  // Already checked for 2 colons in line
-					filenameFields := strings.SplitN(locationFields[0], " --> ", 2) // [0] is fine, already checked for " ---> "
-					errorFilename := strings.TrimSpace(filenameFields[1])           // [1] is fine
-					if filename != errorFilename {
-						return "Error in " + errorFilename + ": " + errorMessage, true, false
-					}
-					errorY := locationFields[1]
-					errorX := locationFields[2]
-
-					// Go to Y:X, if available
-					var foundY LineIndex
-					if y, err := strconv.Atoi(errorY); err == nil { // no error
-						foundY = LineIndex(y - 1)
-						e.redraw = e.GoTo(foundY, c, status)
-						e.redrawCursor = e.redraw
-						foundX := -1
-						if x, err := strconv.Atoi(errorX); err == nil { // no error
-							foundX = x - 1
-						}
-						if foundX != -1 {
-							tabs := strings.Count(e.Line(foundY), "\t")
-							e.pos.sx = foundX + (tabs * (e.tabs.spacesPerTab - 1))
-							e.Center(c)
-							// Use the error message as the status message
-							if errorMessage != "" {
-								return errorMessage, true, false
-							}
-						}
-					}
-					e.redrawCursor = true
-					// Nope, just the error message
-					//return errorMessage, true, false
-				}
-			}
-		}
-	}
-	if e.mode == modePython {
+	} else if e.mode == modePython {
 		return "Syntax OK", true, true
 	}
 
@@ -582,6 +374,10 @@ func (e *Editor) BuildOrExport(c *vt100.Canvas, status *StatusBar, filename stri
 	if err != nil && len(outputString) > 0 {
 		outputLines := strings.Split(outputString, "\n")
 		lastLine := outputLines[len(outputLines)-1]
+		// Skip the JSON from go test -json or cargo build --message-format=json
+		for i := len(outputLines) - 2; i >= 0 && strings.HasPrefix(lastLine, "{"); i-- {
+			lastLine = outputLines[i]
+		}
 		return "Error: " + lastLine, false, false
 	}
 
@@ -13,7 +13,7 @@ func ExampleEditor_BuildOrExport_goError() {
 	os.Chdir("test")
 	// The rename is so that "err.go" is not picked up by the CI tests
 	os.Rename("err_go", "err.go")
-	s, performedAction, compiledOK := e.BuildOrExport(nil, nil, "err.go")
+	s, performedAction, compiledOK := e.BuildOrExport(nil, nil, nil, "err.go")
 	os.Rename("err.go", "err_go")
 	os.Chdir("..")
 	fmt.Printf("%s [performed action: %v] [compiled OK: %v]\n", s, performedAction, compiledOK)
@@ -26,7 +26,7 @@ func TestBuildOrExport(t *testing.T) {
 	e.mode, _ = detectEditorMode("err.rs")
 
 	os.Chdir("test")
-	_, performedAction, compiledOK := e.BuildOrExport(nil, nil, "err.rs")
+	_, performedAction, compiledOK := e.BuildOrExport(nil, nil, nil, "err.rs")
 	os.Chdir("..")
 
 	// fmt.Printf("%s [performed action: %v] [compiled OK: %v]\n", s, performedAction, compiledOK)
@@ -59,7 +59,7 @@ func ExampleEditor_BuildOrExport_goTest() {
 	os.Chdir("test")
 	// The rename is so that "err.go" is not picked up by the CI tests
 	os.Rename("err_test_go", "err_test.go")
-	s, performedAction, compiledOK := e.BuildOrExport(nil, nil, "err_test.go")
+	s, performedAction, compiledOK := e.BuildOrExport(nil, nil, nil, "err_test.go")
 	os.Rename("err_test.go", "err_test_go")
 	os.Chdir("..")
 	fmt.Printf("%s [performed action: %v] [compiled OK: %v]\n", s, performedAction, compiledOK)
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xyproto/vt100"
+)
+
+var (
+	buildOutputForeground      = vt100.White
+	buildOutputBackground      = vt100.BackgroundBlack
+	buildOutputTitleForeground = vt100.Black
+	buildOutputTitleBackground = vt100.BackgroundCyan
+)
+
+// buildSpinner is shown in the status bar while a build is running
+var buildSpinner = []rune(`|/-\`)
+
+var errBuildCancelled = errors.New("cancelled")
+
+// How long to keep collecting output after the build process has exited,
+// in case a child process that is still running keeps the output open
+const buildOutputDelay = 500 * time.Millisecond
+
+// BuildOutput is the combined output of the current or the last build.
+// It is written to by the running process while it can be read by the output pane.
+type BuildOutput struct {
+	buf bytes.Buffer
+	mut *sync.RWMutex
+}
+
+// buildOutput is the output of the current or the last build
+var buildOutput = &BuildOutput{mut: &sync.RWMutex{}}
+
+// Write appends to the output, so that a BuildOutput can be used as the stdout and stderr of a command
+func (bo *BuildOutput) Write(p []byte) (int, error) {
+	bo.mut.Lock()
+	defer bo.mut.Unlock()
+	return bo.buf.Write(p)
+}
+
+// Reset removes all output
+func (bo *BuildOutput) Reset() {
+	bo.mut.Lock()
+	defer bo.mut.Unlock()
+	bo.buf.Reset()
+}
+
+// Bytes returns a copy of the output so far
+func (bo *BuildOutput) Bytes() []byte {
+	bo.mut.RLock()
+	defer bo.mut.RUnlock()
+	return append([]byte{}, bo.buf.Bytes()...)
+}
+
+// Lines returns the output so far as lines that are meant to be read by humans.
+// For the JSON output of go test and cargo, the text output of the test or compiler is used.
+func (bo *BuildOutput) Lines() []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(bo.Bytes()), "\n"), "\n") {
+		if !strings.HasPrefix(line, "{\"") || !json.Valid([]byte(line)) {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+			continue
+		}
+		var obj struct {
+			goTestEvent
+			Message *rustcDiagnostic `json:"message"`
+		}
+		if json.Unmarshal([]byte(line), &obj) != nil {
+			lines = append(lines, line)
+			continue
+		}
+		if obj.Output != "" {
+			lines = append(lines, strings.Split(strings.TrimSuffix(obj.Output, "\n"), "\n")...)
+		} else if obj.Message != nil && obj.Message.Rendered != "" {
+			lines = append(lines, strings.Split(strings.TrimSuffix(obj.Message.Rendered, "\n"), "\n")...)
+		}
+	}
+	return lines
+}
+
+// drawBuildOutput draws a pane with the given lines over the lower half of the canvas,
+// starting at the given offset, or with the last lines visible if the offset is -1
+func drawBuildOutput(c *vt100.Canvas, title string, lines []string, offset int) {
+	w, h := int(c.W()), int(c.H())/2
+	top := int(c.H()) - 1 - h // leave room for the status bar
+	if offset < 0 || offset > len(lines)-(h-1) {
+		offset = len(lines) - (h - 1)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	for row := 0; row < h; row++ {
+		fg, bg := buildOutputForeground, buildOutputBackground
+		var line []rune
+		if row == 0 {
+			fg, bg = buildOutputTitleForeground, buildOutputTitleBackground
+			line = []rune(" " + title)
+		} else if i := offset + row - 1; i < len(lines) {
+			line = []rune(" " + strings.Replace(lines[i], "\t", "    ", -1))
+		}
+		for x := 0; x < w; x++ {
+			r := ' '
+			if x < len(line) {
+				r = line[x]
+			}
+			c.WriteRuneB(uint(x), uint(top+row), fg, bg, r)
+		}
+	}
+	c.Draw()
+}
+
+// scrollBuildOutput returns the new offset after scrolling the build output pane with the given key.
+// An offset of -1 means that the pane follows the end of the output.
+func scrollBuildOutput(c *vt100.Canvas, key string, lines []string, offset int) int {
+	h := int(c.H())/2 - 1
+	last := len(lines) - h
+	if last < 0 {
+		last = 0
+	}
+	if offset < 0 || offset > last {
+		offset = last
+	}
+	switch key {
+	case "↑":
+		offset--
+	case "↓":
+		offset++
+	case "c:21": // ctrl-u, page up
+		offset -= h
+	case "c:4": // ctrl-d, page down
+		offset += h
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= last {
+		return -1
+	}
+	return offset
+}
+
+// How often the spinner in the status bar is updated while a build is running
+const buildSpinnerInterval = 100 * time.Millisecond
+
+// Builder keeps track of the build, test or run job that runs in the background.
+// The main loop holds the lock while handling a keypress, and so does a job, except while it
+// waits for its command to finish, so that the editor can be used while the command is running.
+type Builder struct {
+	mut       *sync.Mutex
+	lock      sync.Locker     // the lock that the main loop holds while handling a keypress
+	wg        *sync.WaitGroup // for waiting until the current job is done
+	busy      bool            // is there a job running?
+	cmd       *exec.Cmd       // the command that is running right now, if any
+	title     string          // a short description of the command that is running
+	start     time.Time       // when the command was started
+	cancelled bool            // has the command been cancelled?
+	showPane  bool            // show the output of the command while it is running?
+	output    string          // the title of the output pane to show when the next key is pressed, if any
+}
+
+// builder runs the build, test or run jobs in the background
+var builder = &Builder{mut: &sync.Mutex{}, wg: &sync.WaitGroup{}}
+
+// Busy checks if a job is running
+func (b *Builder) Busy() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.busy
+}
+
+// Go runs the given job on a goroutine, once the main loop has released the lock.
+// Returns false if another job is already running.
+func (b *Builder) Go(c *vt100.Canvas, e *Editor, job func()) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.busy {
+		return false
+	}
+	b.busy = true
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		if b.lock != nil {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+		}
+		job()
+		b.mut.Lock()
+		b.busy = false
+		b.showPane = false
+		b.mut.Unlock()
+		if c != nil {
+			if e.redraw {
+				e.DrawLines(c, true, false)
+				e.redraw = false
+			}
+			if title := b.pendingOutput(); title != "" {
+				drawBuildOutput(c, title+" (↑ and ↓ to scroll)", buildOutput.Lines(), -1)
+			}
+			vt100.SetXY(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()))
+		}
+	}()
+	return true
+}
+
+// Cancel kills the process group of the command that is running, if any
+func (b *Builder) Cancel() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.cmd != nil && !b.cancelled {
+		syscall.Kill(-b.cmd.Process.Pid, syscall.SIGKILL)
+		b.cancelled = true
+	}
+}
+
+// Stop cancels the command that is running, if any, and waits for the job to finish.
+// The main loop must not hold the lock when calling this.
+func (b *Builder) Stop() {
+	b.Cancel()
+	b.wg.Wait()
+}
+
+// TogglePane shows or hides the output of the command that is running
+func (b *Builder) TogglePane() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.showPane = !b.showPane
+}
+
+// PaneShown checks if the output of the command that is running is shown
+func (b *Builder) PaneShown() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.showPane
+}
+
+// ShowOutput asks for the output pane with the given title to be shown when the job is done.
+// It can then be scrolled or closed when the next key is pressed.
+func (b *Builder) ShowOutput(title string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.output = title
+}
+
+// pendingOutput returns the title of the output pane that should be shown, if any
+func (b *Builder) pendingOutput() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.output
+}
+
+// TakeOutput returns the title of the output pane that is shown, if any, and forgets about it
+func (b *Builder) TakeOutput() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	title := b.output
+	b.output = ""
+	return title
+}
+
+// Watch lets the editor be used while a command is running, by having the jobs release the given lock
+// while waiting, and then updates the spinner in the status bar, and the output pane if it is shown,
+// until quit is closed
+func (b *Builder) Watch(c *vt100.Canvas, e *Editor, status *StatusBar, lock sync.Locker, quit chan bool) {
+	b.lock = lock
+	go func() {
+		ticker := time.NewTicker(buildSpinnerInterval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			b.mut.Lock()
+			running, title, start, showPane := b.cmd != nil, b.title, b.start, b.showPane
+			b.mut.Unlock()
+			if !running {
+				continue
+			}
+			lock.Lock()
+			if showPane {
+				drawBuildOutput(c, title, buildOutput.Lines(), -1)
+			}
+			status.ClearAll(c)
+			status.SetMessage(fmt.Sprintf("%s %c %.1fs (ctrl-space to cancel)", title, buildSpinner[frame%len(buildSpinner)], time.Since(start).Seconds()))
+			status.ShowNoTimeout(c, e)
+			vt100.SetXY(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()))
+			lock.Unlock()
+		}
+	}()
+}
+
+// RunInBackground runs the given job with builder.Go, and then shows the message or the error
+// that it returns in the status bar
+func (e *Editor) RunInBackground(c *vt100.Canvas, status *StatusBar, job func() (string, error)) {
+	started := builder.Go(c, e, func() {
+		msg, err := job()
+		status.ClearAll(c)
+		if err != nil {
+			status.SetErrorMessage(err.Error())
+		} else {
+			status.SetMessage(msg)
+		}
+		status.ShowNoTimeout(c, e)
+	})
+	if !started {
+		status.ClearAll(c)
+		status.SetErrorMessage("Another command is running, press ctrl-space to cancel it")
+		status.Show(c, e)
+	}
+}
+
+// RunBuildCommand starts the given command and waits for it on a goroutine, while the combined output
+// is collected in buildOutput. When called from a job started with builder.Go, the editor can be used
+// while waiting, the status bar shows a spinner and the elapsed time, and pressing ctrl-space kills
+// the process group. Otherwise, it just waits for the command.
+func (e *Editor) RunBuildCommand(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, cmd *exec.Cmd, progressStatusMessage string) ([]byte, error) {
+	buildOutput.Reset()
+	// The output is collected from a pipe that is read here, instead of letting cmd.Wait wait for all
+	// output, since cmd.Wait would then hang for as long as any child process keeps the output open
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	// Start the command in a new process group, so that all its child processes can be killed
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	// The command has its own copy of the writing end of the pipe
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(buildOutput, pr)
+		close(copied)
+	}()
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		// Collect the rest of the output, but stop waiting for it after a short while
+		select {
+		case <-copied:
+		case <-time.After(buildOutputDelay):
+		}
+		pr.Close()
+		<-copied
+		done <- err
+	}()
+	if !builder.Busy() {
+		err := <-done
+		return buildOutput.Bytes(), err
+	}
+
+	builder.mut.Lock()
+	builder.cmd, builder.title, builder.start, builder.cancelled = cmd, progressStatusMessage, time.Now(), false
+	lock := builder.lock
+	builder.mut.Unlock()
+
+	// Let the main loop handle keypresses while waiting
+	if lock != nil {
+		lock.Unlock()
+	}
+	err = <-done
+	if lock != nil {
+		lock.Lock()
+	}
+
+	builder.mut.Lock()
+	if builder.cancelled {
+		err = errBuildCancelled
+	}
+	if builder.showPane {
+		e.redraw = true
+	}
+	builder.cmd, builder.cancelled = nil, false
+	builder.mut.Unlock()
+	if status != nil {
+		status.ClearAll(c)
+	}
+	return buildOutput.Bytes(), err
+}
+
+// ShowBuildOutput shows the output of the last build or run in a read-only pane with the given title,
+// that can be scrolled with the arrow keys, ctrl-u and ctrl-d, until esc, q or return is pressed.
+// If a key is given, it is handled before waiting for the next one.
+func (e *Editor) ShowBuildOutput(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, title, key string) {
+	lines := buildOutput.Lines()
+	offset := -1 // start at the end of the output
+	if key == "" {
+		offset = 0
+	}
+	for {
+		if key == "" {
+			drawBuildOutput(c, fmt.Sprintf("%s (%d lines)", title, len(lines)), lines, offset)
+			status.ClearAll(c)
+			status.SetMessage("Press esc to close")
+			status.ShowNoTimeout(c, e)
+			key = tty.String()
+		}
+		switch key {
+		case "c:27", "q", "c:13", "c:17": // esc, q, return or ctrl-q
+			status.ClearAll(c)
+			e.redraw = true
+			e.redrawCursor = true
+			return
+		default:
+			offset = scrollBuildOutput(c, key, lines, offset)
+		}
+		key = ""
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBuildOutputLines(t *testing.T) {
+	bo := &BuildOutput{mut: &sync.RWMutex{}}
+	bo.Write([]byte(`# plain text
+{"Action":"output","Package":"p","Test":"TestA","Output":"    a_test.go:8: expected 5\n"}
+{"Action":"fail","Package":"p","Test":"TestA","Elapsed":0}
+{"reason":"compiler-message","message":{"rendered":"error: oops\n --> src/main.rs:3:5\n","level":"error","message":"oops","spans":[]}}
+{"reason":"build-finished","success":false}
+`))
+	expected := []string{"# plain text", "    a_test.go:8: expected 5", "error: oops", " --> src/main.rs:3:5"}
+	if lines := bo.Lines(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+func TestRunBuildCommand(t *testing.T) {
+	e := NewSimpleEditor(80)
+	output, err := e.RunBuildCommand(nil, nil, nil, exec.Command("sh", "-c", "echo out; echo err >&2; exit 1"), "Building")
+	if err == nil {
+		t.Error("expected the non-zero exit status to be an error")
+	}
+	if string(output) != "out\nerr\n" || string(buildOutput.Bytes()) != "out\nerr\n" {
+		t.Errorf("expected the combined output, got %q", output)
+	}
+}
+
+func TestRunBuildCommandChildProcess(t *testing.T) {
+	// A child process that keeps running keeps the output open, but should not hold up the build
+	cmd := exec.Command("sh", "-c", "sleep 5 & echo done")
+	start := time.Now()
+	e := NewSimpleEditor(80)
+	output, err := e.RunBuildCommand(nil, nil, nil, cmd, "Building")
+	defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(output) != "done\n" {
+		t.Errorf("expected the output of the command, got %q", output)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected the build to be done when the command is done, but it took %v", elapsed)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	// The lock that the main loop holds while handling a keypress
+	mut := &sync.Mutex{}
+	defer func(lock sync.Locker) { builder.lock = lock }(builder.lock)
+	builder.lock = mut
+
+	e := NewSimpleEditor(80)
+	result := make(chan error, 1)
+	mut.Lock()
+	if !builder.Go(nil, e, func() {
+		_, err := e.RunBuildCommand(nil, nil, nil, exec.Command("sleep", "10"), "Sleeping")
+		result <- err
+	}) {
+		t.Fatal("could not start the job")
+	}
+	if builder.Go(nil, e, func() {}) {
+		t.Error("expected only one job at a time")
+	}
+	mut.Unlock()
+
+	// Wait for the command to start
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		builder.mut.Lock()
+		running := builder.cmd != nil
+		builder.mut.Unlock()
+		if running {
+			break
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Fatal("the command did not start")
+		}
+	}
+
+	// The job should not hold the lock while waiting for the command
+	mut.Lock()
+	builder.Cancel()
+	mut.Unlock()
+	if err := <-result; err != errBuildCancelled {
+		t.Errorf("expected the command to be cancelled, got %v", err)
+	}
+	builder.Stop()
+	if builder.Busy() {
+		t.Error("expected the job to be done")
+	}
+}
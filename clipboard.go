@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/xyproto/env"
+	"github.com/xyproto/vt100"
+)
+
+const (
+	// The clipboard file, for when there is no other way to copy and paste
+	clipboardFilename = "clipboard.txt" // in the cache directory
+
+	// The timeout used when reading keys in the main loop
+	keyTimeout = 2 * time.Millisecond
+
+	// How long to wait for the terminal to reply with the clipboard contents
+	osc52ReplyTimeout = 200 * time.Millisecond
+)
+
+// Which clipboard backend to use: "auto", "system", "osc52" or "file"
+var clipboardBackend = env.Str("O_CLIPBOARD", "auto")
+
+// Clipboard is a place where text can be copied to and pasted from
+type Clipboard interface {
+	Name() string
+	WriteAll(s string) error
+	ReadAll() (string, error)
+}
+
+// SystemClipboard uses xclip, xsel or wl-clipboard (or pbcopy on macOS)
+type SystemClipboard struct{}
+
+// Name returns the name of this clipboard backend
+func (sc *SystemClipboard) Name() string {
+	return "system"
+}
+
+// WriteAll places the given string in the system clipboard
+func (sc *SystemClipboard) WriteAll(s string) error {
+	return clipboard.WriteAll(s)
+}
+
+// ReadAll returns the contents of the system clipboard
+func (sc *SystemClipboard) ReadAll() (string, error) {
+	return clipboard.ReadAll()
+}
+
+// FileClipboard keeps the copied text in a file that only the current user can read
+type FileClipboard struct {
+	filename string
+}
+
+// Name returns the name of this clipboard backend
+func (fc *FileClipboard) Name() string {
+	return "file"
+}
+
+// WriteAll writes the given string to the clipboard file
+func (fc *FileClipboard) WriteAll(s string) error {
+	if err := os.MkdirAll(filepath.Dir(fc.filename), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(fc.filename, []byte(s), 0600, -1, -1)
+}
+
+// ReadAll reads the clipboard file
+func (fc *FileClipboard) ReadAll() (string, error) {
+	data, err := ioutil.ReadFile(fc.filename)
+	return string(data), err
+}
+
+// OSC52Clipboard sends the copied text to the terminal emulator with the OSC 52 escape sequence,
+// which also works over SSH. Reading the clipboard is only possible if the terminal emulator
+// allows it, so the copied text is also kept in a file, which is used as a fallback for pasting.
+type OSC52Clipboard struct {
+	tty      osc52Terminal
+	fallback Clipboard
+	noReply  bool // the terminal has not replied to a clipboard query, don't ask again
+}
+
+// osc52Terminal is the terminal that the clipboard contents are queried from
+type osc52Terminal interface {
+	RawMode()
+	Restore()
+	SetTimeout(d time.Duration)
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+}
+
+// ttyTerminal reads from the given TTY and writes to stdout, like the rest of the editor
+type ttyTerminal struct {
+	*vt100.TTY
+}
+
+// Read reads from the TTY, waiting for as long as the current timeout
+func (tt ttyTerminal) Read(p []byte) (int, error) {
+	return tt.Term().Read(p)
+}
+
+// Write writes to stdout
+func (tt ttyTerminal) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// Name returns the name of this clipboard backend
+func (oc *OSC52Clipboard) Name() string {
+	return "osc52"
+}
+
+// osc52Wrap wraps the given escape sequence so that it passes through tmux or GNU screen, if needed
+func osc52Wrap(seq string) string {
+	if hasE("TMUX") {
+		return "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) + "\x1b\\"
+	} else if strings.HasPrefix(env.Str("TERM"), "screen") {
+		return "\x1bP" + seq + "\x1b\\"
+	}
+	return seq
+}
+
+// osc52Sequence returns the escape sequence that places the given string in the clipboard
+func osc52Sequence(s string) string {
+	return osc52Wrap("\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(s)) + "\a")
+}
+
+// parseOSC52Reply extracts the clipboard contents from a reply to an OSC 52 query
+func parseOSC52Reply(reply []byte) (string, error) {
+	start := bytes.Index(reply, []byte("\x1b]52;"))
+	if start == -1 {
+		return "", errors.New("not an OSC 52 reply")
+	}
+	reply = reply[start+5:]
+	// Skip the selection parameter, like "c;"
+	semicolon := bytes.IndexByte(reply, ';')
+	if semicolon == -1 {
+		return "", errors.New("incomplete OSC 52 reply")
+	}
+	reply = reply[semicolon+1:]
+	// The reply is terminated by either BEL or ST
+	end := bytes.IndexByte(reply, '\a')
+	if end == -1 {
+		end = bytes.Index(reply, []byte("\x1b\\"))
+	}
+	if end == -1 {
+		return "", errors.New("incomplete OSC 52 reply")
+	}
+	data, err := base64.StdEncoding.DecodeString(string(reply[:end]))
+	return string(data), err
+}
+
+// WriteAll sends the given string to the terminal emulator, and also writes it to the fallback clipboard
+func (oc *OSC52Clipboard) WriteAll(s string) error {
+	fmt.Print(osc52Sequence(s))
+	return oc.fallback.WriteAll(s)
+}
+
+// query asks the terminal emulator for the clipboard contents, and waits a short while for the reply.
+// The terminal is in raw mode while waiting, so that the reply is neither line buffered nor echoed.
+func (oc *OSC52Clipboard) query() (string, error) {
+	t := oc.tty
+	t.RawMode()
+	// The timeout must be set after entering raw mode, since raw mode makes reads block
+	t.SetTimeout(osc52ReplyTimeout)
+	defer func() {
+		t.Restore()
+		t.SetTimeout(keyTimeout)
+	}()
+	if _, err := t.Write([]byte(osc52Wrap("\x1b]52;c;?\a"))); err != nil {
+		return "", err
+	}
+	var (
+		reply []byte
+		buf   = make([]byte, 4096)
+	)
+	for {
+		n, err := t.Read(buf)
+		if n > 0 {
+			reply = append(reply, buf[:n]...)
+			if s, err := parseOSC52Reply(reply); err == nil {
+				return s, nil
+			}
+		}
+		if n == 0 || err != nil {
+			return "", errors.New("no reply from the terminal emulator")
+		}
+	}
+}
+
+// ReadAll asks the terminal emulator for the clipboard contents.
+// If the terminal emulator does not reply, the fallback clipboard is used.
+func (oc *OSC52Clipboard) ReadAll() (string, error) {
+	if oc.tty != nil && !oc.noReply {
+		if s, err := oc.query(); err == nil { // success
+			return s, nil
+		}
+		oc.noReply = true
+	}
+	return oc.fallback.ReadAll()
+}
+
+// NewClipboard returns the clipboard backend that is selected with O_CLIPBOARD,
+// or picks one based on the environment if O_CLIPBOARD is not set or "auto".
+func NewClipboard(tty *vt100.TTY) Clipboard {
+	fileClipboard := &FileClipboard{cachePath(clipboardFilename)}
+	osc52Clipboard := &OSC52Clipboard{nil, fileClipboard, false}
+	if tty != nil {
+		osc52Clipboard.tty = ttyTerminal{tty}
+	}
+	switch strings.ToLower(clipboardBackend) {
+	case "system":
+		return &SystemClipboard{}
+	case "osc52":
+		return osc52Clipboard
+	case "file":
+		return fileClipboard
+	}
+	// Over SSH, the system clipboard is on the remote host, so use the terminal emulator
+	if hasE("SSH_CONNECTION") || hasE("SSH_TTY") {
+		return osc52Clipboard
+	}
+	// Use the system clipboard if the right utilities are available
+	if (hasE("WAYLAND_DISPLAY") && which("wl-copy") != "") || (hasE("DISPLAY") && (which("xclip") != "" || which("xsel") != "")) || which("pbcopy") != "" {
+		return &SystemClipboard{}
+	}
+	// The Linux console does not support OSC 52
+	if term := env.Str("TERM"); term != "" && term != "linux" && term != "dumb" {
+		return osc52Clipboard
+	}
+	return fileClipboard
+}
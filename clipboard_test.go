@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOSC52(t *testing.T) {
+	const s = "hello\nworld"
+	seq := osc52Sequence(s)
+	if osc52Wrap("") != "" {
+		t.Skip("the sequence is wrapped when running in tmux or screen")
+	}
+	// The terminal emulator replies in the same format, terminated by either BEL or ST
+	for _, reply := range []string{seq, "\x1b]52;c;aGVsbG8Kd29ybGQ=\x1b\\"} {
+		got, err := parseOSC52Reply([]byte(reply))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != s {
+			t.Errorf("expected %q, got %q", s, got)
+		}
+	}
+	if _, err := parseOSC52Reply([]byte("\x1b]52;c;aGVsbG8K")); err == nil {
+		t.Error("an unterminated reply should not be accepted")
+	}
+}
+
+// fakeTerminal replies to an OSC 52 query with the given chunks, but only while in raw mode
+type fakeTerminal struct {
+	chunks  []string
+	raw     bool
+	timeout time.Duration
+	written bytes.Buffer
+}
+
+func (ft *fakeTerminal) RawMode()                   { ft.raw = true }
+func (ft *fakeTerminal) Restore()                   { ft.raw = false }
+func (ft *fakeTerminal) SetTimeout(d time.Duration) { ft.timeout = d }
+func (ft *fakeTerminal) Write(p []byte) (int, error) {
+	return ft.written.Write(p)
+}
+
+func (ft *fakeTerminal) Read(p []byte) (int, error) {
+	if !ft.raw || ft.timeout == 0 || len(ft.chunks) == 0 {
+		// In cooked mode, or without a timeout, a real terminal would block here
+		return 0, nil
+	}
+	n := copy(p, ft.chunks[0])
+	ft.chunks = ft.chunks[1:]
+	return n, nil
+}
+
+func TestOSC52Query(t *testing.T) {
+	// Don't wrap the sequences for tmux or screen
+	defer os.Setenv("TMUX", os.Getenv("TMUX"))
+	defer os.Setenv("TERM", os.Getenv("TERM"))
+	os.Unsetenv("TMUX")
+	os.Setenv("TERM", "xterm")
+	dir, err := ioutil.TempDir("", "o_clipboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fallback := &FileClipboard{filepath.Join(dir, "clipboard.txt")}
+	fallback.WriteAll("from the file")
+
+	// The reply may arrive in several reads
+	ft := &fakeTerminal{chunks: []string{"\x1b]52;c;aGVsbG8K", "d29ybGQ=\a"}}
+	oc := &OSC52Clipboard{ft, fallback, false}
+	s, err := oc.ReadAll()
+	if err != nil || s != "hello\nworld" {
+		t.Errorf("expected the clipboard contents from the terminal, got %q (%v)", s, err)
+	}
+	if ft.written.String() != "\x1b]52;c;?\a" {
+		t.Errorf("expected a clipboard query to be sent, got %q", ft.written.String())
+	}
+	if ft.raw || ft.timeout != keyTimeout {
+		t.Error("expected the terminal to be restored, with the timeout for reading keys")
+	}
+
+	// If the terminal does not reply, the fallback is used, and the terminal is not asked again
+	ft = &fakeTerminal{}
+	oc = &OSC52Clipboard{ft, fallback, false}
+	for i := 0; i < 2; i++ {
+		if s, err := oc.ReadAll(); err != nil || s != "from the file" {
+			t.Errorf("expected the contents of the fallback clipboard, got %q (%v)", s, err)
+		}
+	}
+	if ft.written.String() != "\x1b]52;c;?\a" {
+		t.Errorf("expected a single clipboard query, got %q", ft.written.String())
+	}
+}
@@ -13,12 +13,27 @@ import (
 	"github.com/xyproto/vt100"
 )
 
-const lastCommandFile = "~/.cache/o/last_command.sh"
+const lastCommandFile = "last_command.sh" // in the cache directory
 
-// UserSave saves the file and the location history
-func (e *Editor) UserSave(c *vt100.Canvas, status *StatusBar) {
+// UserSave saves the file and the location history.
+// If the file has been changed on disk by another program, the user is asked what to do,
+// unless tty is nil, in which case the file is not saved.
+func (e *Editor) UserSave(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar) {
 	// Save the file
-	if err := e.Save(c); err != nil {
+	err := e.Save(c)
+	if err == errChangedOnDisk && tty != nil {
+		msg, save := e.ResolveChangedOnDisk(c, tty, status)
+		if !save {
+			status.ClearAll(c)
+			if msg != "" {
+				status.SetMessage(msg)
+				status.Show(c, e)
+			}
+			return
+		}
+		err = e.Save(c)
+	}
+	if err != nil {
 		status.SetErrorMessage(err.Error())
 		status.Show(c, e)
 		return
@@ -29,9 +44,16 @@ func (e *Editor) UserSave(c *vt100.Canvas, status *StatusBar) {
 		e.SaveLocation(absFilename, e.locationHistory)
 	}
 
+	// Let the language server know, if one is running
+	e.LSPDidSave()
+
 	// Status message
 	status.Clear(c)
-	status.SetMessage("Saved " + e.filename)
+	if e.backupErr != nil {
+		status.SetErrorMessage("Saved " + e.filename + ", but could not keep a backup: " + e.backupErr.Error())
+	} else {
+		status.SetMessage("Saved " + e.filename)
+	}
 	status.Show(c, e)
 }
 
@@ -90,7 +112,7 @@ func (a *Actions) Perform(index int) {
 
 // CommandMenu will display a menu with various commands that can be browsed with arrow up and arrow down
 // Also returns the selected menu index (can be -1).
-func (e *Editor) CommandMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, undo *Undo, lastMenuIndex int, forced bool, lk *LockKeeper) int {
+func (e *Editor) CommandMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, undo *Undo, lastMenuIndex int, lk *LockKeeper) int {
 
 	const insertFilename = "include.txt"
 
@@ -127,7 +149,7 @@ func (e *Editor) CommandMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY,
 		[]func(){
 			func() { // save and quit
 				e.clearOnQuit = true
-				e.UserSave(c, status)
+				e.UserSave(c, tty, status)
 				e.quit = true        // indicate that the user wishes to quit
 				e.clearOnQuit = true // clear the terminal after quitting
 			},
@@ -259,23 +281,195 @@ func (e *Editor) CommandMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY,
 		})
 	}
 
-	// Add the unlock menu item
-	// TODO: Detect if the current file is locked first
-	if forced {
-		actions.Add("Unlock if locked", func() {
-			if absFilename, err := e.AbsFilename(); err == nil { // no issues
-				lk.Load()
-				lk.Unlock(absFilename)
-				lk.Save()
+	// Add menu items for running the commands in the project configuration file, if any.
+	// The run command is used by the menu item for running the program, further down.
+	for _, kind := range []string{projectTest, projectLint} {
+		if _, err := e.ProjectCommand(kind, e.filename); err != nil {
+			continue
+		}
+		kind := kind // to be used in the closure
+		actions.Add("Run the "+kind+" command for this project", func() {
+			e.RunInBackground(c, status, func() (string, error) {
+				return e.RunProjectCommand(c, tty, status, kind)
+			})
+		})
+	}
+
+	// Add a menu item for running the test that the cursor is in
+	if _, name, err := e.TestCommandUnderCursor(); err == nil {
+		actions.Add("Run "+name, func() {
+			e.RunInBackground(c, status, func() (string, error) {
+				return e.RunTestUnderCursor(c, tty, status)
+			})
+		})
+	}
+
+	// Add a menu item for running the tests of this Go package with coverage
+	if e.mode == modeGo && which("go") != "" {
+		actions.Add("Run the tests with coverage", func() {
+			e.RunInBackground(c, status, func() (string, error) {
+				return e.RunCoverage(c, tty, status)
+			})
+		})
+	}
+
+	// Add a menu item for showing or hiding the coverage from the last test run with coverage
+	if coverage.Loaded() {
+		title := "Show the test coverage"
+		if coverage.Shown() {
+			title = "Hide the test coverage"
+		}
+		actions.Add(title, func() {
+			coverage.Toggle()
+			e.redraw = true
+			if coverage.Shown() {
+				status.ClearAll(c)
+				status.SetMessage(coverage.Status())
+				status.ShowNoTimeout(c, e)
 			}
 		})
 	}
 
-	// Add the portal menu item
+	// Add a menu item for running the program that was built from this file, or this script
+	if _, name, err := e.RunCommand(e.filename); err == nil {
+		actions.Add("Run "+name, func() {
+			e.RunInBackground(c, status, func() (string, error) {
+				return e.RunProgram(c, tty, status)
+			})
+		})
+	}
+
+	// Add a menu item for showing the output of the last build or run,
+	// or for showing or hiding the output of the build that is running
+	if builder.Busy() {
+		title := "Show the output of the running build"
+		if builder.PaneShown() {
+			title = "Hide the output of the running build"
+		}
+		actions.Add(title, func() {
+			builder.TogglePane()
+			e.redraw = true
+		})
+	} else if len(buildOutput.Bytes()) > 0 {
+		actions.Add("Show the output of the last build or run", func() {
+			e.ShowBuildOutput(c, tty, status, "Output", "")
+		})
+	}
+
+	// Add a menu item for listing the errors and warnings from the last build
+	if n := quickfix.Len(); n > 0 {
+		actions.Add(fmt.Sprintf("List the build errors (%d)", n), func() {
+			if msg := e.QuickfixMenu(tty, c, status, lk); msg != "" {
+				status.ClearAll(c)
+				status.SetMessage(msg)
+				status.ShowNoTimeout(c, e)
+			}
+		})
+	}
+
+	// Add the auto-pairing toggle menu item
+	autoPairText := "Enable auto-pairing of brackets and quotes"
+	if autoPairing {
+		autoPairText = "Disable auto-pairing of brackets and quotes"
+	}
+	actions.Add(autoPairText, func() {
+		autoPairing = !autoPairing
+	})
+
+	// Add the menu item for listing and removing file locks
+	if lk != nil {
+		actions.Add("Show file locks", func() {
+			if msg := e.LocksMenu(c, status, tty, lk); msg != "" {
+				status.ClearAll(c)
+				status.SetMessage(msg)
+				status.Show(c, e)
+			}
+		})
+	}
+
+	// Add the menu items that use the language server, if one is running
+	if e.LSP() != nil {
+		if name := e.IdentifierAtCursor(); name != "" {
+			actions.Add("Show information about "+name, func() {
+				status.ClearAll(c)
+				if text, err := e.LSPHover(); err != nil {
+					status.SetErrorMessage(err.Error())
+				} else if text = strings.TrimSpace(text); text == "" {
+					status.SetMessage("No information about " + name)
+				} else {
+					// Show the first line, which is typically the signature or the type
+					status.SetMessage(strings.SplitN(text, "\n", 2)[0])
+				}
+				status.Show(c, e)
+			})
+			actions.Add("Find references to "+name, func() {
+				status.ClearAll(c)
+				if msg, err := e.LSPReferences(tty, c, status, lk); err != nil {
+					status.SetErrorMessage(err.Error())
+				} else if msg != "" {
+					status.SetMessage(msg)
+				}
+				status.Show(c, e)
+			})
+		}
+	}
+
+	// Add the menu item for generating a tags file for ctrl-]
+	if which("ctags") != "" {
+		actions.Add("Regenerate tags", func() {
+			status.ClearAll(c)
+			dir := "."
+			if absFilename, err := e.AbsFilename(); err == nil {
+				dir = filepath.Dir(absFilename)
+			}
+			if tagsFile, err := RegenerateTags(dir); err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage("Generated " + tagsFile)
+			}
+			status.Show(c, e)
+		})
+	}
+
+	// Add the menu item for jumping to the lines that have been visited the most lately
+	if len(e.hotSpots) > 0 {
+		actions.Add("Jump to a frequently visited location", func() {
+			if msg := e.HotSpotsMenu(c, status, tty); msg != "" {
+				status.ClearAll(c)
+				status.SetMessage(msg)
+				status.Show(c, e)
+			}
+		})
+	}
+
+	// Add the portal menu items
 	if portal, err := LoadPortal(); err == nil { // no problems
 		actions.Add("Close portal at "+portal.String(), func() {
 			ClosePortal()
 		})
+		actions.Add("Save the portal with a name", func() {
+			name, ok := e.UserInput(c, tty, status, "Portal name:")
+			if !ok || name == "" {
+				return
+			}
+			if strings.ContainsAny(name, "/\\") {
+				status.SetErrorMessage("The portal name can not contain slashes")
+			} else if err := portal.SaveAs(name); err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage("Saved the portal at " + portal.String() + " as " + name)
+			}
+			status.Show(c, e)
+		})
+		actions.Add("Share the portal with other users", func() {
+			status.ClearAll(c)
+			if err := portal.Share(); err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage("Shared the portal at " + portal.String() + " in " + sharedPortalDirectory)
+			}
+			status.Show(c, e)
+		})
 	} else {
 		// Could not close portal, try opening a new one
 		if portal, err := e.NewPortal(); err == nil { // no problems
@@ -285,6 +479,19 @@ func (e *Editor) CommandMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY,
 		}
 	}
 
+	if len(Portals()) > 0 {
+		actions.Add("Paste from a named or shared portal", func() {
+			if msg := e.PortalsMenu(c, status, tty); msg != "" {
+				status.ClearAll(c)
+				status.SetMessage(msg)
+				status.Show(c, e)
+			}
+		})
+		actions.Add("Close all portals", func() {
+			CloseAllPortals()
+		})
+	}
+
 	// Add the "Default theme" menu item text and menu function
 	actions.Add("Default theme", func() {
 		e.setDefaultTheme()
@@ -369,7 +576,7 @@ func (e *Editor) CommandMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY,
 // Save the command to a temporary file, given an exec.Cmd struct
 func saveCommand(cmd *exec.Cmd) error {
 
-	p := expandUser(lastCommandFile)
+	p := cachePath(lastCommandFile)
 
 	// First create the folder for the lock file overview, if needed
 	folderPath := filepath.Dir(p)
@@ -384,6 +591,15 @@ func saveCommand(cmd *exec.Cmd) error {
 
 	// Strip the leading /usr/bin/sh -c command, if present
 	commandString := strings.TrimPrefix(cmd.String(), "/usr/bin/sh -c ")
+	if len(cmd.Args) == 3 && cmd.Args[0] == "sh" && cmd.Args[1] == "-c" {
+		// sh may be found elsewhere, and the command may contain spaces
+		commandString = cmd.Args[2]
+	}
+
+	// Commands from the project configuration file are run in the project directory
+	if cmd.Dir != "" {
+		commandString = "cd " + shellQuote(cmd.Dir) + " && " + commandString
+	}
 
 	// Write the contents, ignore the number of written bytes
 	_, err = f.WriteString(fmt.Sprintf("#!/bin/sh\n%s\n", commandString))
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/syntax"
+	"github.com/xyproto/vt100"
+)
+
+// Where a completion came from, shown to the right of each completion in the popup
+const (
+	sourceKeyword = "keyword"
+	sourceCorpus  = "corpus"
+	sourceLSP     = "lsp"
+)
+
+// The maximum number of completions that are shown in the popup at the same time
+const maxCompletionRows = 8
+
+var (
+	completionForeground         = vt100.White
+	completionBackground         = vt100.BackgroundBlue
+	completionSelectedForeground = vt100.Black
+	completionSelectedBackground = vt100.BackgroundCyan
+	completionSourceColor        = vt100.LightCyan
+)
+
+// Completion is a word that can be completed to, where it came from and,
+// if available, details like the signature of a function
+type Completion struct {
+	text   string
+	source string
+	detail string
+}
+
+// addCompletion adds the given completion to the list, unless the same text is already there
+func addCompletion(completions []Completion, text, source, detail string) []Completion {
+	for _, completion := range completions {
+		if completion.text == text {
+			return completions
+		}
+	}
+	return append(completions, Completion{text, source, detail})
+}
+
+// filterCompletions returns the completions that start with the given prefix, in the same order.
+// Completions where the case matches come first, then completions where the case does not match.
+func filterCompletions(completions []Completion, prefix string) []Completion {
+	var exact, ignoringCase []Completion
+	lowerPrefix := strings.ToLower(prefix)
+	for _, completion := range completions {
+		if completion.text == prefix {
+			continue
+		}
+		if strings.HasPrefix(completion.text, prefix) {
+			exact = append(exact, completion)
+		} else if strings.HasPrefix(strings.ToLower(completion.text), lowerPrefix) {
+			ignoringCase = append(ignoringCase, completion)
+		}
+	}
+	return append(exact, ignoringCase...)
+}
+
+// sortCompletionsByLength sorts the completions so that the shortest ones come first
+func sortCompletionsByLength(completions []Completion) {
+	sort.SliceStable(completions, func(i, j int) bool {
+		return len(completions[i].text) < len(completions[j].text)
+	})
+}
+
+// drawCompletionPopup draws the visible part of the list of completions at the given position
+func drawCompletionPopup(c *vt100.Canvas, x, y uint, completions []Completion, selected, offset int) {
+	// Find the width of the popup
+	textWidth, sourceWidth := 0, 0
+	for _, completion := range completions {
+		if l := len([]rune(completion.text)); l > textWidth {
+			textWidth = l
+		}
+		if l := len(completion.source); l > sourceWidth {
+			sourceWidth = l
+		}
+	}
+	width := uint(1 + textWidth + 2 + sourceWidth + 1)
+	if x+width > c.Width() {
+		if c.Width() > width {
+			x = c.Width() - width
+		} else {
+			x = 0
+		}
+	}
+	for row := 0; row < maxCompletionRows && offset+row < len(completions); row++ {
+		completion := completions[offset+row]
+		fg, bg := completionForeground, completionBackground
+		if offset+row == selected {
+			fg, bg = completionSelectedForeground, completionSelectedBackground
+		}
+		line := " " + completion.text + strings.Repeat(" ", textWidth-len([]rune(completion.text))+2)
+		lineLength := uint(len([]rune(line)))
+		for i, r := range line {
+			c.WriteRuneB(x+uint(i), y+uint(row), fg, bg, r)
+		}
+		sourceFg := completionSourceColor
+		if offset+row == selected {
+			sourceFg = fg
+		}
+		source := completion.source + strings.Repeat(" ", sourceWidth-len(completion.source)+1)
+		for i, r := range source {
+			c.WriteRuneB(x+lineLength+uint(i), y+uint(row), sourceFg, bg, r)
+		}
+	}
+	c.Draw()
+}
+
+// CompletionPopup shows the given completions in a popup next to the cursor, where one can be selected
+// with the arrow keys and chosen with return or tab. Typing letters filters the list.
+// Returns the word that should end up before the cursor, and true if a completion was chosen.
+// If the popup is cancelled, the prefix and what was typed while the popup was open is returned, together with false.
+func (e *Editor) CompletionPopup(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, prefix string, completions []Completion) (string, bool) {
+	var (
+		typed    string
+		selected int
+		offset   int
+		filtered = filterCompletions(completions, prefix)
+	)
+	if len(filtered) == 0 {
+		return prefix, false
+	}
+
+	// Place the popup below the cursor, aligned with the start of the word, or above if there is no room
+	cx, cy := e.pos.ScreenX(), e.pos.ScreenY()
+	x := cx - len([]rune(prefix))
+	if x < 0 {
+		x = 0
+	}
+	rows := len(filtered)
+	if rows > maxCompletionRows {
+		rows = maxCompletionRows
+	}
+	y := cy + 1
+	if c != nil && uint(y+rows) >= c.Height()-1 && cy-rows >= 0 {
+		y = cy - rows
+	}
+
+	for {
+		// Draw the current lines first, so that a previous and larger popup is cleared
+		e.DrawLines(c, true, false)
+		if c != nil {
+			drawCompletionPopup(c, uint(x), uint(y), filtered, selected, offset)
+		}
+		status.ClearAll(c)
+		status.SetMessage("Complete: " + prefix + typed)
+		status.ShowNoTimeout(c, e)
+		vt100.SetXY(uint(cx), uint(cy))
+
+		key := tty.String()
+		switch key {
+		case "↓", "c:14": // down arrow or ctrl-n
+			selected = (selected + 1) % len(filtered)
+		case "↑", "c:16": // up arrow or ctrl-p
+			selected = (selected + len(filtered) - 1) % len(filtered)
+		case "c:9", "c:13": // tab or return
+			status.ClearAll(c)
+			return filtered[selected].text, true
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return prefix + typed, false
+		case "←", "→": // left arrow or right arrow, done completing, keep what has been typed
+			status.ClearAll(c)
+			return prefix + typed, false
+		case "c:8", "c:127": // ctrl-h or backspace
+			if typed == "" {
+				status.ClearAll(c)
+				return prefix, false
+			}
+			typed = string([]rune(typed)[:len([]rune(typed))-1])
+		default:
+			runes := []rune(key)
+			if len(runes) != 1 || strings.HasPrefix(key, "c:") {
+				// Not a key that can be used for filtering
+				continue
+			}
+			if r := runes[0]; !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+				status.ClearAll(c)
+				if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+					// A special key, like the arrow glyphs, done completing, keep what has been typed
+					return prefix + typed, false
+				}
+				// Punctuation, done completing, keep what has been typed, including this rune
+				return prefix + typed + key, false
+			}
+			typed += key
+		}
+		filtered = filterCompletions(completions, prefix+typed)
+		if len(filtered) == 0 {
+			status.ClearAll(c)
+			return prefix + typed, false
+		}
+		if selected >= len(filtered) {
+			selected = len(filtered) - 1
+		}
+		// Scroll the list, if needed
+		if selected < offset {
+			offset = selected
+		} else if selected >= offset+maxCompletionRows {
+			offset = selected - maxCompletionRows + 1
+		}
+	}
+}
+
+// Completions returns the possible completions of the given word before the cursor.
+// The completions come from the language server, if one is running, from the snippets and keywords of the
+// current language, from the words in the open buffers and nearby files and, if the word comes
+// after a ".", from the fields and methods found by parsing the Go package, if editing Go,
+// and from what follows the word before the "." in files in the same directory.
+func (e *Editor) Completions(word string) []Completion {
+	var completions []Completion
+	if e.LSP() != nil {
+		items, _ := e.LSPCompletion()
+		for _, item := range items {
+			text := item.InsertText
+			if text == "" {
+				text = item.Label
+			}
+			completions = addCompletion(completions, text, sourceLSP, item.Detail)
+		}
+	}
+	x, err := e.DataX()
+	if err != nil {
+		x = len(e.lines[int(e.DataY())])
+	}
+	start := x - len([]rune(word))
+	if start > 0 && e.lines[int(e.DataY())][start-1] == '.' {
+		// Grep all files in this directory with the same extension as the currently edited file
+		// for what could follow the word before the "."
+		if before := e.IdentifierBefore(e.DataY(), start-1); before != "" {
+			if e.mode == modeGo {
+				// Fields, methods and package-level identifiers found by parsing the Go package
+				for _, completion := range e.GoCompletions(before) {
+					completions = addCompletion(completions, completion.text, completion.source, completion.detail)
+				}
+			}
+			for _, text := range corpus(before, "*"+filepath.Ext(e.filename)) {
+				completions = addCompletion(completions, text, sourceCorpus, "")
+			}
+		}
+		return completions
+	}
+	if word == "" {
+		return completions
+	}
+	for _, completion := range e.SnippetCompletions(word) {
+		completions = addCompletion(completions, completion.text, completion.source, completion.detail)
+	}
+	if e.mode == modeBlank || e.mode == modeText || e.mode == modeMarkdown {
+		// Only complete long words in text, so that tab can still be used for indentation
+		if len([]rune(word)) >= minTextCompletionLength {
+			for _, completion := range e.WordCompletions(word) {
+				completions = addCompletion(completions, completion.text, completion.source, completion.detail)
+			}
+		}
+		return completions
+	}
+	var keywords []Completion
+	for kw := range syntax.Keywords {
+		if strings.HasPrefix(kw, word) {
+			keywords = append(keywords, Completion{kw, sourceKeyword, ""})
+		}
+	}
+	// The keywords are in a map, so sort them, with the shortest ones first
+	sort.Slice(keywords, func(i, j int) bool {
+		return keywords[i].text < keywords[j].text
+	})
+	sortCompletionsByLength(keywords)
+	for _, completion := range keywords {
+		completions = addCompletion(completions, completion.text, completion.source, completion.detail)
+	}
+	// Then add the words from the open buffers and nearby files
+	for _, completion := range e.WordCompletions(word) {
+		completions = addCompletion(completions, completion.text, completion.source, completion.detail)
+	}
+	return completions
+}
+
+// InsertCompletion replaces the given word before the cursor with the completed word
+func (e *Editor) InsertCompletion(c *vt100.Canvas, word, completed string) {
+	if strings.HasPrefix(completed, word) {
+		e.InsertStringAndMove(c, strings.TrimPrefix(completed, word))
+		return
+	}
+	// The case is different, so remove the word first
+	for range []rune(word) {
+		e.Prev(c)
+		e.Delete()
+	}
+	e.InsertStringAndMove(c, completed)
+}
+
+// ShowCompletionDetail shows the details of the chosen completion in the status bar,
+// like the signature of a function, if there are any
+func (e *Editor) ShowCompletionDetail(c *vt100.Canvas, status *StatusBar, completions []Completion, completed string) {
+	for _, completion := range completions {
+		if completion.text == completed && completion.detail != "" {
+			status.ClearAll(c)
+			status.SetMessage(completion.detail)
+			status.Show(c, e)
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAddCompletion(t *testing.T) {
+	var completions []Completion
+	completions = addCompletion(completions, "Println", sourceLSP, "func(a ...any)")
+	completions = addCompletion(completions, "Printf", sourceKeyword, "")
+	// The same text is only added once, and the first one is kept
+	completions = addCompletion(completions, "Println", sourceBuffer, "")
+	if got := completionTexts(completions); got != "Println Printf" {
+		t.Errorf("expected the duplicate to be skipped, got %q", got)
+	}
+	if completions[0].source != sourceLSP || completions[0].detail != "func(a ...any)" {
+		t.Errorf("expected the first Println to be kept, got %+v", completions[0])
+	}
+}
+
+func TestFilterCompletions(t *testing.T) {
+	completions := []Completion{
+		{"Print", sourceKeyword, ""},
+		{"printer", sourceBuffer, ""},
+		{"PRINTING", sourceBuffer, ""},
+		{"print", sourceBuffer, ""},
+		{"println", sourceBuffer, ""},
+		{"sprint", sourceBuffer, ""},
+	}
+	// Matches where the case is the same come first, then matches where the case is different,
+	// in the same order as before. The exact match is left out, and so is what does not match.
+	expected := "printer println Print PRINTING"
+	if got := completionTexts(filterCompletions(completions, "print")); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if filtered := filterCompletions(completions, "xyz"); len(filtered) != 0 {
+		t.Errorf("expected no matches, got %v", filtered)
+	}
+	if got := completionTexts(filterCompletions(completions, "")); got != "Print printer PRINTING print println sprint" {
+		t.Errorf("expected everything to match an empty prefix, got %q", got)
+	}
+}
+
+func TestSortCompletionsByLength(t *testing.T) {
+	completions := []Completion{
+		{"println", sourceBuffer, ""},
+		{"for", sourceKeyword, ""},
+		{"print", sourceBuffer, ""},
+		{"if", sourceKeyword, ""},
+		{"fmt", sourceBuffer, ""},
+	}
+	sortCompletionsByLength(completions)
+	// Completions of the same length keep their order
+	expected := "if for fmt print println"
+	if got := completionTexts(completions); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/xyproto/vt100"
+)
+
+var (
+	coveredColor   = vt100.LightGreen
+	uncoveredColor = vt100.LightRed
+)
+
+// coverageMarker is drawn in the rightmost column of lines with covered or uncovered statements
+const coverageMarker = '▐'
+
+// CoverBlock is a range of statements from a Go coverage profile. Lines and columns are 1-based,
+// and the columns are byte offsets.
+type CoverBlock struct {
+	startLine, startCol int
+	endLine, endCol     int
+	numStmts, count     int
+}
+
+// Coverage is the test coverage from the last time the tests were run with coverage
+type Coverage struct {
+	blocks  map[string][]CoverBlock // per filename in the profile, like "example.com/p/main.go"
+	keys    map[string]string       // the filename in the profile for each absolute filename that has been looked up
+	percent float64                 // the percentage of statements that were covered
+	show    bool
+	mut     *sync.RWMutex
+}
+
+// coverage is the test coverage from the last time the tests were run with coverage
+var coverage = &Coverage{mut: &sync.RWMutex{}}
+
+// parseCoverProfile parses a coverage profile from go test -coverprofile, with lines like:
+//
+//	mode: set
+//	example.com/p/main.go:10.13,12.2 1 1
+func parseCoverProfile(r io.Reader) (map[string][]CoverBlock, float64, error) {
+	var (
+		blocks             = make(map[string][]CoverBlock)
+		seen               = make(map[string]int) // the index of each block, for merging repeated blocks
+		scanner            = bufio.NewScanner(r)
+		total, coveredStmt int
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// filename:startLine.startCol,endLine.endCol numStmts count
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, 0, errors.New("invalid coverage profile line: " + line)
+		}
+		filename := line[:i]
+		var b CoverBlock
+		if _, err := fmt.Sscanf(line[i+1:], "%d.%d,%d.%d %d %d", &b.startLine, &b.startCol, &b.endLine, &b.endCol, &b.numStmts, &b.count); err != nil {
+			return nil, 0, errors.New("invalid coverage profile line: " + line)
+		}
+		key := filename + ":" + strings.Fields(line[i+1:])[0]
+		if index, found := seen[key]; found {
+			blocks[filename][index].count += b.count
+			continue
+		}
+		seen[key] = len(blocks[filename])
+		blocks[filename] = append(blocks[filename], b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	for _, fileBlocks := range blocks {
+		for _, b := range fileBlocks {
+			total += b.numStmts
+			if b.count > 0 {
+				coveredStmt += b.numStmts
+			}
+		}
+	}
+	percent := 0.0
+	if total > 0 {
+		percent = 100 * float64(coveredStmt) / float64(total)
+	}
+	return blocks, percent, nil
+}
+
+// Load reads the given coverage profile and shows the coverage
+func (cov *Coverage) Load(profileFilename string) error {
+	f, err := os.Open(profileFilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	blocks, percent, err := parseCoverProfile(f)
+	if err != nil {
+		return err
+	}
+	cov.mut.Lock()
+	defer cov.mut.Unlock()
+	cov.blocks, cov.keys, cov.percent, cov.show = blocks, make(map[string]string), percent, true
+	return nil
+}
+
+// Loaded checks if there is coverage from an earlier test run
+func (cov *Coverage) Loaded() bool {
+	cov.mut.RLock()
+	defer cov.mut.RUnlock()
+	return cov.blocks != nil
+}
+
+// Shown checks if the coverage should be shown
+func (cov *Coverage) Shown() bool {
+	cov.mut.RLock()
+	defer cov.mut.RUnlock()
+	return cov.show && cov.blocks != nil
+}
+
+// Toggle shows or hides the coverage
+func (cov *Coverage) Toggle() {
+	cov.mut.Lock()
+	defer cov.mut.Unlock()
+	cov.show = !cov.show
+}
+
+// Status returns a status message with the percentage of statements that were covered
+func (cov *Coverage) Status() string {
+	cov.mut.RLock()
+	defer cov.mut.RUnlock()
+	return fmt.Sprintf("Coverage: %.1f%% of statements", cov.percent)
+}
+
+// profileKey returns the filename that is used for the given file in the given coverage profile, or ""
+func profileKey(absFilename string, blocks map[string][]CoverBlock) string {
+	// The profile uses the import path of the package, followed by the filename
+	if root, modulePath := goModule(filepath.Dir(absFilename)); modulePath != "" {
+		if rel, err := filepath.Rel(root, absFilename); err == nil {
+			return modulePath + "/" + filepath.ToSlash(rel)
+		}
+	}
+	for filename := range blocks {
+		if strings.HasSuffix(filepath.FromSlash(filename), string(filepath.Separator)+filepath.Base(absFilename)) {
+			return filename
+		}
+	}
+	return ""
+}
+
+// Blocks returns the covered and uncovered statements in the given file.
+// The filename in the profile is only looked up once per file, since this is done for every redraw.
+func (cov *Coverage) Blocks(absFilename string) []CoverBlock {
+	cov.mut.Lock()
+	defer cov.mut.Unlock()
+	if cov.blocks == nil {
+		return nil
+	}
+	key, found := cov.keys[absFilename]
+	if !found {
+		key = profileKey(absFilename, cov.blocks)
+		cov.keys[absFilename] = key
+	}
+	return cov.blocks[key]
+}
+
+// coverageForLines returns, for each given line, if each rune is in a covered (1) or an uncovered (-1)
+// statement, or neither (0). Tabs are counted as the given number of spaces, as when drawing.
+// Lines without statements are left out.
+func coverageForLines(blocks []CoverBlock, lines map[LineIndex]string, spacesPerTab int) map[LineIndex][]int8 {
+	result := make(map[LineIndex][]int8)
+	for y, line := range lines {
+		n := int(y.LineNumber())
+		var byteStates []int8
+		for _, b := range blocks {
+			if n < b.startLine || n > b.endLine {
+				continue
+			}
+			if byteStates == nil {
+				byteStates = make([]int8, len(line))
+			}
+			from, to := 0, len(line)
+			if n == b.startLine {
+				from = b.startCol - 1
+			}
+			if n == b.endLine {
+				to = b.endCol - 1
+			}
+			state := int8(1)
+			if b.count == 0 {
+				state = -1
+			}
+			for i := from; i < to && i < len(line); i++ {
+				if i >= 0 && (byteStates[i] == 0 || state < 0) {
+					byteStates[i] = state
+				}
+			}
+		}
+		if byteStates == nil {
+			continue
+		}
+		// Convert from byte offsets to rune indices
+		var runeStates []int8
+		for i, r := range line {
+			if r == '\t' {
+				for j := 0; j < spacesPerTab; j++ {
+					runeStates = append(runeStates, byteStates[i])
+				}
+				continue
+			}
+			runeStates = append(runeStates, byteStates[i])
+		}
+		result[y] = runeStates
+	}
+	return result
+}
+
+// CoverageForLines returns the coverage for the lines from "fromline" up to "toline", if the coverage is shown
+func (e *Editor) CoverageForLines(fromline, toline LineIndex) map[LineIndex][]int8 {
+	if e.mode != modeGo || !coverage.Shown() {
+		return nil
+	}
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return nil
+	}
+	blocks := coverage.Blocks(absFilename)
+	if len(blocks) == 0 {
+		return nil
+	}
+	lines := make(map[LineIndex]string)
+	for y := fromline; y < toline && int(y) < e.Len(); y++ {
+		lines[y] = e.Line(y)
+	}
+	return coverageForLines(blocks, lines, e.tabs.spacesPerTab)
+}
+
+// addCoverageProfile adds -coverprofile to the given go test command if the coverage is shown,
+// so that it can be refreshed when the tests are run again. Returns the name of the profile, or "".
+func addCoverageProfile(cmd *exec.Cmd) string {
+	if !coverage.Shown() {
+		return ""
+	}
+	f, err := ioutil.TempFile("", "o_cover*.out")
+	if err != nil {
+		return ""
+	}
+	f.Close()
+	cmd.Args = append(cmd.Args, "-coverprofile="+f.Name())
+	return f.Name()
+}
+
+// refreshCoverage loads the given profile, if it was written, and then removes it
+func refreshCoverage(profileFilename string) {
+	if profileFilename == "" {
+		return
+	}
+	coverage.Load(profileFilename)
+	os.Remove(profileFilename)
+}
+
+// RunCoverage saves the file, runs the tests of the current Go package with coverage and
+// shows which statements were covered. Returns the coverage as a status message, or an error
+// if the tests failed.
+func (e *Editor) RunCoverage(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar) (string, error) {
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return "", err
+	}
+	if e.changed {
+		if err := e.Save(c); err != nil {
+			return "", err
+		}
+	}
+	f, err := ioutil.TempFile("", "o_cover*.out")
+	if err != nil {
+		return "", err
+	}
+	profileFilename := f.Name()
+	f.Close()
+	defer os.Remove(profileFilename)
+
+	cmd := exec.Command("go", "test", "-json", "-coverprofile="+profileFilename)
+	cmd.Dir = filepath.Dir(absFilename)
+
+	// Save the command in a temporary file
+	saveCommand(cmd)
+
+	output, err := e.RunBuildCommand(c, tty, status, cmd, "Testing with coverage")
+	if err == errBuildCancelled {
+		return "", errors.New("Testing with coverage was " + err.Error())
+	}
+	entries := ParseDiagnostics(string(output), cmd.Dir, e.mode)
+	quickfix.Set(entries)
+	loadErr := coverage.Load(profileFilename)
+	e.redraw = true
+	if firstError, found := FirstError(entries, absFilename); found {
+		return "", errors.New("Test failed: " + e.goToError(c, status, firstError, absFilename))
+	}
+	if err != nil && loadErr != nil {
+		return "", errors.New("Test failed")
+	}
+	if loadErr != nil {
+		return "", loadErr
+	}
+	return coverage.Status(), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseCoverProfile(t *testing.T) {
+	profile := `mode: set
+example.com/p/main.go:3.13,5.2 2 1
+example.com/p/main.go:7.14,9.16 1 0
+example.com/p/main.go:9.16,11.3 1 0
+example.com/p/util.go:3.20,5.2 4 1
+example.com/p/util.go:3.20,5.2 4 0
+`
+	blocks, percent, err := parseCoverProfile(strings.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks["example.com/p/main.go"]) != 3 || len(blocks["example.com/p/util.go"]) != 1 {
+		t.Errorf("expected 3 blocks in main.go and 1 block in util.go, got %v", blocks)
+	}
+	if b := blocks["example.com/p/main.go"][1]; b != (CoverBlock{7, 14, 9, 16, 1, 0}) {
+		t.Errorf("unexpected block: %+v", b)
+	}
+	// 6 of 8 statements are covered, the repeated block in util.go is only counted once
+	if s := fmt.Sprintf("%.1f", percent); s != "75.0" {
+		t.Errorf("expected 75.0%%, got %s%%", s)
+	}
+	if _, _, err := parseCoverProfile(strings.NewReader("mode: set\nexample.com/p/main.go\n")); err == nil {
+		t.Error("expected an invalid line to be an error")
+	}
+}
+
+func TestCoverageForLines(t *testing.T) {
+	blocks := []CoverBlock{
+		{startLine: 1, startCol: 14, endLine: 3, endCol: 2, numStmts: 1, count: 1},
+		{startLine: 2, startCol: 12, endLine: 2, endCol: 15, numStmts: 1, count: 0},
+	}
+	lines := map[LineIndex]string{
+		0: "func f() int {",
+		1: "\tif x { return 1 }",
+		2: "}",
+		3: "// not a statement",
+	}
+	result := coverageForLines(blocks, lines, 2)
+	if _, found := result[3]; found {
+		t.Error("expected no coverage for a line without statements")
+	}
+	if states := result[0]; len(states) != 14 || states[12] != 0 || states[13] != 1 {
+		t.Errorf("expected only the { to be covered, got %v", states)
+	}
+	// The tab counts as two runes, and "ret" is not covered
+	expected := []int8{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, 1, 1, 1, 1}
+	if states := result[1]; fmt.Sprint(states) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, states)
+	}
+	if states := result[2]; len(states) != 1 || states[0] != 1 {
+		t.Errorf("expected the } to be covered, got %v", states)
+	}
+}
+
+func TestCoverageBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	block := CoverBlock{startLine: 3, startCol: 13, endLine: 5, endCol: 2, numStmts: 1, count: 1}
+	cov := &Coverage{mut: &sync.RWMutex{}}
+	if blocks := cov.Blocks(filepath.Join(dir, "main.go")); blocks != nil {
+		t.Errorf("expected no blocks before the coverage is loaded, got %v", blocks)
+	}
+	cov.blocks = map[string][]CoverBlock{"example.com/p/sub/main.go": {block}}
+	cov.keys = make(map[string]string)
+
+	absFilename := filepath.Join(dir, "sub", "main.go")
+	if blocks := cov.Blocks(absFilename); len(blocks) != 1 || blocks[0] != block {
+		t.Fatalf("expected the block for example.com/p/sub/main.go, got %v", blocks)
+	}
+	// The filename in the profile is only looked up once
+	os.Remove(filepath.Join(dir, "go.mod"))
+	if blocks := cov.Blocks(absFilename); len(blocks) != 1 {
+		t.Errorf("expected the filename in the profile to be remembered, got %v", blocks)
+	}
+	if key := cov.keys[absFilename]; key != "example.com/p/sub/main.go" {
+		t.Errorf("expected example.com/p/sub/main.go, got %q", key)
+	}
+	if blocks := cov.Blocks(filepath.Join(dir, "other.go")); blocks != nil {
+		t.Errorf("expected no blocks for a file that is not in the profile, got %v", blocks)
+	}
+}
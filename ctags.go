@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// The name of the tags file that is generated by ctags
+const tagsFilename = "tags"
+
+var errNoTagsFile = errors.New("could not find a tags file, try generating one with ctags -R")
+
+// Tag is a definition found in a tags file
+type Tag struct {
+	name       string
+	filename   string // absolute filename
+	pattern    string // search pattern from the tags file, without the surrounding /^ and $/
+	lineNumber LineNumber
+	kind       string
+	wholeLine  bool // did the pattern end with $? If not, ctags has truncated it and it matches the start of a line
+}
+
+// String returns a short description of this tag, for use in menus
+func (t Tag) String() string {
+	s := t.filename
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, t.filename); err == nil && !strings.HasPrefix(rel, "..") {
+			s = rel
+		}
+	}
+	if t.lineNumber > 0 {
+		s += ":" + t.lineNumber.String()
+	}
+	if t.kind != "" {
+		s += " (" + t.kind + ")"
+	}
+	if pattern := strings.TrimSpace(t.pattern); pattern != "" {
+		s += ": " + pattern
+	}
+	return s
+}
+
+// FindTagsFile searches for a tags file in the given directory, and then upwards
+func FindTagsFile(dir string) (string, error) {
+	for {
+		tagsFile := filepath.Join(dir, tagsFilename)
+		if fi, err := os.Stat(tagsFile); err == nil && fi.Mode().IsRegular() {
+			return tagsFile, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errNoTagsFile
+		}
+		dir = parent
+	}
+}
+
+// unescapeTagPattern converts a pattern like /^func main() {$/ to "func main() {".
+// Returns true if the pattern ends with $, which means that it matches the whole line.
+func unescapeTagPattern(address string) (string, bool) {
+	if len(address) < 2 {
+		return address, false
+	}
+	// The pattern is surrounded by / for forward searches and ? for backward searches
+	if delim := address[0]; (delim == '/' || delim == '?') && address[len(address)-1] == delim {
+		address = address[1 : len(address)-1]
+	}
+	address = strings.TrimPrefix(address, "^")
+	wholeLine := strings.HasSuffix(address, "$") && !strings.HasSuffix(address, "\\$")
+	if wholeLine {
+		address = address[:len(address)-1]
+	}
+	var sb strings.Builder
+	escaped := false
+	for _, r := range address {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		sb.WriteRune(r)
+	}
+	return sb.String(), wholeLine
+}
+
+// parseTagLine parses a line in a tags file, in the Universal Ctags format:
+// name<tab>filename<tab>address;"<tab>kind<tab>field:value...
+// The filename is relative to the given directory, unless it is absolute.
+func parseTagLine(line, dir string) (Tag, bool) {
+	var t Tag
+	if strings.HasPrefix(line, "!_TAG_") {
+		return t, false
+	}
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) < 3 {
+		return t, false
+	}
+	t.name = fields[0]
+	t.filename = fields[1]
+	if !filepath.IsAbs(t.filename) {
+		t.filename = filepath.Join(dir, t.filename)
+	}
+	// The address is followed by ;" and then the extension fields, if there are any
+	address, extra := fields[2], ""
+	if pos := strings.LastIndex(address, ";\""); pos != -1 {
+		address, extra = address[:pos], address[pos+2:]
+	}
+	if n, err := strconv.Atoi(address); err == nil {
+		t.lineNumber = LineNumber(n)
+	} else {
+		t.pattern, t.wholeLine = unescapeTagPattern(address)
+	}
+	for _, field := range strings.Split(extra, "\t") {
+		switch {
+		case field == "":
+		case strings.HasPrefix(field, "kind:"):
+			t.kind = strings.TrimPrefix(field, "kind:")
+		case strings.HasPrefix(field, "line:"):
+			if n, err := strconv.Atoi(strings.TrimPrefix(field, "line:")); err == nil {
+				t.lineNumber = LineNumber(n)
+			}
+		case !strings.Contains(field, ":"):
+			// A kind without the "kind:" prefix
+			t.kind = field
+		}
+	}
+	return t, true
+}
+
+// FindTags returns all tags with the given name in the given tags file
+func FindTags(tagsFile, name string) ([]Tag, error) {
+	f, err := os.Open(tagsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		tags    []Tag
+		dir     = filepath.Dir(tagsFile)
+		prefix  = name + "\t"
+		scanner = bufio.NewScanner(f)
+	)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		if t, ok := parseTagLine(line, dir); ok {
+			tags = append(tags, t)
+		}
+	}
+	return tags, scanner.Err()
+}
+
+// Resolve finds the line number of the tag, by searching for the pattern in the file if needed.
+// Patterns that ctags has truncated only have to match the start of the line.
+func (t Tag) Resolve() (LineNumber, error) {
+	if t.lineNumber > 0 {
+		return t.lineNumber, nil
+	}
+	f, err := os.Open(t.filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if line := scanner.Text(); line == t.pattern || (!t.wholeLine && strings.HasPrefix(line, t.pattern)) {
+			return LineNumber(n), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("could not find the definition of %s in %s", t.name, filepath.Base(t.filename))
+}
+
+// RegenerateTags runs ctags -R in the directory of the tags file for the given directory,
+// or in the given directory if there is no tags file yet
+func RegenerateTags(dir string) (string, error) {
+	if which("ctags") == "" {
+		return "", errors.New("ctags is not installed")
+	}
+	if tagsFile, err := FindTagsFile(dir); err == nil {
+		dir = filepath.Dir(tagsFile)
+	}
+	cmd := exec.Command("ctags", "-R")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return filepath.Join(dir, tagsFilename), nil
+}
+
+// IdentifierAtCursor returns the identifier (letters, digits and underscores) under the cursor
+func (e *Editor) IdentifierAtCursor() string {
+	runes, ok := e.lines[int(e.DataY())]
+	if !ok {
+		return ""
+	}
+	x, err := e.DataX()
+	if err != nil || x >= len(runes) {
+		return ""
+	}
+	if !isIdentifierRune(runes[x]) {
+		return ""
+	}
+	start, end := x, x
+	for start > 0 && isIdentifierRune(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && isIdentifierRune(runes[end]) {
+		end++
+	}
+	return string(runes[start:end])
+}
+
+// GoToDefinition jumps to the definition of the identifier under the cursor, by looking it up
+// in the tags file. If there are several definitions, a menu is shown. Returns a status message.
+func (e *Editor) GoToDefinition(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) (string, error) {
+	name := e.IdentifierAtCursor()
+	if name == "" {
+		return "", errors.New("no identifier under the cursor")
+	}
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return "", err
+	}
+	tagsFile, err := FindTagsFile(filepath.Dir(absFilename))
+	if err != nil {
+		return "", err
+	}
+	tags, err := FindTags(tagsFile, name)
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("could not find %s in %s", name, tagsFile)
+	}
+	t := tags[0]
+	if len(tags) > 1 {
+		choices := make([]string, len(tags))
+		for i, t := range tags {
+			choices[i] = t.String()
+		}
+		selected := e.Menu(status, tty, "Definitions of "+name, choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+		e.redraw = true
+		e.redrawCursor = true
+		if selected < 0 {
+			return "", nil
+		}
+		t = tags[selected]
+	}
+	lineNumber, err := t.Resolve()
+	if err != nil {
+		return "", err
+	}
+	// Make it possible to jump back here with ctrl-l and the left arrow
+	jumpList.Record(e.jumpAt(e.DataY()))
+	if err := e.jumpTo(Jump{t.filename, lineNumber.LineIndex()}, tty, c, status, lk); err != nil {
+		return "", err
+	}
+	// Place the cursor at the name of the definition, if it can be found on the line
+	y := e.DataY()
+	if x := strings.Index(e.Line(y), name); x != -1 {
+		tabs := strings.Count(e.Line(y)[:x], "\t")
+		e.pos.sx = len([]rune(e.Line(y)[:x])) + (tabs * (e.tabs.spacesPerTab - 1))
+		e.HorizontalScrollIfNeeded(c)
+	}
+	return "Jumped to the definition of " + name, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnescapeTagPattern(t *testing.T) {
+	patterns := []struct {
+		address   string
+		expected  string
+		wholeLine bool
+	}{
+		{`/^func main() {$/`, "func main() {", true},
+		{`?^func main() {$?`, "func main() {", true},
+		{`/^int x;/`, "int x;", false},
+		{`/^	path = "\/usr\/bin"$/`, `	path = "/usr/bin"`, true},
+		{`/^cost = 5\$/`, "cost = 5$", false},
+		{`/^a \\ b$/`, `a \ b`, true},
+		{`/`, "/", false},
+		{``, "", false},
+	}
+	for _, p := range patterns {
+		if got, wholeLine := unescapeTagPattern(p.address); got != p.expected || wholeLine != p.wholeLine {
+			t.Errorf("unescapeTagPattern(%q): expected %q and %v, got %q and %v", p.address, p.expected, p.wholeLine, got, wholeLine)
+		}
+	}
+}
+
+func TestParseTagLine(t *testing.T) {
+	lines := []struct {
+		line     string
+		ok       bool
+		expected Tag
+	}{
+		{"!_TAG_FILE_FORMAT\t2\t/extended format/", false, Tag{}},
+		{"main\tmain.go", false, Tag{}},
+		{"main\tmain.go\t/^func main() {$/;\"\tkind:function\tline:5\tlanguage:Go", true, Tag{"main", "/src/main.go", "func main() {", 5, "function", true}},
+		{"main\tmain.go\t/^func main() {$/;\"\tf", true, Tag{"main", "/src/main.go", "func main() {", 0, "f", true}},
+		{"Point\tgeo/point.c\t12;\"\ts", true, Tag{"Point", "/src/geo/point.c", "", 12, "s", false}},
+		{"x\t/abs/x.py\t/^x = 1$/", true, Tag{"x", "/abs/x.py", "x = 1", 0, "", true}},
+		{"weird\tw.c\t/^a;\"b$/;\"\tv", true, Tag{"weird", "/src/w.c", `a;"b`, 0, "v", true}},
+	}
+	for _, l := range lines {
+		tag, ok := parseTagLine(l.line, "/src")
+		if ok != l.ok {
+			t.Errorf("parseTagLine(%q): expected ok to be %v", l.line, l.ok)
+			continue
+		}
+		if ok && tag != l.expected {
+			t.Errorf("parseTagLine(%q): expected %+v, got %+v", l.line, l.expected, tag)
+		}
+	}
+}
+
+func TestFindTagsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_ctags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Resolve symlinks, since the temporary directory may be behind one
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	subDir := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A directory named "tags" is not a tags file
+	if err := os.Mkdir(filepath.Join(subDir, tagsFilename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindTagsFile(subDir); err != errNoTagsFile {
+		t.Errorf("expected errNoTagsFile, got %v", err)
+	}
+
+	sourceFilename := filepath.Join(dir, "a", "main.go")
+	if err := ioutil.WriteFile(sourceFilename, []byte("package main\n\nfunc main() {\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tagsFile := filepath.Join(dir, tagsFilename)
+	tagsData := "!_TAG_FILE_FORMAT\t2\t/extended format/\nmain\ta/main.go\t/^func main() {$/;\"\tf\nmain2\ta/main.go\t1;\"\tf\n"
+	if err := ioutil.WriteFile(tagsFile, []byte(tagsData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := FindTagsFile(subDir); err != nil || found != tagsFile {
+		t.Errorf("expected to find %s, got %q (%v)", tagsFile, found, err)
+	}
+
+	// Only tags with the exact name are found, and the line number is found by searching for the pattern
+	tags, err := FindTags(tagsFile, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].filename != sourceFilename {
+		t.Fatalf("expected one tag in %s, got %v", sourceFilename, tags)
+	}
+	if lineNumber, err := tags[0].Resolve(); err != nil || lineNumber != 3 {
+		t.Errorf("expected the definition to be on line 3, got %d (%v)", lineNumber, err)
+	}
+}
+
+func TestResolveTruncatedPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_ctags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sourceFilename := filepath.Join(dir, "long.go")
+	source := "package main\n\nfunc longFunctionName(a, b int) {}\n\nfunc longFunctionName(a, b int, c string) (int, error) {\n}\n"
+	if err := ioutil.WriteFile(sourceFilename, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// ctags truncates long patterns, and then leaves out the $ at the end
+	tag, ok := parseTagLine("longFunctionName\tlong.go\t/^func longFunctionName(a, b int, c/;\"\tf", dir)
+	if !ok {
+		t.Fatal("could not parse the tag line")
+	}
+	if lineNumber, err := tag.Resolve(); err != nil || lineNumber != 5 {
+		t.Errorf("expected the truncated pattern to match line 5, got %d (%v)", lineNumber, err)
+	}
+	// A pattern that ends with $ must match the whole line
+	tag, _ = parseTagLine("longFunctionName\tlong.go\t/^func longFunctionName(a, b int$/;\"\tf", dir)
+	if _, err := tag.Resolve(); err == nil {
+		t.Error("expected a pattern with $ not to match the start of a line")
+	}
+}
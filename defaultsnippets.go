@@ -0,0 +1,367 @@
+package main
+
+// defaultSnippets are the bundled snippets for each mode, in the same format as the
+// snippet files in ~/.config/o/snippets. Snippets with the same name in those files take precedence.
+var defaultSnippets = map[Mode]string{
+	modeGo: `snippet iferr return the error if it is not nil
+	if err != nil {
+		return ${1:err}
+	}
+snippet main a main package
+	package main
+
+	func main() {
+		${0}
+	}
+snippet func a function
+	func ${1:name}(${2}) ${3:error} {
+		${0}
+	}
+snippet meth a method
+	func (${1:r} *${2:Type}) ${3:Name}(${4}) {
+		${0}
+	}
+snippet for a for loop with an index
+	for ${1:i} := 0; $1 < ${2:n}; $1++ {
+		${0}
+	}
+snippet forr a for loop over a range
+	for ${1:_}, ${2:v} := range ${3:xs} {
+		${0}
+	}
+snippet test a test function
+	func Test${1:Name}(t *testing.T) {
+		${0}
+	}
+`,
+	modeC: `snippet main a main function
+	#include <stdio.h>
+
+	int main(int argc, char* argv[])
+	{
+		${0}
+		return 0;
+	}
+snippet inc include a header
+	#include <${1:stdio.h}>
+snippet for a for loop
+	for (int ${1:i} = 0; $1 < ${2:n}; $1++) {
+		${0}
+	}
+snippet guard a header guard
+	#ifndef ${1:HEADER_H}
+	#define $1
+
+	${0}
+
+	#endif
+`,
+	modeCpp: `snippet main a main function
+	#include <iostream>
+
+	int main(int argc, char* argv[])
+	{
+		${0}
+		return 0;
+	}
+snippet inc include a header
+	#include <${1:iostream}>
+snippet for a for loop
+	for (int ${1:i} = 0; $1 < ${2:n}; $1++) {
+		${0}
+	}
+snippet forr a range-based for loop
+	for (const auto& ${1:x} : ${2:xs}) {
+		${0}
+	}
+snippet class a class
+	class ${1:Name} {
+	public:
+		$1();
+		~$1();
+	${0}
+	};
+`,
+	modeRust: `snippet fn a function
+	fn ${1:name}(${2}) {
+		${0}
+	}
+snippet main a main function
+	fn main() {
+		${0}
+	}
+snippet struct a struct
+	struct ${1:Name} {
+		${0}
+	}
+snippet impl an impl block
+	impl ${1:Name} {
+		${0}
+	}
+snippet test a test module
+	#[cfg(test)]
+	mod tests {
+		use super::*;
+
+		#[test]
+		fn ${1:it_works}() {
+			${0}
+		}
+	}
+`,
+	modePython: `snippet def a function
+	def ${1:name}(${2}):
+		${0:pass}
+snippet class a class
+	class ${1:Name}:
+		def __init__(self${2}):
+			${0:pass}
+snippet main run main if this is the main module
+	if __name__ == "__main__":
+		${0:main()}
+snippet for a for loop
+	for ${1:x} in ${2:xs}:
+		${0:pass}
+`,
+	modeShell: `snippet if an if statement
+	if [ ${1:condition} ]; then
+		${0}
+	fi
+snippet for a for loop
+	for ${1:x} in ${2:xs}; do
+		${0}
+	done
+snippet fn a function
+	${1:name}() {
+		${0}
+	}
+`,
+	modeZig: `snippet main a main function
+	const std = @import("std");
+
+	pub fn main() !void {
+		${0}
+	}
+snippet fn a function
+	fn ${1:name}(${2}) ${3:void} {
+		${0}
+	}
+snippet test a test
+	test "${1:name}" {
+		${0}
+	}
+`,
+	modeKotlin: `snippet main a main function
+	fun main() {
+		${0}
+	}
+snippet fun a function
+	fun ${1:name}(${2}) {
+		${0}
+	}
+`,
+	modeJava: `snippet main a main method
+	public static void main(String[] args) {
+		${0}
+	}
+snippet class a class
+	public class ${1:Name} {
+		${0}
+	}
+snippet for a for loop
+	for (int ${1:i} = 0; $1 < ${2:n}; $1++) {
+		${0}
+	}
+`,
+	modeScala: `snippet main a main method
+	object ${1:Main} {
+		def main(args: Array[String]): Unit = {
+			${0}
+		}
+	}
+snippet def a function
+	def ${1:name}(${2}): ${3:Unit} = {
+		${0}
+	}
+`,
+	modeLua: `snippet fn a function
+	function ${1:name}(${2})
+		${0}
+	end
+snippet for a for loop
+	for ${1:i} = ${2:1}, ${3:n} do
+		${0}
+	end
+snippet if an if statement
+	if ${1:condition} then
+		${0}
+	end
+`,
+	modeCrystal: `snippet def a method
+	def ${1:name}(${2})
+		${0}
+	end
+snippet class a class
+	class ${1:Name}
+		${0}
+	end
+`,
+	modeNim: `snippet proc a procedure
+	proc ${1:name}(${2}) =
+		${0:discard}
+snippet for a for loop
+	for ${1:x} in ${2:xs}:
+		${0:discard}
+`,
+	modeHaskell: `snippet main a main function
+	main :: IO ()
+	main = ${0:return ()}
+snippet fn a function with a type signature
+	${1:name} :: ${2:a}
+	$1 ${3} = ${0:undefined}
+`,
+	modeOCaml: `snippet let a function
+	let ${1:name} ${2:x} =
+		${0}
+snippet match a match expression
+	match ${1:x} with
+	| ${2:_} -> ${0}
+`,
+	modeStandardML: `snippet fun a function
+	fun ${1:name} ${2:x} =
+		${0}
+snippet case a case expression
+	case ${1:x} of
+		${2:_} => ${0}
+`,
+	modeOdin: `snippet main a main procedure
+	package main
+
+	import "core:fmt"
+
+	main :: proc() {
+		${0}
+	}
+snippet proc a procedure
+	${1:name} :: proc(${2}) {
+		${0}
+	}
+`,
+	modeAda: `snippet proc a procedure
+	procedure ${1:Name} is
+	begin
+		${0:null;}
+	end $1;
+snippet if an if statement
+	if ${1:Condition} then
+		${0:null;}
+	end if;
+`,
+	modeObjectPascal: `snippet program a program
+	program ${1:Name};
+
+	begin
+		${0}
+	end.
+snippet proc a procedure
+	procedure ${1:Name}(${2});
+	begin
+		${0}
+	end;
+`,
+	modeLisp: `snippet defun a function
+	(defun ${1:name} (${2})
+		${0})
+snippet let a let expression
+	(let ((${1:x} ${2:nil}))
+		${0})
+`,
+	modeOak: `snippet fn a function
+	fn ${1:name}(${2}) {
+		${0}
+	}
+`,
+	modeHIDL: `snippet interface an interface
+	package ${1:android.hardware.name}@${2:1.0};
+
+	interface ${3:IName} {
+		${0}
+	};
+`,
+	modeSQL: `snippet sel a select statement
+	SELECT ${1:*} FROM ${2:table} WHERE ${0};
+snippet create a create table statement
+	CREATE TABLE ${1:name} (
+		${2:id} INTEGER PRIMARY KEY${0}
+	);
+`,
+	modeHTML: `snippet html a HTML document
+	<!doctype html>
+	<html>
+		<head>
+			<title>${1:Title}</title>
+		</head>
+		<body>
+			${0}
+		</body>
+	</html>
+snippet a a link
+	<a href="${1:url}">${0:text}</a>
+snippet div a div with a class
+	<div class="${1}">
+		${0}
+	</div>
+`,
+	modeXML: `snippet xml an XML declaration
+	<?xml version="1.0" encoding="${1:UTF-8}"?>
+snippet tag a tag
+	<${1:tag}>${0}</$1>
+`,
+	modeMarkdown: `snippet link a link
+	[${1:text}](${0:url})
+snippet img an image
+	![${1:alt}](${0:url})
+`,
+	modeMakefile: `snippet phony a phony target
+	.PHONY: ${1:target}
+
+	$1:
+		${0}
+`,
+	modeCMake: `snippet project a minimal project
+	cmake_minimum_required(VERSION ${1:3.10})
+	project(${2:name})
+
+	add_executable($2 ${0:main.cpp})
+`,
+	modeVim: `snippet fun a function
+	function! ${1:Name}(${2})
+		${0}
+	endfunction
+`,
+	modeBat: `snippet echo turn off echo
+	@echo off
+	${0}
+`,
+	modeAssembly: `snippet start a start label
+	global ${1:_start}
+
+	section .text
+	$1:
+		${0}
+`,
+	modePolicyLanguage: `snippet allow an allow rule
+	allow ${1:source} ${2:target}:${3:class} { ${0:read} };
+`,
+	modeNroff: `snippet sh a section header
+	.SH ${1:NAME}
+	${0}
+`,
+	modeJSON: `snippet kv a key and a value
+	"${1:key}": ${0:"value"}
+`,
+	modeConfig: `snippet section an INI section
+	[${1:section}]
+	${2:key} = ${0:value}
+`,
+}
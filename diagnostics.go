@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goTestEvent is one line of output from "go test -json"
+type goTestEvent struct {
+	Action     string  // "run", "output", "pass", "fail", "build-output" and more
+	Package    string  // the import path of the package being tested
+	ImportPath string  // the import path of the package being built, for build events
+	Test       string  // the name of the test, or "" for events for the whole package
+	Elapsed    float64 // seconds
+	Output     string
+}
+
+// cargoMessage is one line of output from "cargo build --message-format=json"
+type cargoMessage struct {
+	Reason       string           `json:"reason"` // "compiler-message", "compiler-artifact", "build-finished" and more
+	ManifestPath string           `json:"manifest_path"`
+	Message      *rustcDiagnostic `json:"message"`
+}
+
+// rustcDiagnostic is an error or warning from rustc, as given by cargo
+type rustcDiagnostic struct {
+	Message  string      `json:"message"`
+	Level    string      `json:"level"` // "error", "warning", "note", "help" and more
+	Spans    []rustcSpan `json:"spans"`
+	Rendered string      `json:"rendered"` // the message as rustc would have written it
+}
+
+// rustcSpan is a location in the source code of a rustc diagnostic
+type rustcSpan struct {
+	FileName    string `json:"file_name"` // relative to the workspace root
+	LineStart   int    `json:"line_start"`
+	ColumnStart int    `json:"column_start"`
+	IsPrimary   bool   `json:"is_primary"`
+}
+
+// gccDiagnostic is an error or warning from "gcc -fdiagnostics-format=json"
+type gccDiagnostic struct {
+	Kind      string `json:"kind"` // "error", "warning" or "note"
+	Message   string `json:"message"`
+	Locations []struct {
+		Caret struct {
+			File   string `json:"file"`
+			Line   int    `json:"line"`
+			Column int    `json:"column"`
+		} `json:"caret"`
+	} `json:"locations"`
+}
+
+// goVetDiagnostic is a finding from "go vet -json"
+type goVetDiagnostic struct {
+	Posn    string `json:"posn"` // filename:line:col
+	Message string `json:"message"`
+}
+
+// jsonObject has the fields that tell the different JSON objects apart
+type jsonObject struct {
+	Action string // go test
+	Reason string `json:"reason"` // cargo
+}
+
+// posnRegexp matches the filename:line:col positions from go vet
+var posnRegexp = regexp.MustCompile(`^(.+):(\d+):(\d+)$`)
+
+// diagnosticDecoder decodes the JSON output of compilers, linters and test runners,
+// keeping track of state that spans several lines, like the output of a running test
+type diagnosticDecoder struct {
+	dir         string
+	entries     []QuickfixEntry
+	text        []string            // lines that are not JSON, to be parsed with the error formats
+	testOutput  map[string][]string // output per test, from go test
+	failedTests []string
+}
+
+// absolute returns the filename as an absolute path, where relative filenames are relative to dir
+func absolute(filename, dir string) string {
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	return filepath.Clean(filename)
+}
+
+// decodeGoTest handles one event from "go test -json"
+func (d *diagnosticDecoder) decodeGoTest(event goTestEvent) {
+	key := event.Package + " " + event.Test
+	switch {
+	case event.Action == "build-output":
+		d.text = append(d.text, strings.Split(strings.TrimSuffix(event.Output, "\n"), "\n")...)
+	case event.Action == "output" && event.Test == "":
+		d.text = append(d.text, strings.TrimSuffix(event.Output, "\n"))
+	case event.Action == "output":
+		d.testOutput[key] = append(d.testOutput[key], strings.TrimSuffix(event.Output, "\n"))
+	case event.Action == "fail" && event.Test != "":
+		// Skip tests that only failed because one of their subtests failed
+		for _, failed := range d.failedTests {
+			if strings.HasPrefix(failed, key+"/") {
+				return
+			}
+		}
+		d.failedTests = append(d.failedTests, key)
+		// Use the locations from t.Error and t.Fatal, if there are any
+		found := false
+		for _, entry := range ParseErrors(strings.Join(d.testOutput[key], "\n"), d.dir, modeGo) {
+			if entry.filename != "" {
+				d.entries = append(d.entries, entry)
+				found = true
+			}
+		}
+		if !found {
+			d.entries = append(d.entries, QuickfixEntry{severity: "error", message: fmt.Sprintf("%s (%.2fs)", event.Test, event.Elapsed)})
+		}
+	}
+}
+
+// decodeCargo handles one message from "cargo build --message-format=json"
+func (d *diagnosticDecoder) decodeCargo(msg cargoMessage) {
+	if msg.Reason != "compiler-message" || msg.Message == nil {
+		return
+	}
+	if msg.Message.Level != "error" && msg.Message.Level != "warning" {
+		return
+	}
+	for _, span := range msg.Message.Spans {
+		if !span.IsPrimary {
+			continue
+		}
+		// The filenames are relative to the workspace root, which is the directory of
+		// Cargo.toml, or a directory above it for packages in a workspace
+		filename := span.FileName
+		if !filepath.IsAbs(filename) && msg.ManifestPath != "" {
+			root := filepath.Dir(msg.ManifestPath)
+			for dir := root; ; dir = filepath.Dir(dir) {
+				if exists(filepath.Join(dir, filename)) {
+					root = dir
+					break
+				}
+				if filepath.Dir(dir) == dir {
+					break
+				}
+			}
+			filename = filepath.Join(root, filename)
+		}
+		d.entries = append(d.entries, QuickfixEntry{absolute(filename, d.dir), span.LineStart, span.ColumnStart, normalizeSeverity(msg.Message.Level), msg.Message.Message})
+		return
+	}
+	// An error without a location
+	d.entries = append(d.entries, QuickfixEntry{severity: normalizeSeverity(msg.Message.Level), message: msg.Message.Message})
+}
+
+// decodeGCC handles the errors and warnings from "gcc -fdiagnostics-format=json"
+func (d *diagnosticDecoder) decodeGCC(diagnostics []gccDiagnostic) {
+	for _, diagnostic := range diagnostics {
+		entry := QuickfixEntry{severity: normalizeSeverity(diagnostic.Kind), message: diagnostic.Message}
+		if len(diagnostic.Locations) > 0 {
+			caret := diagnostic.Locations[0].Caret
+			entry.filename, entry.line, entry.col = absolute(caret.File, d.dir), caret.Line, caret.Column
+		}
+		d.entries = append(d.entries, entry)
+	}
+}
+
+// decodeGoVet handles the findings from "go vet -json", which are grouped
+// by package and then by analyzer
+func (d *diagnosticDecoder) decodeGoVet(packages map[string]map[string]json.RawMessage) {
+	// Sort by package and analyzer, so that the order is the same every time
+	var keys []string
+	for pkg, analyzers := range packages {
+		for analyzer := range analyzers {
+			keys = append(keys, pkg+"\x00"+analyzer)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields := strings.SplitN(key, "\x00", 2)
+		analyzer, data := fields[1], packages[fields[0]][fields[1]]
+		var diagnostics []goVetDiagnostic
+		if err := json.Unmarshal(data, &diagnostics); err != nil {
+			// {"error": "message"}
+			var failure struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+				d.text = append(d.text, failure.Error)
+			}
+			continue
+		}
+		for _, diagnostic := range diagnostics {
+			entry := QuickfixEntry{severity: "warning", message: analyzer + ": " + diagnostic.Message}
+			if match := posnRegexp.FindStringSubmatch(diagnostic.Posn); match != nil {
+				entry.filename = absolute(match[1], d.dir)
+				entry.line, _ = strconv.Atoi(match[2])
+				entry.col, _ = strconv.Atoi(match[3])
+			}
+			d.entries = append(d.entries, entry)
+		}
+	}
+}
+
+// decode tries to decode the given JSON value, and returns false if it is not recognized
+func (d *diagnosticDecoder) decode(data []byte) bool {
+	if data[0] == '[' {
+		var diagnostics []gccDiagnostic
+		if err := json.Unmarshal(data, &diagnostics); err != nil {
+			return false
+		}
+		d.decodeGCC(diagnostics)
+		return true
+	}
+	var obj jsonObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return false
+	}
+	switch {
+	case obj.Action != "":
+		var event goTestEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return false
+		}
+		d.decodeGoTest(event)
+	case obj.Reason != "":
+		var msg cargoMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return false
+		}
+		d.decodeCargo(msg)
+	default:
+		var packages map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(data, &packages); err != nil {
+			return false
+		}
+		d.decodeGoVet(packages)
+	}
+	return true
+}
+
+// ParseDiagnostics finds all errors and warnings in the output of a compiler, linter or test runner.
+// The JSON output of "go test -json", "go vet -json", "cargo build --message-format=json" and
+// "gcc -fdiagnostics-format=json" (from a command in the project configuration file) is decoded,
+// while other output is parsed with the error formats for the given mode.
+// Relative filenames are relative to the given directory.
+func ParseDiagnostics(output, dir string, mode Mode) []QuickfixEntry {
+	d := &diagnosticDecoder{dir: dir, testOutput: make(map[string][]string)}
+	foundJSON := false
+	lines := strings.Split(strings.Replace(output, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		value := line
+		// go vet -json spreads each JSON object over several lines
+		if line == "{" {
+			for j := i + 1; j < len(lines); j++ {
+				if strings.TrimRight(lines[j], " \t") == "}" {
+					if block := strings.Join(lines[i:j+1], "\n"); json.Valid([]byte(block)) {
+						value, i = block, j
+					}
+					break
+				}
+			}
+		}
+		if (strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[")) && json.Valid([]byte(value)) && d.decode([]byte(value)) {
+			foundJSON = true
+			continue
+		}
+		d.text = append(d.text, lines[i])
+	}
+	if !foundJSON {
+		return ParseErrors(output, dir, mode)
+	}
+	// Parse the output that was not JSON, like build errors from older versions of go test
+	entries := d.entries
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		seen[entry.String()] = true
+	}
+	for _, entry := range ParseErrors(strings.Join(d.text, "\n"), dir, mode) {
+		if key := entry.String(); !seen[key] {
+			seen[key] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	samples := []struct {
+		filename string
+		mode     Mode
+		expected []QuickfixEntry
+	}{
+		{"gotest.json", modeGo, []QuickfixEntry{
+			{"/src/a_test.go", 8, 0, "error", "expected 5, got 4"},
+			{"", 0, 0, "error", "TestTest (0.00s)"},
+		}},
+		{"gotest_build.json", modeGo, []QuickfixEntry{
+			{"/src/b_test.go", 2, 15, "error", "undefined: testing"},
+		}},
+		{"govet.json", modeGo, []QuickfixEntry{
+			{"/src/v.go", 3, 23, "warning", `printf: fmt.Printf format %d has arg "x" of wrong type string`},
+		}},
+		{"cargo.json", modeRust, []QuickfixEntry{
+			{"/src/src/main.rs", 3, 5, "error", "cannot find macro `rintln` in this scope"},
+		}},
+		{"gcc.json", modeC, []QuickfixEntry{
+			{"/src/main.c", 1, 28, "error", "'x' undeclared (first use in this function)"},
+			{"/src/main.c", 1, 18, "warning", "unused variable 'y'"},
+		}},
+		// Output that is not JSON is parsed with the error formats
+		{"gcc.txt", modeC, []QuickfixEntry{
+			{"/src/main.c", 4, 10, "error", "'x' undeclared (first use in this function)"},
+			{"/src/main.c", 4, 10, "note", "each undeclared identifier is reported only once for each function it appears in"},
+			{"/src/main.c", 3, 7, "warning", "unused variable 'y' [-Wunused-variable]"},
+		}},
+	}
+	for _, sample := range samples {
+		data, err := ioutil.ReadFile(filepath.Join("test", "errorformat", sample.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := ParseDiagnostics(string(data), "/src", sample.mode)
+		if len(entries) != len(sample.expected) {
+			t.Errorf("%s: expected %d entries, got %d: %v", sample.filename, len(sample.expected), len(entries), entries)
+			continue
+		}
+		for i, entry := range entries {
+			if entry != sample.expected[i] {
+				t.Errorf("%s: expected %v, got %v", sample.filename, sample.expected[i], entry)
+			}
+		}
+	}
+}
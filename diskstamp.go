@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xyproto/vt100"
+)
+
+// How often the open files are checked for changes made by other programs
+const diskCheckInterval = 2 * time.Second
+
+var (
+	errChangedOnDisk = errors.New("the file has been changed on disk, press ctrl-s to resolve")
+
+	// The state of the files on disk when they were loaded or saved, by absolute filename
+	diskStamps    = make(map[string]*DiskStamp)
+	diskStampsMut = &sync.RWMutex{}
+)
+
+// DiskStamp is what a file looked like on disk when it was loaded or last saved.
+// The contents are kept around, so that they can be used as the base for a three-way merge.
+type DiskStamp struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	data    []byte
+	warned  bool // has the user been warned about the file being changed on disk?
+}
+
+// NewDiskStamp records the modification time, size and hash of the given file.
+// If data is nil, the file is read.
+func NewDiskStamp(filename string, data []byte) (*DiskStamp, error) {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data, err = ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &DiskStamp{fileInfo.ModTime(), fileInfo.Size(), sha256.Sum256(data), data, false}, nil
+}
+
+// changedOnDisk checks if the file on disk differs from what it looked like when
+// it was loaded or saved. Only the modification time and size are checked first,
+// so that files that are touched, but not changed, are not reported. The modification time and size
+// of such files are then updated, so that they are not read again the next time.
+// If the file has been removed, it is not considered to be changed.
+// diskStampsMut must be locked for writing when calling this.
+func (ds *DiskStamp) changedOnDisk(filename string) bool {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	if fileInfo.ModTime().Equal(ds.modTime) && fileInfo.Size() == ds.size {
+		return false
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	if sha256.Sum256(data) != ds.hash {
+		return true
+	}
+	ds.modTime, ds.size = fileInfo.ModTime(), fileInfo.Size()
+	return false
+}
+
+// RecordDiskStamp stores what the given file looks like on disk right now.
+// If data is nil, the file is read.
+func RecordDiskStamp(absFilename string, data []byte) {
+	ds, err := NewDiskStamp(absFilename, data)
+	diskStampsMut.Lock()
+	defer diskStampsMut.Unlock()
+	if err != nil {
+		delete(diskStamps, absFilename)
+		return
+	}
+	diskStamps[absFilename] = ds
+}
+
+// ChangedOnDisk checks if the given file has been changed by another program
+// since it was loaded or saved by this editor
+func ChangedOnDisk(absFilename string) bool {
+	diskStampsMut.Lock()
+	defer diskStampsMut.Unlock()
+	ds, ok := diskStamps[absFilename]
+	return ok && ds.changedOnDisk(absFilename)
+}
+
+// loadedData returns the contents of the given file, as it was when it was loaded or saved
+func loadedData(absFilename string) ([]byte, bool) {
+	diskStampsMut.RLock()
+	defer diskStampsMut.RUnlock()
+	ds, ok := diskStamps[absFilename]
+	if !ok {
+		return nil, false
+	}
+	return ds.data, true
+}
+
+// WatchDisk starts a goroutine that regularly checks if any of the loaded files
+// have been changed by another program, and warns about it in the status bar, once per change.
+// The given lock is held while drawing, so that the warning is only shown while the main loop
+// is waiting for a keypress.
+func WatchDisk(c *vt100.Canvas, e *Editor, status *StatusBar, lock sync.Locker) {
+	go func() {
+		for {
+			time.Sleep(diskCheckInterval)
+			diskStampsMut.Lock()
+			var changed []string
+			for absFilename, ds := range diskStamps {
+				if !ds.warned && ds.changedOnDisk(absFilename) {
+					ds.warned = true
+					changed = append(changed, absFilename)
+				}
+			}
+			diskStampsMut.Unlock()
+			if len(changed) == 0 {
+				continue
+			}
+			lock.Lock()
+			for _, absFilename := range changed {
+				status.ClearAll(c)
+				status.SetErrorMessage(filepath.Base(absFilename) + " was changed on disk by another program")
+				status.Show(c, e)
+			}
+			vt100.SetXY(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()))
+			lock.Unlock()
+		}
+	}()
+}
+
+// mergeThreeWay merges the changes from base to ours with the changes from base to theirs,
+// by using "git merge-file" or "diff3". Conflicts are marked in the returned data.
+// Returns the merged data and true if there were conflicts.
+func mergeThreeWay(ours, base, theirs []byte) ([]byte, bool, error) {
+	tempDir, err := ioutil.TempDir("", "o_merge")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	oursFilename := filepath.Join(tempDir, "ours")
+	baseFilename := filepath.Join(tempDir, "loaded")
+	theirsFilename := filepath.Join(tempDir, "disk")
+	for filename, data := range map[string][]byte{oursFilename: ours, baseFilename: base, theirsFilename: theirs} {
+		if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var cmd *exec.Cmd
+	if which("git") != "" {
+		cmd = exec.Command("git", "merge-file", "-p", "-L", "editor", "-L", "loaded", "-L", "disk", oursFilename, baseFilename, theirsFilename)
+	} else if which("diff3") != "" {
+		cmd = exec.Command("diff3", "-m", "-L", "editor", "-L", "loaded", "-L", "disk", oursFilename, baseFilename, theirsFilename)
+	} else {
+		return nil, false, errors.New("three-way merging requires either git or diff3")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() > 0 && exitError.ExitCode() < 128 && stderr.Len() == 0 {
+		// Both git merge-file and diff3 exit with a positive exit code if there are conflicts
+		return stdout.Bytes(), true, nil
+	} else if err != nil {
+		if stderr.Len() > 0 {
+			return nil, false, errors.New(string(bytes.TrimSpace(stderr.Bytes())))
+		}
+		return nil, false, err
+	}
+	return stdout.Bytes(), false, nil
+}
+
+// ResolveChangedOnDisk presents a menu for what to do when the file is about to be saved,
+// but has been changed on disk since it was loaded. The file can be reloaded, overwritten
+// or the changes can be merged with a three-way merge against the loaded version.
+// Returns a status message, and true if the file should be saved.
+func (e *Editor) ResolveChangedOnDisk(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar) (string, bool) {
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return err.Error(), false
+	}
+	choices := []string{
+		"Reload the file from disk (discard my changes)",
+		"Save anyway (overwrite the changes on disk)",
+		"Merge the changes on disk with my changes",
+		"Cancel",
+	}
+	title := filepath.Base(absFilename) + " has been changed on disk"
+	selected := e.Menu(status, tty, title, choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 2, false)
+	e.redraw = true
+	e.redrawCursor = true
+
+	switch selected {
+	case 0: // reload
+		undo.Snapshot(e)
+		if _, err := e.Load(c, tty, e.filename); err != nil {
+			return err.Error(), false
+		}
+		RecordDiskStamp(absFilename, nil)
+		e.changed = false
+		if e.AfterEndOfLine() {
+			e.End(c)
+		}
+		return "Reloaded " + e.filename, false
+	case 1: // overwrite
+		// Accept what is on disk now as the loaded version, so that it can be overwritten
+		RecordDiskStamp(absFilename, nil)
+		return "", true
+	case 2: // merge
+		base, ok := loadedData(absFilename)
+		if !ok {
+			return "Could not find the loaded version of " + e.filename, false
+		}
+		theirs, err := ioutil.ReadFile(absFilename)
+		if err != nil {
+			return err.Error(), false
+		}
+		merged, conflicts, err := mergeThreeWay([]byte(e.String()), base, theirs)
+		if err != nil {
+			return err.Error(), false
+		}
+		undo.Snapshot(e)
+		e.LoadBytes(merged)
+		e.changed = true
+		if e.AfterEndOfLine() {
+			e.End(c)
+		}
+		// The changes on disk are now part of the editor contents
+		RecordDiskStamp(absFilename, theirs)
+		if conflicts {
+			return "Merged, but there are conflicts marked with <<<<<<<, resolve them and save", false
+		}
+		return "Merged the changes on disk, press ctrl-s to save", false
+	}
+	return "", false
+}
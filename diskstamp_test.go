@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedOnDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_stamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(filename, []byte("hello\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	RecordDiskStamp(filename, nil)
+	if ChangedOnDisk(filename) {
+		t.Error("the file has not been changed yet")
+	}
+
+	// Touching the file should not count as a change
+	later := time.Now().Add(time.Minute).Truncate(time.Second)
+	os.Chtimes(filename, later, later)
+	if ChangedOnDisk(filename) {
+		t.Error("touching the file is not a change")
+	}
+	// The new modification time is remembered, so that the file is not read again every time
+	diskStampsMut.RLock()
+	modTime := diskStamps[filename].modTime
+	diskStampsMut.RUnlock()
+	if !modTime.Equal(later) {
+		t.Errorf("expected the modification time to be updated to %v, got %v", later, modTime)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("hello there\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !ChangedOnDisk(filename) {
+		t.Error("the file has been changed")
+	}
+
+	// A removed file is not a change, it can just be saved again
+	os.Remove(filename)
+	if ChangedOnDisk(filename) {
+		t.Error("a removed file is not a change")
+	}
+}
+
+func TestMergeThreeWay(t *testing.T) {
+	if which("git") == "" && which("diff3") == "" {
+		t.Skip("requires git or diff3")
+	}
+	base := []byte("one\ntwo\nthree\nfour\n")
+	ours := []byte("one\ntwo 2\nthree\nfour\n")
+	theirs := []byte("one\ntwo\nthree\nfour 4\n")
+	merged, conflicts, err := mergeThreeWay(ours, base, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conflicts || string(merged) != "one\ntwo 2\nthree\nfour 4\n" {
+		t.Errorf("unexpected merge result: %q", string(merged))
+	}
+
+	theirs = []byte("one\ntwo II\nthree\nfour\n")
+	merged, conflicts, err = mergeThreeWay(ours, base, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conflicts || !strings.Contains(string(merged), "<<<<<<<") {
+		t.Errorf("expected a conflict, got: %q", string(merged))
+	}
+}
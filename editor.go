@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -43,12 +42,15 @@ type Editor struct {
 	mode               Mode                  // a filetype mode, like for git or markdown
 	filename           string                // the current filename
 	locationHistory    map[string]LineNumber // location history, for jumping to the last location when opening a file
+	bookmarks          Bookmarks             // named or numbered lines, that follow lines being inserted or deleted
+	hotSpots           HotSpots              // lines where the cursor has spent the most time lately
 	quit               bool                  // for indicating if the user wants to end the editor session
 	clearOnQuit        bool                  // clear the terminal when quitting the editor, or not
 	wrapWhenTyping     bool                  // wrap text at a certain limit when typing
 	lightTheme         bool                  // using a light theme? (the XTERM_VERSION environment variable is set)
 	noColor            bool                  // should no color be used?
 	firstLineHash      bool                  // is the first line starting with "#"?
+	backupErr          error                 // set if the last save could not keep a backup of the previous version
 	EditorColors
 }
 
@@ -532,6 +534,12 @@ func (e *Editor) PrepareEmpty(c *vt100.Canvas, tty *vt100.TTY, filename string)
 func (e *Editor) Save(c *vt100.Canvas) error {
 	var data []byte
 
+	// Don't overwrite changes that another program has made to the file since it was loaded
+	absFilename, absErr := e.AbsFilename()
+	if absErr == nil && ChangedOnDisk(absFilename) {
+		return errChangedOnDisk
+	}
+
 	// Save the current position
 	bookmark := e.pos.Copy()
 
@@ -565,38 +573,54 @@ func (e *Editor) Save(c *vt100.Canvas) error {
 		}
 	}
 
-	// Mark the data as "not changed"
-	e.changed = false
-
 	// Should the file be saved with the executable bit enabled?
 	// (Does it either start with a shebang or reside in a common bin directory like /usr/bin?)
 	shebang := bytes.HasPrefix(data, []byte{'#', '!'}) || aBinDirectory(e.filename)
 
-	// Default file mode (0644 for regular files, 0755 for executable files)
-	var fileMode os.FileMode = 0644
+	// Use the permissions of the existing file, or 0644 minus the umask for new files
+	fileMode, _, _, found := fileModeAndOwner(resolveSymlinks(e.filename))
+	if !found {
+		fileMode = 0644
+	}
 
 	// Checking the syntax highlighting makes it easy to press `ctrl-t` before saving a script,
 	// to toggle the executable bit on or off. This is only for files that start with "#!".
 	// Also, if the file is in one of the common bin directories, like "/usr/bin", then assume that it
 	// is supposed to be executable.
-	if shebang && e.syntaxHighlight {
-		// This is both a script file and the syntax highlight is enabled.
-		fileMode = 0755
+	// rust source may start with something like "#![feature(core_intrinsics)]", so avoid that.
+	if shebang && e.mode != modeRust {
+		if e.syntaxHighlight {
+			// This is both a script file and the syntax highlight is enabled: "chmod +x"
+			fileMode |= 0111
+		} else if found {
+			// "chmod -x"
+			fileMode &^= 0111
+		}
+	}
+	if !found {
+		fileMode &^= umask
 	}
 
-	// Save the file and return any errors
-	if err := ioutil.WriteFile(e.filename, data, fileMode); err != nil {
+	// Save the file and return any errors. A backup that could not be made is not an error,
+	// but it is remembered, so that the user can be told.
+	backupErr, err := saveFile(e.filename, data, fileMode, keepBackup)
+	if err != nil {
 		return err
 	}
+	e.backupErr = backupErr
+
+	// Remember what the file looks like on disk now
+	if absErr == nil {
+		RecordDiskStamp(absFilename, data)
+	}
 
-	// "chmod +x" or "chmod -x". This is needed after saving the file, in order to toggle the executable bit.
-	// rust source may start with something like "#![feature(core_intrinsics)]", so avoid that.
 	if shebang && e.mode != modeRust {
-		// Call Chmod, but ignore errors (since this is just a bonus and not critical)
-		os.Chmod(e.filename, fileMode)
 		e.SetSyntaxHighlight(true)
 	}
 
+	// Mark the data as "not changed"
+	e.changed = false
+
 	e.redrawCursor = true
 
 	// Trailing spaces may be trimmed, so move to the end, if needed
@@ -713,6 +737,9 @@ func (e *Editor) DeleteLine(n LineIndex) {
 		// The line numbers and the length of e.lines does not match
 		return
 	}
+	// Bookmarks after this line are moved one line up
+	e.bookmarks = e.bookmarks.Shift(n+1, -1)
+
 	// Shift all lines after y:
 	// shift all lines after n one step closer to n, overwriting e.lines[n]
 	for index := n; index <= (maxIndex - 1); index++ {
@@ -935,6 +962,9 @@ func (e *Editor) InsertLineAbove() {
 	// Create new set of lines
 	lines2 := make(map[int][]rune)
 
+	// Bookmarks from this line and down are moved one line down
+	e.bookmarks = e.bookmarks.Shift(LineIndex(y), 1)
+
 	// If at the first line, just add a line at the top
 	if y == 0 {
 
@@ -1002,6 +1032,9 @@ func (e *Editor) InsertLineBelowAt(index LineIndex) {
 	// Create new set of lines, with room for one more
 	lines2 := make(map[int][]rune, len(e.lines)+1)
 
+	// Bookmarks below this line are moved one line down
+	e.bookmarks = e.bookmarks.Shift(index+1, 1)
+
 	// For each line in the old map, if at y, insert a blank line
 	// (insert a blank line below)
 	for k, v := range e.lines {
@@ -2278,13 +2311,18 @@ func (e *Editor) Switch(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *
 	if err != nil {
 		return err
 	}
-	// Unlock and save the lock file
-	lk.Unlock(absFilename)
-	lk.Save()
+	// Unlock the current file
+	if lk != nil {
+		lk.Unlock(absFilename)
+	}
 	// Now open the header filename instead of the current file. Save the current file first.
 	e.Save(c)
 	// Save the current location in the location history and write it to file
 	e.SaveLocation(absFilename, e.locationHistory)
+	// Make it possible to jump back to this location
+	jumpList.Record(e.jumpAt(e.DataY()))
+	// Let the language server know that this file is no longer being edited
+	e.LSPClose()
 
 	var (
 		e2            *Editor
@@ -2312,6 +2350,9 @@ func (e *Editor) Switch(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *
 		undo, switchUndoBackup = switchUndoBackup, undo
 	}
 
+	// Open the new file with a language server, if one is installed
+	e.StartLSP()
+
 	e.redraw = true
 	e.redrawCursor = true
 
@@ -2358,15 +2399,22 @@ func (e *Editor) LineContentsFromCursorPosition() string {
 
 // LettersBeforeCursor returns the current word up until the cursor (for autocompletion)
 func (e *Editor) LettersBeforeCursor() string {
-	y := int(e.DataY())
-	runes, ok := e.lines[y]
+	// Either find x or use the last index of the line
+	x, err := e.DataX()
+	if err != nil {
+		x = len(e.lines[int(e.DataY())])
+	}
+	return e.LettersBefore(e.DataY(), x)
+}
+
+// LettersBefore returns the letters before the given position, on the given line
+func (e *Editor) LettersBefore(y LineIndex, x int) string {
+	runes, ok := e.lines[int(y)]
 	if !ok {
 		// This should never happen
 		return ""
 	}
-	// Either find x or use the last index of the line
-	x, err := e.DataX()
-	if err != nil {
+	if x > len(runes) {
 		x = len(runes)
 	}
 
@@ -76,3 +76,37 @@ func ExampleEditor_InsertRune() {
 	// text
 	//  -- comment
 }
+
+func ExampleEditor_ToggleBookmark() {
+	e := NewSimpleEditor(80)
+	e.LoadBytes([]byte("one\ntwo\nthree\nfour\n"))
+	e.GoTo(2, nil, nil)
+	fmt.Println(e.ToggleBookmark())
+
+	// Insert a line above the bookmark, then delete two lines above it
+	e.InsertLineBelowAt(0)
+	fmt.Println(e.bookmarks)
+	e.DeleteLine(0)
+	e.DeleteLine(0)
+	fmt.Println(e.bookmarks)
+
+	// Delete the line above a bookmark
+	e = NewSimpleEditor(80)
+	e.LoadBytes([]byte("a\nb\nc\nd\n"))
+	e.GoTo(2, nil, nil)
+	e.ToggleBookmark()
+	e.DeleteLine(1)
+	fmt.Println(e.bookmarks, e.Line(1))
+
+	// Delete a line with a bookmark, when there is a bookmark on the line below it
+	e.GoTo(0, nil, nil)
+	e.ToggleBookmark()
+	e.DeleteLine(0)
+	fmt.Println(e.bookmarks, e.Line(0))
+	// Output:
+	// Added bookmark 1 at line 3
+	// 1=4
+	// 1=2
+	// 1=2 c
+	// 1=1 c
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errorFormatFilename is a file where users can add their own error formats, like this:
+//
+//	[rust]
+//	^(?P<severity>error|warning)(\[\w+\])?: (?P<message>.+)$
+//		^\s*--> (?P<file>[^:]+):(?P<line>\d+):(?P<col>\d+)
+//
+// Each line is a regular expression with named groups for file, line, col, severity and message.
+// An indented line continues the error format on the line above, and must match one of the next
+// maxErrorFormatGap lines of the output. Error formats in a section named after the mode are only
+// used for files in that mode, while error formats before the first section are used for all modes.
+const errorFormatFilename = "~/.config/o/errorformat.conf"
+
+// maxErrorFormatGap is how many lines after the previous match the next regular expression
+// of a multi-line error format in the user configuration may match
+const maxErrorFormatGap = 8
+
+// ErrorFormat describes how an error or warning looks in the output of a compiler or test runner.
+// The regular expressions can have named groups for file, line, col, severity and message.
+// For multi-line formats, each regular expression after the first one must match one of the
+// next "within" lines after the line that matched the previous one.
+type ErrorFormat struct {
+	modes    []Mode // the modes this error format is for, or nil for all modes
+	patterns []*regexp.Regexp
+	within   int
+}
+
+// newErrorFormat creates a new ErrorFormat for the given modes (nil for all modes),
+// where the regular expressions after the first one must match within the given number of lines
+func newErrorFormat(modes []Mode, within int, patterns ...string) ErrorFormat {
+	ef := ErrorFormat{modes: modes, within: within}
+	for _, pattern := range patterns {
+		ef.patterns = append(ef.patterns, regexp.MustCompile(pattern))
+	}
+	return ef
+}
+
+// builtinErrorFormats are tried in order, after the ones the user has added.
+// More specific formats must come before the more general ones.
+var builtinErrorFormats = []ErrorFormat{
+	// Rust, where the location is on the line after the message:
+	//  error[E0425]: cannot find value `x` in this scope
+	//   --> src/main.rs:2:5
+	newErrorFormat(nil, 1,
+		`^(?P<severity>error|warning)(?:\[\w+\])?: (?P<message>.+)$`,
+		`^\s*--> (?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+)$`),
+
+	// A Rust panic, like a failed assertion in a test:
+	//  thread 'tests::it_works' panicked at src/lib.rs:10:9:
+	//  assertion `left == right` failed
+	newErrorFormat(nil, 1,
+		`^thread '[^']*' panicked at (?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+):$`,
+		`^(?P<message>.+)$`),
+
+	// A Rust panic, from versions of Rust before 1.73:
+	//  thread 'tests::it_works' panicked at 'assertion failed: x', src/lib.rs:10:9
+	newErrorFormat(nil, 0,
+		`^thread '[^']*' panicked at '(?P<message>.*)', (?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+)$`),
+
+	// A frame in a Rust backtrace, from RUST_BACKTRACE=1:
+	//     3: rp::f
+	//               at ./src/main.rs:2:5
+	newErrorFormat(nil, 1,
+		`^\s+\d+: (?P<message>\S.*)$`,
+		`^\s+at (?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+)$`),
+
+	// A frame in the stack trace of a Go panic, where the location is on the line after the function:
+	//  main.(*T).f(...)
+	//  	/src/main.go:6 +0x1d
+	newErrorFormat(nil, 1,
+		`^(?P<message>(?:created by )?\S+?)(?:\([^()]*\))?(?: in goroutine \d+)?$`,
+		`^\t(?P<file>\S+\.go):(?P<line>\d+)(?: \+0x[0-9a-f]+)?$`),
+
+	// Crystal, where the message comes a few lines after the location:
+	//  In hello.cr:1:1
+	//  Error: undefined local variable or method 'asdf'
+	newErrorFormat([]Mode{modeCrystal}, maxErrorFormatGap,
+		`^In (?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+)$`,
+		`^Error: (?P<message>.+)$`),
+
+	// Haskell, where the message is on the line after the location:
+	//  main.hs:3:8: error:
+	//      • Variable not in scope: asdf
+	newErrorFormat([]Mode{modeHaskell}, 1,
+		`^(?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+)(?:-\d+)?: (?P<severity>error|warning):`,
+		`^\s+• (?P<message>.+)$`),
+
+	// Python, where the message is at the end of the traceback:
+	//    File "main.py", line 8
+	//  SyntaxError: invalid syntax
+	newErrorFormat(nil, 20,
+		`^\s*File "(?P<file>[^"<]+)", line (?P<line>\d+)`,
+		`^(?:\w+\.)*\w*(?:Error|Exception): (?P<message>\S.*)$`),
+
+	// Odin:
+	//  main.odin(7:3) Error: Undeclared name: z
+	newErrorFormat(nil, 0,
+		`^(?P<file>[^\s(]+)\((?P<line>\d+):(?P<col>\d+)\) (?:(?P<severity>Syntax Error|Error|Warning): )?(?P<message>.+)$`),
+
+	// Object Pascal:
+	//  main.pas(4,1) Fatal: Syntax error, ";" expected
+	newErrorFormat(nil, 0,
+		`^(?P<file>[^\s(]+)\((?P<line>\d+),(?P<col>\d+)\) (?P<severity>Error|Fatal|Warning|Note|Hint): (?P<message>.+)$`),
+
+	// Lua, where the name of the executable comes first:
+	//  luac: main.lua:3: '=' expected near 'x'
+	newErrorFormat([]Mode{modeLua}, 0,
+		`^\S+: (?P<file>[^:\s]+):(?P<line>\d+): (?P<message>.+)$`),
+
+	// Kotlin:
+	//  e: file:///src/main.kt:3:5 Unresolved reference 'x'.
+	newErrorFormat(nil, 0,
+		`^(?P<severity>e|w): (?:file://)?(?P<file>[^:\s]+):(?P<line>\d+):(?P<col>\d+):? (?P<message>.+)$`),
+
+	// A failed Go test, without a location:
+	//  --- FAIL: TestTest (0.00s)
+	newErrorFormat([]Mode{modeGo}, 0,
+		`^\s*--- FAIL: (?P<message>.+)$`),
+
+	// Go, C, C++, Zig, Haskell, Kotlin, go test and many others:
+	//  main.c:4:10: error: 'x' undeclared
+	//  ./main.go:6:2: undefined: asdfasdf
+	//      main_test.go:12: expected 2, got 3
+	newErrorFormat(nil, 0,
+		`^\s*(?P<file>[^\s:()"']+):(?P<line>\d+)(?::(?P<col>\d+))?: (?:(?P<severity>(?i:fatal error|error|fatal|warning|note)): ?)?(?P<message>\S.*)$`),
+}
+
+var (
+	userErrorFormats     []ErrorFormat
+	userErrorFormatsOnce sync.Once
+)
+
+// parseErrorFormats parses error formats from the contents of a configuration file.
+// Lines with invalid regular expressions are skipped.
+func parseErrorFormats(text string) []ErrorFormat {
+	var (
+		formats []ErrorFormat
+		modes   []Mode
+		skip    bool // skip the continuation lines of an invalid error format
+	)
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := strings.ToLower(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			modes = nil
+			for mode, modeName := range modeNames {
+				if modeName == name {
+					modes = []Mode{mode}
+					break
+				}
+			}
+			continue
+		}
+		re, err := regexp.Compile(trimmed)
+		continuation := strings.TrimLeft(line, " \t") != line
+		switch {
+		case continuation && len(formats) > 0:
+			if !skip && err == nil {
+				formats[len(formats)-1].patterns = append(formats[len(formats)-1].patterns, re)
+			}
+		case err == nil:
+			formats = append(formats, ErrorFormat{modes: modes, patterns: []*regexp.Regexp{re}, within: maxErrorFormatGap})
+			skip = false
+		default:
+			skip = true
+		}
+	}
+	return formats
+}
+
+// ErrorFormats returns the error formats for the given mode, the ones from the user first
+func ErrorFormats(mode Mode) []ErrorFormat {
+	userErrorFormatsOnce.Do(func() {
+		if data, err := ioutil.ReadFile(expandUser(errorFormatFilename)); err == nil {
+			userErrorFormats = parseErrorFormats(string(data))
+		}
+	})
+	var formats []ErrorFormat
+	for _, ef := range append(append([]ErrorFormat{}, userErrorFormats...), builtinErrorFormats...) {
+		if ef.For(mode) {
+			formats = append(formats, ef)
+		}
+	}
+	return formats
+}
+
+// For checks if this error format should be used for the given mode
+func (ef ErrorFormat) For(mode Mode) bool {
+	if ef.modes == nil {
+		return true
+	}
+	for _, m := range ef.modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Match tries to match the error format at the given line, and returns the named groups
+// from all the regular expressions. The first non-empty value of a group is used.
+func (ef ErrorFormat) Match(lines []string, i int) (map[string]string, bool) {
+	groups := make(map[string]string)
+	collect := func(re *regexp.Regexp, line string) bool {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			return false
+		}
+		for j, name := range re.SubexpNames() {
+			if name != "" && groups[name] == "" {
+				groups[name] = strings.TrimSpace(match[j])
+			}
+		}
+		return true
+	}
+	if len(ef.patterns) == 0 || !collect(ef.patterns[0], lines[i]) {
+		return nil, false
+	}
+	for _, re := range ef.patterns[1:] {
+		found := false
+		for j := i + 1; j <= i+ef.within && j < len(lines); j++ {
+			if collect(re, lines[j]) {
+				i, found = j, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return groups, true
+}
+
+// normalizeSeverity returns "error", "warning" or "note"
+func normalizeSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "w", "warning":
+		return "warning"
+	case "note", "hint", "info":
+		return "note"
+	}
+	return "error"
+}
+
+// ParseErrors finds all errors and warnings in the output of a compiler or test runner,
+// using the error formats for the given mode. Relative filenames are relative to the given
+// directory. Errors without a location, like failed Go tests, have an empty filename.
+func ParseErrors(output, dir string, mode Mode) []QuickfixEntry {
+	var (
+		entries []QuickfixEntry
+		seen    = make(map[string]bool)
+		lines   = strings.Split(strings.Replace(output, "\r\n", "\n", -1), "\n")
+		formats = ErrorFormats(mode)
+	)
+	for i := range lines {
+		for _, ef := range formats {
+			groups, ok := ef.Match(lines, i)
+			if !ok {
+				continue
+			}
+			entry := QuickfixEntry{severity: normalizeSeverity(groups["severity"]), message: groups["message"]}
+			if filename := groups["file"]; filename != "" {
+				entry.filename = absolute(filename, dir)
+				entry.line, _ = strconv.Atoi(groups["line"])
+				entry.col, _ = strconv.Atoi(groups["col"])
+			}
+			if key := entry.String(); !seen[key] {
+				seen[key] = true
+				entries = append(entries, entry)
+			}
+			break
+		}
+	}
+	return entries
+}
+
+// FirstError returns the error that should be shown first: an error in the given file if there
+// is one, or else the first error with a location, or else the first error
+func FirstError(entries []QuickfixEntry, absFilename string) (QuickfixEntry, bool) {
+	var found []QuickfixEntry
+	for _, entry := range entries {
+		if entry.severity == "error" {
+			found = append(found, entry)
+		}
+	}
+	for _, entry := range found {
+		if entry.filename == absFilename {
+			return entry, true
+		}
+	}
+	for _, entry := range found {
+		if entry.filename != "" {
+			return entry, true
+		}
+	}
+	if len(found) > 0 {
+		return found[0], true
+	}
+	return QuickfixEntry{}, false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	output := `# example.com/p
+./main.go:5:2: undefined: asdf
+./util.go:12:8: x declared but not used
+main.c:3:10: warning: unused variable 'y' [-Wunused-variable]
+main.c:3:10: warning: unused variable 'y' [-Wunused-variable]
+error[E0425]: cannot find value ` + "`x`" + ` in this scope
+ --> src/main.rs:2:5
+/abs/main.odin(7:3) Error: Undeclared name: z
+prog.pas(4,1) Fatal: Syntax error
+`
+	entries := ParseErrors(output, "/src", modeBlank)
+	expected := []QuickfixEntry{
+		{"/src/main.go", 5, 2, "error", "undefined: asdf"},
+		{"/src/util.go", 12, 8, "error", "x declared but not used"},
+		{"/src/main.c", 3, 10, "warning", "unused variable 'y' [-Wunused-variable]"},
+		{"/src/src/main.rs", 2, 5, "error", "cannot find value `x` in this scope"},
+		{"/abs/main.odin", 7, 3, "error", "Undeclared name: z"},
+		{"/src/prog.pas", 4, 1, "error", "Syntax error"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %v", len(expected), len(entries), entries)
+	}
+	for i, entry := range entries {
+		if entry != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], entry)
+		}
+	}
+}
+
+func TestErrorFormatSamples(t *testing.T) {
+	samples := []struct {
+		filename string
+		mode     Mode
+		source   string // the file that was built
+		expected QuickfixEntry
+	}{
+		{"go.txt", modeGo, "main.go", QuickfixEntry{"/src/main.go", 6, 2, "error", "undefined: asdfasdf"}},
+		{"gotest.txt", modeGo, "main_test.go", QuickfixEntry{"/src/main_test.go", 12, 0, "error", "expected 4, got 5"}},
+		{"rustc.txt", modeRust, "err.rs", QuickfixEntry{"/src/err.rs", 2, 5, "error", "cannot find macro `rintln` in this scope"}},
+		{"gcc.txt", modeC, "main.c", QuickfixEntry{"/src/main.c", 4, 10, "error", "'x' undeclared (first use in this function)"}},
+		{"python.txt", modePython, "main.py", QuickfixEntry{"/src/main.py", 8, 0, "error", "invalid syntax"}},
+		{"python_runtime.txt", modePython, "main.py", QuickfixEntry{"/src/main.py", 4, 0, "error", "division by zero"}},
+		{"crystal.txt", modeCrystal, "hello.cr", QuickfixEntry{"/src/hello.cr", 3, 3, "error", "undefined local variable or method 'asdf' for top-level"}},
+		{"ghc.txt", modeHaskell, "main.hs", QuickfixEntry{"/src/main.hs", 3, 8, "error", "Variable not in scope: asdf :: IO ()"}},
+		{"odin.txt", modeOdin, "main.odin", QuickfixEntry{"/src/main.odin", 7, 3, "error", "Undeclared name: z"}},
+		{"fpc.txt", modeObjectPascal, "main.pas", QuickfixEntry{"/src/main.pas", 4, 1, "error", `Syntax error, ";" expected but "END" found`}},
+		{"luac.txt", modeLua, "main.lua", QuickfixEntry{"/src/main.lua", 3, 0, "error", "'=' expected near 'world'"}},
+		{"kotlinc.txt", modeKotlin, "main.kt", QuickfixEntry{"/src/main.kt", 2, 5, "error", "unresolved reference: printn"}},
+		{"kotlinc_k2.txt", modeKotlin, "main.kt", QuickfixEntry{"/src/main.kt", 2, 5, "error", "Unresolved reference 'printn'."}},
+		{"zig.txt", modeZig, "main.zig", QuickfixEntry{"/src/main.zig", 4, 5, "error", "use of undeclared identifier 'asdf'"}},
+		{"gopanic.txt", modeGo, "main.go", QuickfixEntry{"/src/main.go", 6, 0, "error", "main.(*T).f"}},
+		{"rustbacktrace.txt", modeRust, "src/main.rs", QuickfixEntry{"/src/src/main.rs", 2, 5, "error", "index out of bounds: the len is 3 but the index is 5"}},
+	}
+	for _, sample := range samples {
+		data, err := ioutil.ReadFile(filepath.Join("test", "errorformat", sample.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, ok := FirstError(ParseErrors(string(data), "/src", sample.mode), filepath.Join("/src", sample.source))
+		if !ok || entry != sample.expected {
+			t.Errorf("%s: expected %v, got %v", sample.filename, sample.expected, entry)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	for filename, expected := range map[string][]string{
+		"gopanic.txt":       {"/src/main.go:6: error: main.(*T).f", "/src/main.go:11: error: main.main"},
+		"rustbacktrace.txt": {"/src/src/main.rs:2:5: error: index out of bounds: the len is 3 but the index is 5", "/src/src/main.rs:2:5: error: rp::f", "/src/src/main.rs:6:20: error: rp::main"},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("test", "errorformat", filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, entry := range ParseErrors(string(data), "/src", modeBlank) {
+			if strings.HasPrefix(entry.filename, "/src/") {
+				found = append(found, entry.String())
+			}
+		}
+		if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%s: expected %q, got %q", filename, expected, found)
+		}
+	}
+}
+
+func TestFailedGoTest(t *testing.T) {
+	entries := ParseErrors("test will now fail\n--- FAIL: TestTest (0.00s)\nFAIL\n", "/src", modeGo)
+	if entry, ok := FirstError(entries, "/src/main_test.go"); !ok || entry.filename != "" || entry.message != "TestTest (0.00s)" {
+		t.Errorf("expected the failed test without a location, got %v", entry)
+	}
+}
+
+func TestParseErrorFormats(t *testing.T) {
+	formats := parseErrorFormats(`# comment
+^ERR (?P<file>\S+) (?P<line>\d+)$
+	^  (?P<message>.+)$
+
+[go]
+^oops (?P<message>.+)$
+^(invalid$
+	^skipped$
+`)
+	if len(formats) != 2 || len(formats[0].patterns) != 2 || formats[0].modes != nil || !formats[1].For(modeGo) || formats[1].For(modeC) {
+		t.Fatalf("unexpected error formats: %v", formats)
+	}
+	lines := []string{"ERR main.x 3", "", "  it broke"}
+	groups, ok := formats[0].Match(lines, 0)
+	if !ok || groups["file"] != "main.x" || groups["line"] != "3" || groups["message"] != "it broke" {
+		t.Errorf("unexpected match: %v", groups)
+	}
+}
@@ -52,6 +52,56 @@ const (
 // Mode is a per-filetype mode, like for Markdown
 type Mode int
 
+// modeNames are short names for the modes, used for naming configuration files, like "go.snippets"
+var modeNames = map[Mode]string{
+	modeBlank:          "blank",
+	modeGit:            "git",
+	modeMarkdown:       "markdown",
+	modeMakefile:       "make",
+	modeShell:          "sh",
+	modeConfig:         "config",
+	modeAssembly:       "asm",
+	modeGo:             "go",
+	modeHaskell:        "haskell",
+	modeOCaml:          "ocaml",
+	modeStandardML:     "sml",
+	modePython:         "python",
+	modeText:           "text",
+	modeCMake:          "cmake",
+	modeVim:            "vim",
+	modeLisp:           "lisp",
+	modeZig:            "zig",
+	modeKotlin:         "kotlin",
+	modeJava:           "java",
+	modeHIDL:           "hidl",
+	modeSQL:            "sql",
+	modeOak:            "oak",
+	modeRust:           "rust",
+	modeLua:            "lua",
+	modeCrystal:        "crystal",
+	modeNim:            "nim",
+	modeObjectPascal:   "pascal",
+	modeBat:            "bat",
+	modeCpp:            "cpp",
+	modeC:              "c",
+	modeAda:            "ada",
+	modeHTML:           "html",
+	modeOdin:           "odin",
+	modeXML:            "xml",
+	modePolicyLanguage: "policy",
+	modeNroff:          "nroff",
+	modeScala:          "scala",
+	modeJSON:           "json",
+}
+
+// Name returns a short name for the mode, like "go" or "cpp"
+func (mode Mode) Name() string {
+	if name, ok := modeNames[mode]; ok {
+		return name
+	}
+	return "blank"
+}
+
 // detectFileMode looks at the filename and tries to guess what could be an appropriate editor mode.
 // This mainly affects syntax highlighting (which can be toggled with ctrl-t) and indentation.
 func detectEditorMode(filename string) (Mode, bool) {
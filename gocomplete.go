@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goSymbol is a field, method or package-level identifier that can be completed to
+type goSymbol struct {
+	name      string
+	kind      string // "field", "method", "func", "type", "var" or "const"
+	signature string // for functions and methods, and the type of fields
+}
+
+// goPackage is a parsed Go package, used for completion
+type goPackage struct {
+	fset  *token.FileSet
+	files []*ast.File
+}
+
+// parseGoPackage parses the Go files in the given directory. If a filename and source is given,
+// that source is used instead of the contents of that file, since it may have unsaved changes.
+// Files with syntax errors are still used, as far as they could be parsed.
+func parseGoPackage(dir, currentFilename string, currentSource []byte, withTests bool) *goPackage {
+	pkg := &goPackage{fset: token.NewFileSet()}
+	filenames, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, filename := range filenames {
+		if !withTests && strings.HasSuffix(filename, "_test.go") && filename != currentFilename {
+			continue
+		}
+		var src interface{}
+		if filename == currentFilename {
+			src = currentSource
+		}
+		if f, _ := parser.ParseFile(pkg.fset, filename, src, 0); f != nil {
+			pkg.files = append(pkg.files, f)
+		}
+	}
+	return pkg
+}
+
+// file returns the parsed file with the given filename, or nil
+func (pkg *goPackage) file(filename string) *ast.File {
+	for _, f := range pkg.files {
+		if pkg.fset.Position(f.Package).Filename == filename {
+			return f
+		}
+	}
+	return nil
+}
+
+// nodeString returns the Go source code for the given node
+func (pkg *goPackage) nodeString(node interface{}) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, pkg.fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// funcSignature returns a function declaration as a one-line signature, without the body
+func (pkg *goPackage) funcSignature(fd *ast.FuncDecl) string {
+	s := "func "
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		s += "(" + pkg.nodeString(fd.Recv.List[0].Type) + ") "
+	}
+	return s + fd.Name.Name + strings.TrimPrefix(pkg.nodeString(fd.Type), "func")
+}
+
+// baseTypeName returns the name of a local type, like "Editor" for "*Editor", or "" if it is not a local type
+func baseTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return baseTypeName(t.X)
+	case *ast.ParenExpr:
+		return baseTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// findFunc returns the function declaration, without a receiver, with the given name
+func (pkg *goPackage) findFunc(name string) *ast.FuncDecl {
+	for _, f := range pkg.files {
+		for _, decl := range f.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == name {
+				return fd
+			}
+		}
+	}
+	return nil
+}
+
+// typeOfExpr tries to find the name of the local type of an expression, like T{}, &T{}, new(T) or NewT()
+func (pkg *goPackage) typeOfExpr(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.CompositeLit:
+		return baseTypeName(x.Type)
+	case *ast.UnaryExpr:
+		if x.Op == token.AND {
+			return pkg.typeOfExpr(x.X)
+		}
+	case *ast.ParenExpr:
+		return pkg.typeOfExpr(x.X)
+	case *ast.CallExpr:
+		if ident, ok := x.Fun.(*ast.Ident); ok {
+			if ident.Name == "new" && len(x.Args) == 1 {
+				return baseTypeName(x.Args[0])
+			}
+			return pkg.resultType(ident.Name, 0)
+		}
+	}
+	return ""
+}
+
+// resultType returns the name of the local type of the given result of the local function with the given name
+func (pkg *goPackage) resultType(funcName string, index int) string {
+	fd := pkg.findFunc(funcName)
+	if fd == nil || fd.Type.Results == nil {
+		return ""
+	}
+	i := 0
+	for _, field := range fd.Type.Results.List {
+		// Results like (a, b int) are a single field with several names
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		if index < i+n {
+			return baseTypeName(field.Type)
+		}
+		i += n
+	}
+	return ""
+}
+
+// typeOfValueSpec returns the local type of the variable with the given name in a var declaration
+func (pkg *goPackage) typeOfValueSpec(vs *ast.ValueSpec, name string) string {
+	for i, ident := range vs.Names {
+		if ident.Name != name {
+			continue
+		}
+		if vs.Type != nil {
+			return baseTypeName(vs.Type)
+		}
+		if i < len(vs.Values) {
+			return pkg.typeOfExpr(vs.Values[i])
+		}
+	}
+	return ""
+}
+
+// typeOfIdent tries to find the local type of the variable with the given name, as seen
+// from the given position in the given file. Receivers, parameters, variables declared before
+// the position in the same function, and package-level variables are considered.
+func (pkg *goPackage) typeOfIdent(f *ast.File, pos token.Pos, name string) string {
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Body == nil || pos < fd.Pos() || pos > fd.End() {
+			continue
+		}
+		typeName := ""
+		// Receivers and parameters
+		for _, fl := range []*ast.FieldList{fd.Recv, fd.Type.Params} {
+			if fl == nil {
+				continue
+			}
+			for _, field := range fl.List {
+				for _, ident := range field.Names {
+					if ident.Name == name {
+						typeName = baseTypeName(field.Type)
+					}
+				}
+			}
+		}
+		// Variables declared before the position. The last declaration wins.
+		ast.Inspect(fd.Body, func(node ast.Node) bool {
+			if node == nil || node.Pos() > pos {
+				return false
+			}
+			switch n := node.(type) {
+			case *ast.AssignStmt:
+				if n.Tok != token.DEFINE {
+					break
+				}
+				for i, lhs := range n.Lhs {
+					ident, ok := lhs.(*ast.Ident)
+					if !ok || ident.Name != name {
+						continue
+					}
+					t := ""
+					if len(n.Lhs) == len(n.Rhs) {
+						t = pkg.typeOfExpr(n.Rhs[i])
+					} else if call, ok := n.Rhs[0].(*ast.CallExpr); ok && len(n.Rhs) == 1 {
+						// Like a, b := f()
+						if fun, ok := call.Fun.(*ast.Ident); ok {
+							t = pkg.resultType(fun.Name, i)
+						}
+					}
+					if t != "" {
+						typeName = t
+					}
+				}
+			case *ast.ValueSpec:
+				if t := pkg.typeOfValueSpec(n, name); t != "" {
+					typeName = t
+				}
+			}
+			return true
+		})
+		if typeName != "" {
+			return typeName
+		}
+	}
+	// Package-level variables
+	for _, f := range pkg.files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				if t := pkg.typeOfValueSpec(spec.(*ast.ValueSpec), name); t != "" {
+					return t
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// members returns the fields and methods of the local type with the given name,
+// including the fields and methods of embedded local types
+func (pkg *goPackage) members(typeName string, visited map[string]bool) []goSymbol {
+	if visited[typeName] {
+		return nil
+	}
+	visited[typeName] = true
+	var symbols []goSymbol
+	for _, f := range pkg.files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv != nil && len(d.Recv.List) > 0 && baseTypeName(d.Recv.List[0].Type) == typeName {
+					symbols = append(symbols, goSymbol{d.Name.Name, "method", pkg.funcSignature(d)})
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || ts.Name.Name != typeName {
+						continue
+					}
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, field := range st.Fields.List {
+						if len(field.Names) == 0 {
+							// An embedded type
+							if embedded := baseTypeName(field.Type); embedded != "" {
+								symbols = append(symbols, goSymbol{embedded, "field", pkg.nodeString(field.Type)})
+								symbols = append(symbols, pkg.members(embedded, visited)...)
+							}
+							continue
+						}
+						for _, ident := range field.Names {
+							symbols = append(symbols, goSymbol{ident.Name, "field", ident.Name + " " + pkg.nodeString(field.Type)})
+						}
+					}
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+// exported returns the exported package-level identifiers in the package
+func (pkg *goPackage) exported() []goSymbol {
+	var symbols []goSymbol
+	for _, f := range pkg.files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.IsExported() {
+					symbols = append(symbols, goSymbol{d.Name.Name, "func", pkg.funcSignature(d)})
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if s.Name.IsExported() {
+							symbols = append(symbols, goSymbol{s.Name.Name, "type", ""})
+						}
+					case *ast.ValueSpec:
+						for _, ident := range s.Names {
+							if ident.IsExported() {
+								symbols = append(symbols, goSymbol{ident.Name, d.Tok.String(), ""})
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+// goModule finds the go.mod file in the given directory or above, and returns
+// the directory of go.mod and the module path
+func goModule(dir string) (string, string) {
+	for {
+		if f, err := os.Open(filepath.Join(dir, "go.mod")); err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "module" {
+					return dir, strings.Trim(fields[1], "\"")
+				}
+			}
+			return dir, ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// localImportDir returns the directory of the package that is imported as the given name
+// in the given file, if it is in the same module. Returns "" if not.
+func localImportDir(f *ast.File, dir, name string) string {
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		importName := filepath.Base(importPath)
+		if spec.Name != nil {
+			importName = spec.Name.Name
+		}
+		if importName != name {
+			continue
+		}
+		moduleDir, modulePath := goModule(dir)
+		if modulePath == "" || !strings.HasPrefix(importPath+"/", modulePath+"/") {
+			return ""
+		}
+		return filepath.Join(moduleDir, strings.TrimPrefix(importPath, modulePath))
+	}
+	return ""
+}
+
+// GoCompletions returns the fields and methods of the variable before the "." before the cursor,
+// if its type can be found in the current package, or the exported identifiers of a local package
+// if the word before the "." is the name of an imported package in the same module.
+// Returns nil if nothing was found.
+func (e *Editor) GoCompletions(before string) []Completion {
+	absFilename, err := e.AbsFilename()
+	if err != nil || before == "" {
+		return nil
+	}
+	dir := filepath.Dir(absFilename)
+	pkg := parseGoPackage(dir, absFilename, []byte(e.String()), strings.HasSuffix(absFilename, "_test.go"))
+	f := pkg.file(absFilename)
+	if f == nil {
+		return nil
+	}
+	var symbols []goSymbol
+	if importDir := localImportDir(f, dir, before); importDir != "" {
+		symbols = parseGoPackage(importDir, "", nil, false).exported()
+	} else {
+		tf := pkg.fset.File(f.Pos())
+		y := int(e.DataY()) + 1
+		if tf == nil || y > tf.LineCount() {
+			return nil
+		}
+		typeName := pkg.typeOfIdent(f, tf.LineStart(y), before)
+		if typeName == "" {
+			return nil
+		}
+		symbols = pkg.members(typeName, make(map[string]bool))
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbols[i].name < symbols[j].name
+	})
+	var completions []Completion
+	for _, symbol := range symbols {
+		completions = addCompletion(completions, symbol.name, symbol.kind, symbol.signature)
+	}
+	return completions
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const goCompleteSource = `package main
+
+import (
+	"fmt"
+
+	"example.com/m/shapes"
+	geo "example.com/m/geometry"
+)
+
+type Base struct {
+	ID int
+}
+
+func (b *Base) Describe() string { return fmt.Sprint(b.ID) }
+
+type Point struct {
+	Base
+	X, Y int
+}
+
+func (p Point) Len() int { return p.X + p.Y }
+
+func NewPoint() *Point { return &Point{} }
+
+func twoPoints() (*Point, error) { return nil, nil }
+
+var global = Point{}
+
+func main() {
+	p1 := NewPoint()
+	var my_point Point
+	p2, err := twoPoints()
+	q := new(Base)
+	r := &Point{}
+	// here
+	fmt.Println(p1, my_point, p2, err, q, r, shapes.Square, geo.Pi)
+}
+`
+
+// writeGoCompletePackage writes a module with a main package and two local packages to a temporary directory
+func writeGoCompletePackage(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "o_gocomplete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":               "module example.com/m\n",
+		"main.go":              goCompleteSource,
+		"shapes/shapes.go":     "package shapes\n\nconst Square = 4\n\nfunc Area() int { return 0 }\n\nfunc hidden() {}\n",
+		"geometry/geometry.go": "package geometry\n\nvar Pi = 3.14\n\ntype Circle struct{}\n",
+	}
+	for name, contents := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTypeOfIdent(t *testing.T) {
+	dir := writeGoCompletePackage(t)
+	defer os.RemoveAll(dir)
+
+	pkg := parseGoPackage(dir, "", nil, false)
+	f := pkg.file(filepath.Join(dir, "main.go"))
+	if f == nil {
+		t.Fatal("could not parse main.go")
+	}
+	pos := f.Pos() + token.Pos(strings.Index(goCompleteSource, "// here"))
+	idents := []struct {
+		name     string
+		expected string
+	}{
+		{"p1", "Point"},       // from the result of a local function
+		{"my_point", "Point"}, // from a var declaration
+		{"p2", "Point"},       // from the first of several results
+		{"err", "error"},      // not a local type, but the name is still found
+		{"q", "Base"},         // from new
+		{"r", "Point"},        // from a composite literal
+		{"global", "Point"},   // from a package-level variable
+		{"missing", ""},
+	}
+	for _, ident := range idents {
+		if got := pkg.typeOfIdent(f, pos, ident.name); got != ident.expected {
+			t.Errorf("typeOfIdent(%q): expected %q, got %q", ident.name, ident.expected, got)
+		}
+	}
+
+	// Variables declared after the position are not known yet
+	if got := pkg.typeOfIdent(f, f.Pos()+token.Pos(strings.Index(goCompleteSource, "p1 :=")), "r"); got != "" {
+		t.Errorf("expected r to be unknown before it is declared, got %q", got)
+	}
+}
+
+func TestGoMembers(t *testing.T) {
+	dir := writeGoCompletePackage(t)
+	defer os.RemoveAll(dir)
+
+	pkg := parseGoPackage(dir, "", nil, false)
+	var names []string
+	for _, symbol := range pkg.members("Point", make(map[string]bool)) {
+		names = append(names, symbol.kind+" "+symbol.name)
+	}
+	got := strings.Join(names, ", ")
+	for _, expected := range []string{"method Len", "field Base", "field ID", "method Describe", "field X", "field Y"} {
+		if !strings.Contains(", "+got+", ", ", "+expected+", ") {
+			t.Errorf("expected the members of Point to include %q, got %s", expected, got)
+		}
+	}
+	if members := pkg.members("Missing", make(map[string]bool)); len(members) != 0 {
+		t.Errorf("expected no members for an unknown type, got %v", members)
+	}
+}
+
+func TestLocalImportDir(t *testing.T) {
+	dir := writeGoCompletePackage(t)
+	defer os.RemoveAll(dir)
+
+	pkg := parseGoPackage(dir, "", nil, false)
+	f := pkg.file(filepath.Join(dir, "main.go"))
+	if f == nil {
+		t.Fatal("could not parse main.go")
+	}
+	imports := []struct {
+		name     string
+		expected string
+	}{
+		{"shapes", filepath.Join(dir, "shapes")},
+		{"geo", filepath.Join(dir, "geometry")},
+		{"geometry", ""}, // imported with another name
+		{"fmt", ""},      // not in the same module
+		{"os", ""},       // not imported
+	}
+	for _, i := range imports {
+		if got := localImportDir(f, dir, i.name); got != i.expected {
+			t.Errorf("localImportDir(%q): expected %q, got %q", i.name, i.expected, got)
+		}
+	}
+}
+
+func TestGoCompletions(t *testing.T) {
+	dir := writeGoCompletePackage(t)
+	defer os.RemoveAll(dir)
+
+	e := NewSimpleEditor(80)
+	e.mode = modeGo
+	e.filename = filepath.Join(dir, "main.go")
+	e.InsertStringAndMove(nil, strings.Replace(goCompleteSource, "// here", "my_point.", 1))
+	y := LineIndex(strings.Count(goCompleteSource[:strings.Index(goCompleteSource, "// here")], "\n"))
+	x := len([]rune(e.Line(y)))
+
+	// The receiver name contains an underscore, and must not be cut short
+	before := e.IdentifierBefore(y, x-1)
+	if before != "my_point" {
+		t.Fatalf("expected the identifier before the dot to be my_point, got %q", before)
+	}
+	e.GoTo(y, nil, nil)
+	var names []string
+	for _, completion := range e.GoCompletions(before) {
+		names = append(names, completion.text)
+	}
+	if got := strings.Join(names, " "); got != "Base Describe ID Len X Y" {
+		t.Errorf("unexpected completions for my_point: %s", got)
+	}
+
+	var exported []string
+	for _, completion := range e.GoCompletions("shapes") {
+		exported = append(exported, completion.text)
+	}
+	if got := strings.Join(exported, " "); got != "Area Square" {
+		t.Errorf("unexpected completions for shapes: %s", got)
+	}
+}
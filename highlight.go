@@ -39,6 +39,12 @@ func (e *Editor) WriteLines(c *vt100.Canvas, fromline, toline LineIndex, cx, cy
 
 	expandedRunes := false // used for detecting wide unicode symbols
 
+	// Errors and warnings from the language server, if one is running
+	diagnostics := e.LSPDiagnostics()
+
+	// Covered and uncovered statements from the last test run with coverage, if they are shown
+	lineCoverage := e.CoverageForLines(fromline, toline)
+
 	//logf("numlines: %d offsetY %d\n", numlines, offsetY)
 
 	// If in Markdown mode, figure out the current state of block quotes
@@ -345,6 +351,7 @@ func (e *Editor) WriteLines(c *vt100.Canvas, fromline, toline LineIndex, cx, cy
 
 				// Output a line with the chars (Rune + AttributeColor)
 				skipX := e.pos.offsetX
+				runeCoverage := lineCoverage[y+offsetY]
 				for runeIndex, ra := range runesAndAttributes {
 					if skipX > 0 {
 						skipX--
@@ -354,6 +361,10 @@ func (e *Editor) WriteLines(c *vt100.Canvas, fromline, toline LineIndex, cx, cy
 					fg := ra.A
 					if letter == ' ' {
 						fg = e.fg
+					} else if runeIndex < len(runeCoverage) && runeCoverage[runeIndex] > 0 {
+						fg = coveredColor
+					} else if runeIndex < len(runeCoverage) && runeCoverage[runeIndex] < 0 {
+						fg = uncoveredColor
 					}
 					if matchForAnotherN > 0 {
 						// Coloring an already found match
@@ -429,6 +440,40 @@ func (e *Editor) WriteLines(c *vt100.Canvas, fromline, toline LineIndex, cx, cy
 			//r = []rune(lineNumber)[len([]rune(lineNumber))-1]
 			c.WriteRuneB(xp, yp, e.fg, bg, r)
 		}
+
+		// Show the error or warning from the language server after the line, if there is room
+		if d, found := diagnostics[y+offsetY]; found && lineRuneCount+4 < w {
+			color := lspWarningColor
+			if d.Severity == 1 {
+				color = lspErrorColor
+			}
+			msg := []rune(strings.TrimSpace(strings.SplitN(d.Message, "\n", 2)[0]))
+			xp = uint(cx) + lineRuneCount + 2
+			for _, r := range msg {
+				if xp >= uint(cx)+w-1 {
+					break
+				}
+				c.WriteRuneB(xp, yp, color, bg, r)
+				xp++
+			}
+		}
+
+		// Mark lines with covered or uncovered statements in the rightmost column
+		if runeCoverage, found := lineCoverage[y+offsetY]; found && lineRuneCount < w {
+			color := coveredColor
+			for _, state := range runeCoverage {
+				if state < 0 {
+					color = uncoveredColor
+					break
+				}
+			}
+			c.WriteRuneB(uint(cx)+w-1, yp, color, bg, coverageMarker)
+		}
+
+		// Mark bookmarked lines in the rightmost column
+		if name, found := e.bookmarks.At(y + offsetY); found && lineRuneCount < w {
+			c.WriteRuneB(uint(cx)+w-1, yp, bookmarkMarkerColor, bg, []rune(name)[0])
+		}
 		//c.WriteRuneB(xp, yp, e.fg, e.bg, '\n')
 	}
 
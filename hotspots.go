@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xyproto/vt100"
+)
+
+const (
+	hotSpotsFilename = "hotspots.txt" // in the cache directory
+
+	// After this long, the time spent at a line only counts half
+	hotSpotHalfLife = 10 * time.Minute
+
+	// Time spent at a line between two keypresses is counted up to this duration,
+	// so that leaving the editor alone for a while does not make a line hot
+	maxHotSpotDwell = time.Minute
+
+	// Hot spots closer than this to the current line, or to a hotter spot, are not offered
+	hotSpotMinDistance = 10
+
+	// Hot spots that have cooled down below this many seconds are forgotten
+	minHotSpotScore = 0.5
+
+	// The maximum number of hot spots to keep per file
+	maxHotSpotsPerFile = 100
+)
+
+// HotSpot is a line where the cursor has been for a while
+type HotSpot struct {
+	score   float64 // the number of seconds spent at this line, decayed over time
+	updated time.Time
+}
+
+// Score returns the score of this hot spot, decayed until the given time
+func (h HotSpot) Score(now time.Time) float64 {
+	return h.score * math.Exp2(-now.Sub(h.updated).Seconds()/hotSpotHalfLife.Seconds())
+}
+
+// HotSpots keeps track of where the cursor dwells in a file.
+// The map is shared between undo snapshots, which is fine, since visiting a line can not be undone.
+type HotSpots map[LineIndex]HotSpot
+
+// Visit records that the cursor has been at the given line for the given duration
+func (hs HotSpots) Visit(index LineIndex, dwell time.Duration, now time.Time) {
+	if dwell <= 0 {
+		return
+	}
+	if dwell > maxHotSpotDwell {
+		dwell = maxHotSpotDwell
+	}
+	h := hs[index]
+	hs[index] = HotSpot{h.Score(now) + dwell.Seconds(), now}
+}
+
+// Top returns up to n of the hottest lines, hottest first. Lines that are close to
+// the given current line, or close to a hotter line, are skipped.
+func (hs HotSpots) Top(n int, current LineIndex, now time.Time) []LineIndex {
+	indices := make([]LineIndex, 0, len(hs))
+	for index, h := range hs {
+		if h.Score(now) >= minHotSpotScore {
+			indices = append(indices, index)
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return hs[indices[i]].Score(now) > hs[indices[j]].Score(now)
+	})
+	near := func(a, b LineIndex) bool {
+		return a-b < hotSpotMinDistance && b-a < hotSpotMinDistance
+	}
+	top := make([]LineIndex, 0, n)
+OUT:
+	for _, index := range indices {
+		if len(top) >= n {
+			break
+		}
+		if near(index, current) {
+			continue
+		}
+		for _, chosen := range top {
+			if near(index, chosen) {
+				continue OUT
+			}
+		}
+		top = append(top, index)
+	}
+	return top
+}
+
+// String returns the hot spots that have not cooled down, as a space separated list
+// of line number=score@unix time, with the hottest first
+func (hs HotSpots) String() string {
+	now := time.Now()
+	indices := make([]LineIndex, 0, len(hs))
+	for index, h := range hs {
+		if h.Score(now) >= minHotSpotScore {
+			indices = append(indices, index)
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return hs[indices[i]].score > hs[indices[j]].score
+	})
+	if len(indices) > maxHotSpotsPerFile {
+		indices = indices[:maxHotSpotsPerFile]
+	}
+	fields := make([]string, len(indices))
+	for i, index := range indices {
+		h := hs[index]
+		fields[i] = fmt.Sprintf("%s=%.1f@%d", index.LineNumber(), h.score, h.updated.Unix())
+	}
+	return strings.Join(fields, " ")
+}
+
+// parseHotSpots parses a space separated list of line number=score@unix time
+func parseHotSpots(s string) HotSpots {
+	hs := make(HotSpots)
+	for _, field := range strings.Fields(s) {
+		eq := strings.Index(field, "=")
+		at := strings.LastIndex(field, "@")
+		if eq < 1 || at < eq {
+			continue
+		}
+		lineNumber, err := strconv.Atoi(field[:eq])
+		if err != nil || lineNumber < 1 {
+			continue
+		}
+		score, err := strconv.ParseFloat(field[eq+1:at], 64)
+		if err != nil {
+			continue
+		}
+		unixTime, err := strconv.ParseInt(field[at+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		hs[LineNumber(lineNumber).LineIndex()] = HotSpot{score, time.Unix(unixTime, 0)}
+	}
+	return hs
+}
+
+// LoadAllHotSpots loads the hot spots for all files from the given file.
+// The format of the file is, per line: "filename": line=score@time line=score@time
+func LoadAllHotSpots(hotSpotsFile string) (map[string]string, error) {
+	allHotSpots := make(map[string]string)
+	data, err := ioutil.ReadFile(hotSpotsFile)
+	if err != nil {
+		return allHotSpots, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		pos := strings.LastIndex(line, "\":")
+		if !strings.HasPrefix(line, "\"") || pos < 1 {
+			continue
+		}
+		allHotSpots[line[1:pos]] = strings.TrimSpace(line[pos+2:])
+	}
+	return allHotSpots, nil
+}
+
+// LoadHotSpots returns the saved hot spots for the given absolute filename
+func LoadHotSpots(absFilename string) HotSpots {
+	allHotSpots, _ := LoadAllHotSpots(cachePath(hotSpotsFilename))
+	return parseHotSpots(allHotSpots[absFilename])
+}
+
+// SaveHotSpots saves the hot spots for the given absolute filename,
+// while keeping the hot spots for other files
+func SaveHotSpots(absFilename string, hs HotSpots) error {
+	hotSpotsFile := cachePath(hotSpotsFilename)
+	allHotSpots, _ := LoadAllHotSpots(hotSpotsFile)
+	if s := hs.String(); s != "" {
+		allHotSpots[absFilename] = s
+	} else if _, found := allHotSpots[absFilename]; found {
+		delete(allHotSpots, absFilename)
+	} else {
+		// Nothing to save or remove
+		return nil
+	}
+	if len(allHotSpots) > maxLocationHistoryEntries {
+		// Cull the hot spots for the other files
+		allHotSpots = map[string]string{absFilename: allHotSpots[absFilename]}
+	}
+
+	// First create the folder, if needed, in a best effort attempt
+	os.MkdirAll(filepath.Dir(hotSpotsFile), os.ModePerm)
+
+	var sb strings.Builder
+	for k, v := range allHotSpots {
+		sb.WriteString(fmt.Sprintf("\"%s\": %s\n", k, v))
+	}
+	return ioutil.WriteFile(hotSpotsFile, []byte(sb.String()), 0600)
+}
+
+// GoToHottestSpot jumps to the line that the cursor has spent the most time at lately,
+// that is not close to the current line. Returns false if there is no such line.
+func (e *Editor) GoToHottestSpot(c *vt100.Canvas, status *StatusBar) bool {
+	top := e.hotSpots.Top(1, e.DataY(), time.Now())
+	if len(top) == 0 {
+		return false
+	}
+	e.RecordJump(e.DataY(), top[0])
+	e.redraw = e.GoToLineNumber(top[0].LineNumber(), c, status, true)
+	e.redrawCursor = true
+	return true
+}
+
+// HotSpotsMenu shows a menu with the lines in this file where the cursor has spent
+// the most time lately, and jumps to the selected line. Returns a status message.
+func (e *Editor) HotSpotsMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY) string {
+	top := e.hotSpots.Top(10, e.DataY(), time.Now())
+	if len(top) == 0 {
+		return "No frequently visited locations yet"
+	}
+	choices := make([]string, len(top))
+	for i, index := range top {
+		preview := strings.TrimSpace(e.Line(index))
+		if len([]rune(preview)) > 50 {
+			preview = string([]rune(preview)[:50]) + "..."
+		}
+		choices[i] = fmt.Sprintf("line %s: %s", index.LineNumber(), preview)
+	}
+	selected := e.Menu(status, tty, "Frequently visited locations", choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+	e.redraw = true
+	e.redrawCursor = true
+	if selected < 0 {
+		return ""
+	}
+	e.RecordJump(e.DataY(), top[selected])
+	e.GoToLineNumber(top[selected].LineNumber(), c, status, true)
+	return "Jumped to line " + top[selected].LineNumber().String()
+}
@@ -110,6 +110,11 @@ func NewEditor(tty *vt100.TTY, c *vt100.Canvas, filename string, lineNumber Line
 			return nil, "", err
 		}
 
+		// Remember what the file looked like on disk, to be able to detect changes made by other programs
+		if absFilename, err := e.AbsFilename(); err == nil { // no error
+			RecordDiskStamp(absFilename, nil)
+		}
+
 		if !e.Empty() {
 			e.checkContents()
 		}
@@ -194,15 +199,22 @@ func NewEditor(tty *vt100.TTY, c *vt100.Canvas, filename string, lineNumber Line
 	}
 
 	// Load the location history. This will be saved again later. Errors are ignored.
-	e.locationHistory, err = LoadLocationHistory(expandUser(locationHistoryFilename))
+	e.locationHistory, err = LoadLocationHistory(cachePath(locationHistoryFilename))
 	if err == nil { // no error
 		recordedLineNumber, found = e.locationHistory[absFilename]
 	}
 
+	// Load the bookmarks for this file
+	e.bookmarks = LoadBookmarks(absFilename)
+
+	// Load the frequently visited lines for this file
+	e.hotSpots = LoadHotSpots(absFilename)
+
 	// Load the search history. This will be saved again later. Errors are ignored.
-	searchHistory, _ = LoadSearchHistory(expandUser(searchHistoryFilename))
+	searchHistory, _ = LoadSearchHistory(cachePath(searchHistoryFilename))
 
-	// Jump to the correct line number
+	// Jump to the correct line number, without recording it in the jump list
+	jumpList.Pause()
 	switch {
 	case lineNumber > 0:
 		if colNumber > 0 {
@@ -238,6 +250,7 @@ func NewEditor(tty *vt100.TTY, c *vt100.Canvas, filename string, lineNumber Line
 		e.DrawLines(c, false, false)
 		e.redraw = false
 	}
+	jumpList.Resume()
 
 	// Make sure the location history isn't empty
 	if e.locationHistory == nil {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/xyproto/vt100"
+)
+
+// How many jumps to remember
+const maxJumps = 100
+
+// Jump is a location that the cursor jumped from or to
+type Jump struct {
+	absFilename string
+	index       LineIndex
+}
+
+// JumpList is a list of locations the user has jumped from, for going back and forth,
+// like in a web browser. It is shared between all buffers, so that it still works after switching files.
+type JumpList struct {
+	jumps  []Jump
+	pos    int  // the position in jumps we are at, or len(jumps) if we are not walking the list
+	paused bool // don't record jumps while walking the list or while initializing an editor
+	mut    *sync.RWMutex
+}
+
+// The jump list. Jumps are recorded by searches, go to line, bookmarks, go to definition,
+// the quickfix list and bracket matching, and walked with the ctrl-l arrow keys.
+var jumpList = NewJumpList()
+
+// NewJumpList creates a new and empty jump list
+func NewJumpList() *JumpList {
+	return &JumpList{make([]Jump, 0, maxJumps), 0, false, &sync.RWMutex{}}
+}
+
+// Len returns the number of recorded jumps
+func (jl *JumpList) Len() int {
+	jl.mut.RLock()
+	defer jl.mut.RUnlock()
+	return len(jl.jumps)
+}
+
+// Pause stops jumps from being recorded, until Resume is called
+func (jl *JumpList) Pause() {
+	jl.mut.Lock()
+	jl.paused = true
+	jl.mut.Unlock()
+}
+
+// Resume starts recording jumps again
+func (jl *JumpList) Resume() {
+	jl.mut.Lock()
+	jl.paused = false
+	jl.mut.Unlock()
+}
+
+// Record adds the location that is jumped from to the list.
+// Any locations that can be reached by going forward are forgotten.
+func (jl *JumpList) Record(j Jump) {
+	jl.mut.Lock()
+	defer jl.mut.Unlock()
+	if jl.paused {
+		return
+	}
+	jl.jumps = jl.jumps[:jl.pos]
+	if l := len(jl.jumps); l == 0 || jl.jumps[l-1] != j {
+		jl.jumps = append(jl.jumps, j)
+	}
+	if len(jl.jumps) > maxJumps {
+		jl.jumps = jl.jumps[len(jl.jumps)-maxJumps:]
+	}
+	jl.pos = len(jl.jumps)
+}
+
+// Back returns the previous location in the list. The current location is also recorded,
+// if we are not already walking the list, so that it is possible to go forward to it again.
+func (jl *JumpList) Back(current Jump) (Jump, bool) {
+	jl.mut.Lock()
+	defer jl.mut.Unlock()
+	if len(jl.jumps) == 0 {
+		return Jump{}, false
+	}
+	if jl.pos == len(jl.jumps) {
+		if jl.jumps[len(jl.jumps)-1] != current {
+			jl.jumps = append(jl.jumps, current)
+		}
+		jl.pos = len(jl.jumps) - 1
+	}
+	if jl.pos <= 0 {
+		return Jump{}, false
+	}
+	jl.pos--
+	return jl.jumps[jl.pos], true
+}
+
+// Forward returns the next location in the list, after having gone back
+func (jl *JumpList) Forward() (Jump, bool) {
+	jl.mut.Lock()
+	defer jl.mut.Unlock()
+	if jl.pos+1 >= len(jl.jumps) {
+		return Jump{}, false
+	}
+	jl.pos++
+	return jl.jumps[jl.pos], true
+}
+
+// jumpAt returns a Jump for the given line index in the current file
+func (e *Editor) jumpAt(index LineIndex) Jump {
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		absFilename = e.filename
+	}
+	return Jump{absFilename, index}
+}
+
+// RecordJump records a jump from one line to another in the jump list,
+// if the jump is longer than a single line. Should only be called for explicit jumps,
+// not for every cursor movement.
+func (e *Editor) RecordJump(from, to LineIndex) {
+	if to-from > 1 || from-to > 1 {
+		jumpList.Record(e.jumpAt(from))
+	}
+}
+
+// jumpTo goes to the given location, and switches to the file first if needed
+func (e *Editor) jumpTo(j Jump, tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) error {
+	jumpList.Pause()
+	defer jumpList.Resume()
+	if absFilename, err := e.AbsFilename(); err != nil || absFilename != j.absFilename {
+		if err := e.Switch(tty, c, status, lk, j.absFilename, false); err != nil {
+			return err
+		}
+	}
+	e.redraw = e.GoToLineNumber(j.index.LineNumber(), c, status, true)
+	e.redrawCursor = true
+	return nil
+}
+
+// JumpBack goes back to the location before the last jump. Returns a status message.
+func (e *Editor) JumpBack(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) string {
+	j, ok := jumpList.Back(e.jumpAt(e.DataY()))
+	if !ok {
+		return "No earlier jumps"
+	}
+	if err := e.jumpTo(j, tty, c, status, lk); err != nil {
+		return err.Error()
+	}
+	return "Jumped back to " + filepath.Base(j.absFilename) + ":" + j.index.LineNumber().String()
+}
+
+// JumpForward goes forward again, after having jumped back. Returns a status message.
+func (e *Editor) JumpForward(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) string {
+	j, ok := jumpList.Forward()
+	if !ok {
+		return "No later jumps"
+	}
+	if err := e.jumpTo(j, tty, c, status, lk); err != nil {
+		return err.Error()
+	}
+	return "Jumped forward to " + filepath.Base(j.absFilename) + ":" + j.index.LineNumber().String()
+}
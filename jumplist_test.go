@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJumpList(t *testing.T) {
+	defer func(jl *JumpList) { jumpList = jl }(jumpList)
+	jumpList = NewJumpList()
+
+	e := NewSimpleEditor(80)
+	e.filename = "jumps.txt"
+	e.InsertStringAndMove(nil, strings.Repeat("hay\n", 40)+"needle\n"+strings.Repeat("hay\n", 9))
+
+	// Moving the cursor around is not a jump
+	e.GoTo(20, nil, nil)
+	e.GoTo(5, nil, nil)
+	if n := jumpList.Len(); n != 0 {
+		t.Errorf("expected GoTo to not record any jumps, got %d", n)
+	}
+
+	// Going to a search match is
+	e.searchTerm = "needle"
+	if err := e.GoToNextMatch(nil, nil, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if e.DataY() != 40 {
+		t.Fatalf("expected the cursor to be at the match on line index 40, got %d", e.DataY())
+	}
+	if n := jumpList.Len(); n != 1 {
+		t.Errorf("expected the search to record one jump, got %d", n)
+	}
+	if j, ok := jumpList.Back(e.jumpAt(e.DataY())); !ok || j.index != 5 {
+		t.Errorf("expected to jump back to line index 5, got %v", j)
+	}
+	if j, ok := jumpList.Forward(); !ok || j.index != 40 {
+		t.Errorf("expected to jump forward to line index 40, got %v", j)
+	}
+}
@@ -12,8 +12,6 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/atotto/clipboard"
-	"github.com/xyproto/syntax"
 	"github.com/xyproto/vt100"
 )
 
@@ -43,10 +41,9 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 	var (
 		statusDuration = 2700 * time.Millisecond
 
-		copyLines         []string  // for the cut/copy/paste functionality
-		previousCopyLines []string  // for checking if a paste is the same as last time
-		bookmark          *Position // for the bookmark/jump functionality
-		statusMode        bool      // if information should be shown at the bottom
+		copyLines         []string // for the cut/copy/paste functionality
+		previousCopyLines []string // for checking if a paste is the same as last time
+		statusMode        bool     // if information should be shown at the bottom
 
 		firstPasteAction = true
 		firstCopyAction  = true
@@ -55,6 +52,9 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 		lastPasteY LineIndex = -1 // used for keeping track if ctrl-v is pressed twice on the same line
 		lastCutY   LineIndex = -1 // used for keeping track if ctrl-x is pressed twice on the same line
 
+		killRingIndex = -1    // the clipboard history entry that was pasted last, for cycling with ctrl-_
+		cyclePaste    = false // can the text that was just pasted be cycled with ctrl-_?
+
 		previousKey string // keep track of the previous key press
 
 		lastCommandMenuIndex int // for the command menu
@@ -64,6 +64,8 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 		jsonFormatToggle bool // for toggling indentation or not when pressing ctrl-w for JSON
 
 		markdownSkipExport = true // for skipping the first ctrl-space keypress
+
+		builtSuccessfully bool // for running the program when ctrl-space is pressed again after a successful build
 	)
 
 	// New editor struct. Scroll 10 lines at a time, no word wrap.
@@ -111,54 +113,59 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 	// ctrl-c handler
 	e.SetUpTerminateHandler(c, status, tty)
 
-	tty.SetTimeout(2 * time.Millisecond)
+	tty.SetTimeout(keyTimeout)
+
+	// Select a clipboard backend, based on O_CLIPBOARD or the environment
+	clip := NewClipboard(tty)
+
+	// Remember the last cuts and copies. Load them from the previous session, if they are kept.
+	var killRingFile string
+	if persistKillRing {
+		killRingFile = cachePath(killRingFilename)
+	}
+	killRing := NewKillRing(killRingSize, killRingFile)
+	if persistKillRing {
+		// Ignore errors, there might not be a saved clipboard history yet
+		killRing.Load()
+	}
 
 	previousX := 1
 	previousY := 1
 
-	// Create a LockKeeper for keeping track of which files are being edited
-	lk := NewLockKeeper(expandUser(defaultLockFile))
-
-	var (
-		canUseLocks   = true
-		lockTimestamp time.Time
-	)
+	// Prepare to write swap files for unsaved changes in the background
+	autosave := NewAutosave(e)
 
-	// If the lock keeper does not have an overview already, that's fine. Ignore errors from lk.Load().
-	if err := lk.Load(); err != nil {
-		// Could not load an existing lock overview, this might be the first run? Try saving.
-		if err := lk.Save(); err != nil {
-			// Could not save a lock overview. Can not use locks.
-			canUseLocks = false
-		}
-	}
+	// Create a LockKeeper for keeping track of which files are being edited.
+	// If the lock directory can not be created, locks can not be used.
+	lk, err := NewLockKeeper(cachePath(defaultLockDirectory))
+	canUseLocks := err == nil
 
 	if canUseLocks {
 		// Check if the lock should be forced
 		if forceFlag {
-			// Lock and save, regardless of what the previous status is
-			lk.Lock(absFilename)
-			// TODO: If the file was already marked as locked, this is not strictly needed? The timestamp might be modified, though.
-			lk.Save()
-		} else {
-			// Lock the current file, if it's not already locked
-			if err := lk.Lock(absFilename); err != nil {
-				return fmt.Sprintf("Locked by another (possibly dead) instance of this editor.\nTry: o -f %s", filepath.Base(absFilename)), errors.New(absFilename + " is locked")
-			}
-			// Immediately save the lock file as a signal to other instances of the editor
-			lk.Save()
+			// Lock, regardless of what the previous status is
+			lk.ForceLock(absFilename)
+		} else if err := lk.Lock(absFilename); err != nil {
+			// Locks held by editors that are no longer running have already been removed by lk.Lock
+			if lockedError, ok := err.(*LockedError); ok {
+				return fmt.Sprintf("Locked by %s.\nTry: o -f %s", lockedError.lock, filepath.Base(absFilename)), errors.New(absFilename + " is locked")
+			}
+			// Could not create a lock file. Can not use locks.
+			canUseLocks = false
 		}
-		lockTimestamp = lk.GetTimestamp(absFilename)
+	}
 
+	if canUseLocks {
 		// Set up a catch for panics, so that the current file can be unlocked
 		defer func() {
 			if x := recover(); x != nil {
-				// Unlock and save the lock file
+				// Unlock the file
 				lk.Unlock(absFilename)
-				lk.Save()
+
+				// Write a swap file, so that the editor can discover it and offer to recover it when it starts.
+				autosave.WriteNow()
 
 				// Save the current file. The assumption is that it's better than not saving, if something crashes.
-				// TODO: Save to a crash file, then let the editor discover this when it starts.
 				e.Save(c)
 
 				// Output the error message
@@ -167,6 +174,37 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 		}()
 	}
 
+	// Check if a previous session left unsaved changes behind, then start writing swap files
+	if msg := autosave.Recover(c, status, tty); msg != "" {
+		statusMessage = msg
+	}
+	autosave.Start()
+
+	// Ask before using the commands in a new or changed project configuration file
+	if msg := e.AskToTrustProjectConfig(c, status, tty); msg != "" {
+		statusMessage = msg
+	}
+
+	// Warn if the file is changed on disk by another program
+	WatchDisk(c, e, status, autosave)
+
+	// Start a language server for this file in the background, if one is installed for this mode.
+	// Redraw when new diagnostics arrive, but not while a keypress is being handled.
+	lspQuit := make(chan bool)
+	WatchLSPDiagnostics(c, e, autosave, lspQuit)
+	e.StartLSP()
+
+	// Update the spinner in the status bar while a build is running in the background
+	buildQuit := make(chan bool)
+	builder.Watch(c, e, status, autosave, buildQuit)
+
+	// Send the changes to the language server when no keys have been pressed for a short while
+	lspSyncTimer := time.AfterFunc(lspSyncDelay, func() {
+		autosave.Lock()
+		e.LSPSync()
+		autosave.Unlock()
+	})
+
 	// Do a full reset and redraw, but without the statusbar (set to nil)
 	e.FullResetRedraw(c, nil, false)
 
@@ -188,12 +226,37 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 		e.redrawCursor = false
 	}
 
+	// For measuring how long the cursor stays at each line
+	lastKeyTime := time.Now()
+
 	// This is the main loop for the editor
 	for !e.quit {
 
 		// Read the next key
 		key = tty.String()
 
+		// Record how long the cursor stayed at the current line
+		if key != "" && e.hotSpots != nil {
+			now := time.Now()
+			e.hotSpots.Visit(e.DataY(), now.Sub(lastKeyTime), now)
+			lastKeyTime = now
+		}
+
+		// Don't let the autosave goroutine read the contents while the keypress is being handled
+		autosave.Lock()
+
+		// The output of a program that has just been run is shown until a key is pressed,
+		// and can be scrolled with the arrow keys, ctrl-u and ctrl-d
+		if title := builder.TakeOutput(); title != "" {
+			switch key {
+			case "↑", "↓", "c:21", "c:4":
+				e.ShowBuildOutput(c, tty, status, title, key)
+				key = ""
+			default:
+				e.redraw = true
+			}
+		}
+
 		switch key {
 		case "c:17": // ctrl-q, quit
 			e.quit = true
@@ -311,6 +374,23 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			e.SearchMode(c, status, tty, true)
 		case "c:0": // ctrl-space, build source code to executable, convert to PDF or write to PNG, depending on the mode
 
+			// Pressing ctrl-space while a build is running cancels it
+			if builder.Busy() {
+				builder.Cancel()
+				break
+			}
+
+			// Pressing ctrl-space again after a successful build runs the program
+			if previousKey == "c:0" && builtSuccessfully && !e.changed {
+				builtSuccessfully = false
+				if _, _, err := e.RunCommand(e.filename); err == nil {
+					e.RunInBackground(c, status, func() (string, error) {
+						return e.RunProgram(c, tty, status)
+					})
+					break
+				}
+			}
+
 			// Save the current file, but only if it has changed
 			if e.changed {
 				if err := e.Save(c); err != nil {
@@ -327,53 +407,61 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			// Press ctrl-space twice the first time the PDF should be exported to Markdown,
 			// to avvoid the first accidental ctrl-space key press.
 
-			// Build or export the current file
-			var (
-				statusMessage   string
-				performedAction bool
-				compiled        bool
-			)
-
-			if e.mode == modeMarkdown && markdownSkipExport {
-				// Do nothing, but don't skip the next one
-				markdownSkipExport = false
-				// } else if e.mode == modeMarkdown && !markdownSkipExport{
-				// statusMessage, performedAction, compiled = e.BuildOrExport(c, status, e.filename)
-			} else {
-				statusMessage, performedAction, compiled = e.BuildOrExport(c, status, e.filename)
-			}
-
-			//logf("status message %s performed action %v compiled %v filename %s\n", statusMessage, performedAction, compiled, e.filename)
+			// Build or export the current file in the background, so that the editor can be used meanwhile
+			builder.Go(c, e, func() {
+				var (
+					statusMessage   string
+					performedAction bool
+					compiled        bool
+				)
 
-			// Could an action be performed for this file extension?
-			if !performedAction {
-				status.ClearAll(c)
-				// Building this file extension is not implemented yet.
-				// Just display the current time and word count.
-				// TODO: status.ClearAll() should have cleared the status bar first, but this is not always true,
-				//       which is why the message is hackily surrounded by spaces. Fix.
-				statusMessage := fmt.Sprintf("    %d words, %s    ", e.WordCount(), time.Now().Format("15:04")) // HH:MM
-				status.SetMessage(statusMessage)
-				status.Show(c, e)
-			} else if performedAction && !compiled {
-				status.ClearAll(c)
-				// Performed an action, but it did not work out
-				if statusMessage != "" {
-					status.SetErrorMessage(statusMessage)
+				if e.mode == modeMarkdown && markdownSkipExport {
+					// Do nothing, but don't skip the next one
+					markdownSkipExport = false
+					// } else if e.mode == modeMarkdown && !markdownSkipExport{
+					// statusMessage, performedAction, compiled = e.BuildOrExport(c, tty, status, e.filename)
 				} else {
-					// This should never happen, failed compilations should return a message
-					status.SetErrorMessage("Compilation failed")
+					statusMessage, performedAction, compiled = e.BuildOrExport(c, tty, status, e.filename)
 				}
-				status.ShowNoTimeout(c, e)
-			} else if performedAction && compiled {
-				// Everything worked out
-				if statusMessage != "" {
-					// Got a status message (this may not be the case for build/export processes running in the background)
-					// NOTE: Do not clear the status message first here!
+
+				//logf("status message %s performed action %v compiled %v filename %s\n", statusMessage, performedAction, compiled, e.filename)
+
+				builtSuccessfully = performedAction && compiled
+
+				// Could an action be performed for this file extension?
+				if !performedAction {
+					status.ClearAll(c)
+					// Building this file extension is not implemented yet.
+					// Just display the current time and word count.
+					// TODO: status.ClearAll() should have cleared the status bar first, but this is not always true,
+					//       which is why the message is hackily surrounded by spaces. Fix.
+					statusMessage := fmt.Sprintf("    %d words, %s    ", e.WordCount(), time.Now().Format("15:04")) // HH:MM
 					status.SetMessage(statusMessage)
+					status.Show(c, e)
+				} else if performedAction && !compiled {
+					status.ClearAll(c)
+					// If there are several errors and the cursor was moved to one of them, show which one
+					if n, pos := quickfix.Len(), quickfix.Pos(); n > 1 && pos >= 0 && statusMessage != "" {
+						statusMessage = fmt.Sprintf("%s %d/%d: %s", quickfix.Entries()[pos].severity, pos+1, n, statusMessage)
+					}
+					// Performed an action, but it did not work out
+					if statusMessage != "" {
+						status.SetErrorMessage(statusMessage)
+					} else {
+						// This should never happen, failed compilations should return a message
+						status.SetErrorMessage("Compilation failed")
+					}
 					status.ShowNoTimeout(c, e)
+				} else if performedAction && compiled {
+					// Everything worked out
+					if statusMessage != "" {
+						// Got a status message (this may not be the case for build/export processes running in the background)
+						// NOTE: Do not clear the status message first here!
+						status.SetMessage(statusMessage)
+						status.ShowNoTimeout(c, e)
+					}
 				}
-			}
+			})
 		case "c:20": // ctrl-t, render to PDF
 			// If in a C++ header file, switch to the corresponding
 			// C++ source file, and the other way around.
@@ -449,7 +537,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			status.ClearAll(c)
 			undo.Snapshot(e)
 			undoBackup := undo
-			lastCommandMenuIndex = e.CommandMenu(c, status, tty, undo, lastCommandMenuIndex, forceFlag, lk)
+			lastCommandMenuIndex = e.CommandMenu(c, status, tty, undo, lastCommandMenuIndex, lk)
 			undo = undoBackup
 			if e.AfterEndOfLine() {
 				e.End(c)
@@ -768,6 +856,8 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				//break
 			}
 			undo.Snapshot(e)
+			// Delete the closing rune too, if the cursor is between a pair, and auto-pairing is enabled
+			e.AutoPairBackspace()
 			// Delete the character to the left
 			if e.EmptyLine() {
 				e.DeleteLine(e.DataY())
@@ -811,49 +901,40 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			y := int(e.DataY())
 			r := e.Rune()
 			leftRune := e.LeftRune()
-			ext := filepath.Ext(e.filename)
-
-			// Tab completion of words for Go
-			if word := e.LettersBeforeCursor(); e.mode != modeBlank && leftRune != '.' && !unicode.IsLetter(r) && len(word) > 0 {
-				found := false
-				expandedWord := ""
-				for kw := range syntax.Keywords {
-					if strings.HasPrefix(kw, word) {
-						if !found || (len(kw) < len(expandedWord)) && (len(expandedWord) > 0) {
-							expandedWord = kw
-							found = true
-						}
-					}
-				}
 
-				// Found a suitable keyword to expand to? Insert the rest of the string.
-				if found {
-					toInsert := strings.TrimPrefix(expandedWord, word)
+			// Expand a snippet, if the word before the cursor is the name of one
+			if word := e.IdentifierBeforeCursor(); word != "" && !isIdentifierRune(r) {
+				if snippet, found := e.Snippets()[word]; found {
 					undo.Snapshot(e)
+					e.ExpandSnippet(c, status, tty, word, snippet)
 					e.redrawCursor = true
 					e.redraw = true
-					// Insert the part of expandedWord that comes after the current word
-					e.InsertStringAndMove(c, toInsert)
 					break
 				}
+			}
 
-				// Tab completion after a '.'
-			} else if word := e.LettersBeforeCursor(); e.mode != modeBlank && leftRune == '.' && !unicode.IsLetter(r) && len(word) > 0 {
-				// Now the preceding word before the "." has been found
-
-				// Grep all files in this directory with the same extension as the currently edited file
-				// for what could follow the word and a "."
-				suggestions := corpus(word, "*"+ext)
-
-				// Choose a suggestion (tab cycles to the next suggestion)
-				chosen := e.SuggestMode(c, status, tty, suggestions)
-				e.redrawCursor = true
-				e.redraw = true
-
-				if chosen != "" {
+			// Tab completion of words, with a popup where the list is filtered while typing
+			if word := e.IdentifierBeforeCursor(); !isIdentifierRune(r) && (len(word) > 0 || leftRune == '.') {
+				completions := e.Completions(word)
+				if filtered := filterCompletions(completions, word); len(filtered) == 1 {
+					// Only one possible completion, insert it right away
 					undo.Snapshot(e)
-					// Insert the chosen word
-					e.InsertStringAndMove(c, chosen)
+					e.InsertCompletion(c, word, filtered[0].text)
+					e.ShowCompletionDetail(c, status, filtered, filtered[0].text)
+					e.redrawCursor = true
+					e.redraw = true
+					break
+				} else if len(filtered) > 1 {
+					completed, chosen := e.CompletionPopup(c, status, tty, word, completions)
+					if completed != word {
+						undo.Snapshot(e)
+						e.InsertCompletion(c, word, completed)
+					}
+					if chosen {
+						e.ShowCompletionDetail(c, status, completions, completed)
+					}
+					e.redrawCursor = true
+					e.redraw = true
 					break
 				}
 			}
@@ -1042,6 +1123,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			}
 
 			// Search either forwards or backwards to find a matching rune
+			fromY := e.DataY()
 			switch r {
 			case '(', '{', '[':
 				parcount := 0
@@ -1074,11 +1156,12 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 					e.Prev(c)
 				}
 			}
+			e.RecordJump(fromY, e.DataY())
 
 			e.redrawCursor = true
 			e.redraw = true
 		case "c:19": // ctrl-s, save
-			e.UserSave(c, status)
+			e.UserSave(c, tty, status)
 		case "c:21", "c:26": // ctrl-u or ctrl-z, undo (ctrl-z may background the application)
 			// Forget the cut, copy and paste line state
 			lastCutY = -1
@@ -1102,6 +1185,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			status.SetMessage("Go to line number:")
 			status.ShowNoTimeout(c, e)
 			lns := ""
+			jumpKey := ""
 			cancel := false
 			doneCollectingDigits := false
 			for !doneCollectingDigits {
@@ -1117,7 +1201,13 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 						status.SetMessage("Go to line number: " + lns)
 						status.ShowNoTimeout(c, e)
 					}
-				case "↑", "↓": // up arrow or down arrow
+				case "←", "→": // left arrow or right arrow, walk the jump list
+					jumpKey = numkey
+					fallthrough
+				case "↑", "↓": // up arrow or down arrow, step through the build errors, if any
+					if (numkey == "↑" || numkey == "↓") && quickfix.Len() > 0 {
+						jumpKey = numkey
+					}
 					fallthrough
 				case "c:27", "c:17": // esc or ctrl-q
 					cancel = true
@@ -1131,7 +1221,22 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				e.ClearSearchTerm()
 			}
 			status.ClearAll(c)
-			if lns == "" && !cancel {
+			if jumpKey != "" {
+				var msg string
+				switch jumpKey {
+				case "←":
+					msg = e.JumpBack(tty, c, status, lk)
+				case "→":
+					msg = e.JumpForward(tty, c, status, lk)
+				case "↓":
+					msg = e.QuickfixNext(tty, c, status, lk)
+				case "↑":
+					msg = e.QuickfixPrev(tty, c, status, lk)
+				}
+				status.SetMessage(msg)
+				status.Show(c, e)
+			} else if lns == "" && !cancel {
+				fromY := e.DataY()
 				if e.DataY() > 0 {
 					// If not at the top, go to the first line (by line number, not by index)
 					e.redraw = e.GoToLineNumber(1, c, status, true)
@@ -1139,10 +1244,13 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 					// Go to the last line (by line number, not by index, e.Len() returns an index which is why there is no -1)
 					e.redraw = e.GoToLineNumber(LineNumber(e.Len()), c, status, true)
 				}
+				e.RecordJump(fromY, e.DataY())
 			} else {
 				// Go to the specified line
 				if ln, err := strconv.Atoi(lns); err == nil { // no error
+					fromY := e.DataY()
 					e.redraw = e.GoToLineNumber(LineNumber(ln), c, status, true)
+					e.RecordJump(fromY, e.DataY())
 				}
 			}
 			e.redrawCursor = true
@@ -1166,12 +1274,13 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				lastPasteY = -1
 				// Copy the line internally
 				copyLines = []string{line}
+				killRing.Push(copyLines)
 
 				// Copy the line to the clipboard
-				err = clipboard.WriteAll(line)
+				err = clip.WriteAll(line)
 				if err == nil {
 					// no issue
-				} else if firstCopyAction {
+				} else if firstCopyAction && clip.Name() == "system" {
 					missingUtility := false
 
 					if hasE("DISPLAY") { // X11
@@ -1207,9 +1316,10 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 					break
 				}
 				copyLines = append(copyLines, lines...)
+				killRing.Push(copyLines)
 				s = strings.Join(copyLines, "\n")
 				// Place the block of text in the clipboard
-				_ = clipboard.WriteAll(s)
+				_ = clip.WriteAll(s)
 				// Delete the corresponding number of lines
 				for range lines {
 					e.DeleteLine(y)
@@ -1233,6 +1343,12 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			lastCutY = -1
 
 			undo.Snapshot(e)
+			// Remember the deleted text in the clipboard history
+			if x, err := e.DataX(); err == nil { // no error
+				if rest := []rune(e.CurrentLine()); x < len(rest) {
+					killRing.Push([]string{string(rest[x:])})
+				}
+			}
 			e.DeleteRestOfLine()
 			if e.EmptyRightTrimmedLine() {
 				// Deleting the rest of the line cleared this line,
@@ -1268,9 +1384,10 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				if trimmed != "" {
 					// Copy the line to the internal clipboard
 					copyLines = []string{trimmed}
+					killRing.Push(copyLines)
 					// Copy the line to the clipboard
 					s := "Copied 1 line"
-					if err := clipboard.WriteAll(strings.Join(copyLines, "\n")); err == nil { // OK
+					if err := clip.WriteAll(strings.Join(copyLines, "\n")); err == nil { // OK
 						// The copy operation worked out, using the clipboard
 						s += " from the clipboard"
 					}
@@ -1291,6 +1408,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				s := e.Block(y)
 				if s != "" {
 					copyLines = strings.Split(s, "\n")
+					killRing.Push(copyLines)
 					// Prepare a status message
 					plural := ""
 					lineCount := strings.Count(s, "\n")
@@ -1298,7 +1416,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 						plural = "s"
 					}
 					// Place the block of text in the clipboard
-					err := clipboard.WriteAll(s)
+					err := clip.WriteAll(s)
 					if err != nil {
 						status.SetMessage(fmt.Sprintf("Copied %d line%s", lineCount, plural))
 					} else {
@@ -1310,14 +1428,32 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 		case "c:22": // ctrl-v, paste
 
 			// Save the file right before pasting, just in case wl-paste stops
-			e.UserSave(c, status)
+			e.UserSave(c, tty, status)
+
+			// Only text from the clipboard can be cycled with ctrl-_, not text from a portal
+			cyclePaste = false
 
 			var (
 				gotLineFromPortal bool
 				line              string
 			)
 
-			if portal, err := LoadPortal(); err == nil { // no error
+			if portal, err := LoadPortal(); err == nil && portal.lineCount > 1 { // a portal with a range of lines
+				// Paste all the lines at once, and keep the portal open
+				lines, err := portal.Lines()
+				status.Clear(c)
+				if err != nil {
+					status.SetErrorMessage(err.Error())
+					ClosePortal()
+				} else {
+					undo.Snapshot(e)
+					e.PasteLines(c, lines)
+					status.SetMessage(fmt.Sprintf("Pasted %d lines from the portal at %s", len(lines), portal))
+					e.redraw = true
+				}
+				status.Show(c, e)
+				break
+			} else if err == nil { // a portal to a single line
 				line, err = portal.PopLine(false)
 				status.Clear(c)
 				if err != nil {
@@ -1353,7 +1489,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			// This may only work for the same user, and not with sudo/su
 
 			// Try fetching the lines from the clipboard first
-			s, err := clipboard.ReadAll()
+			s, err := clip.ReadAll()
 			if err == nil { // no error
 				// Fix nonbreaking spaces first
 				s = strings.Replace(s, string([]byte{0xc2, 0xa0}), string([]byte{0x20}), -1)
@@ -1369,7 +1505,7 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				// Split the text into lines and store it in "copyLines"
 				copyLines = strings.Split(s, "\n")
 
-			} else if firstPasteAction {
+			} else if firstPasteAction && clip.Name() == "system" {
 				missingUtility := false
 
 				status.Clear(c)
@@ -1412,6 +1548,10 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			undo.Snapshot(e)
 			y := e.DataY()
 
+			// The pasted text can now be replaced with older clipboard history entries, with ctrl-_
+			killRingIndex = killRing.Index(copyLines)
+			cyclePaste = true
+
 			// Forget the cut and copy line state
 			lastCutY = -1
 			lastCopyY = -1
@@ -1470,6 +1610,53 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 			// Prepare to redraw the text
 			e.redrawCursor = true
 			e.redraw = true
+		case "c:29": // ctrl-], go to the definition of the identifier under the cursor, using a language server or a tags file
+			status.ClearAll(c)
+			msg, err := e.LSPDefinition(tty, c, status, lk)
+			if err == errLSPNotRunning {
+				// Use the tags file instead
+				msg, err = e.GoToDefinition(tty, c, status, lk)
+			}
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else if msg != "" {
+				status.SetMessage(msg)
+			}
+			status.Show(c, e)
+			e.redraw = true
+			e.redrawCursor = true
+		case "c:31": // ctrl-_, cycle the text that was just pasted through the clipboard history, or select from a menu
+			status.ClearAll(c)
+			if (previousKey == "c:22" || previousKey == "c:31") && cyclePaste && killRing.Len() > 0 {
+				next := (killRingIndex + 1) % killRing.Len()
+				if next == killRingIndex {
+					status.SetMessage("There is nothing else in the clipboard history")
+					status.Show(c, e)
+					break
+				}
+				// Undo the previous paste, then paste the next entry instead
+				if err := undo.Restore(e); err != nil {
+					break
+				}
+				undo.Snapshot(e)
+				copyLines = killRing.Get(next)
+				e.PasteLines(c, copyLines)
+				killRingIndex = next
+				status.SetMessage(fmt.Sprintf("Clipboard history %d/%d", next+1, killRing.Len()))
+				status.Show(c, e)
+			} else if lines := e.KillRingMenu(c, status, tty, killRing); lines != nil {
+				undo.Snapshot(e)
+				copyLines = lines
+				clip.WriteAll(strings.Join(copyLines, "\n"))
+				e.PasteLines(c, copyLines)
+				killRingIndex = killRing.Index(copyLines)
+				cyclePaste = true
+			} else if killRing.Len() == 0 {
+				status.SetMessage("The clipboard history is empty")
+				status.Show(c, e)
+			}
+			e.redraw = true
+			e.redrawCursor = true
 		case "c:18": // ctrl-r, to open or close a portal
 
 			// Are we in git mode?
@@ -1484,9 +1671,18 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 
 			// Deal with the portal
 			status.Clear(c)
-			if HasPortal() {
-				status.SetMessage("Closing portal")
-				ClosePortal()
+			if portal, err := LoadPortal(); err == nil { // no error
+				// If the portal is in this file, and the cursor is below the portal range, extend the range
+				if absFilename, err := e.AbsFilename(); err == nil && absFilename == portal.absFilename && portal.Extend(e.LineNumber()) {
+					if err := portal.Save(); err != nil {
+						status.SetErrorMessage(err.Error())
+					} else {
+						status.SetMessage("Extended the portal to " + portal.String())
+					}
+				} else {
+					status.SetMessage("Closing portal")
+					ClosePortal()
+				}
 			} else {
 				portal, err := e.NewPortal()
 				if err != nil {
@@ -1502,31 +1698,15 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 				status.SetMessage("Opening a portal at " + portal.String())
 			}
 			status.Show(c, e)
-		case "c:2": // ctrl-b, bookmark, unbookmark or jump to bookmark
+		case "c:2": // ctrl-b, toggle a bookmark, or select a bookmark to jump to
 			status.Clear(c)
-			if bookmark == nil {
-				// no bookmark, create a bookmark at the current line
-				bookmark = e.pos.Copy()
-				// TODO: Modify the statusbar implementation so that extra spaces are not needed here.
-				s := "Bookmarked line " + e.LineNumber().String()
-				status.SetMessage("  " + s + "  ")
-			} else if bookmark.LineNumber() == e.LineNumber() {
-				// bookmarking the same line twice: remove the bookmark
-				s := "Removed bookmark for line " + bookmark.LineNumber().String()
-				status.SetMessage(s)
-				bookmark = nil
-			} else {
-				undo.Snapshot(e)
-				// Go to the saved bookmark position
-				e.GoToPosition(c, status, *bookmark)
-				// Do the redraw manually before showing the status message
-				e.DrawLines(c, true, false)
-				e.redraw = false
-				// Show the status message
-				s := "Jumped to bookmark at line " + e.LineNumber().String()
-				status.SetMessage(s)
+			if _, onBookmark := e.bookmarks.At(e.DataY()); onBookmark || len(e.bookmarks) == 0 {
+				status.SetMessage(e.ToggleBookmark())
+			} else if msg := e.BookmarkMenu(c, status, tty); msg != "" {
+				status.SetMessage(msg)
 			}
 			status.Show(c, e)
+			e.redraw = true
 			e.redrawCursor = true
 		case "c:10": // ctrl-j, join line
 			if e.Empty() {
@@ -1580,6 +1760,12 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 					r = ' '
 				}
 
+				// Pair brackets and quotes, or type over the closing rune, if enabled
+				if e.AutoPair(c, r) {
+					e.redrawCursor = true
+					break
+				}
+
 				// "smart dedent"
 				if r == '}' || r == ']' || r == ')' {
 
@@ -1652,26 +1838,31 @@ func Loop(tty *vt100.TTY, filename string, lineNumber LineNumber, colNumber ColN
 		previousX = x
 		previousY = y
 
-	} // end of main loop
+		autosave.Keypress()
+		autosave.Unlock()
 
-	if canUseLocks {
-		// Start by loading the lock overview, just in case something has happened in the mean time
-		lk.Load()
+		if key != "" {
+			lspSyncTimer.Reset(lspSyncDelay)
+		}
 
-		// Check if the lock is unchanged
-		fileLockTimestamp := lk.GetTimestamp(absFilename)
-		lockUnchanged := lockTimestamp == fileLockTimestamp
+	} // end of main loop
 
-		// TODO: If the stored timestamp is older than uptime, unlock and save the lock overview
+	// Stop the build that is running in the background, if any
+	builder.Stop()
+	close(buildQuit)
 
-		//var notime time.Time
+	// Stop the autosave goroutine and remove the swap file, if any
+	autosave.Done()
 
-		if !forceFlag || lockUnchanged {
-			// If the file has not been locked externally since this instance of the editor was loaded, don't
-			// Unlock the current file and save the lock overview. Ignore errors because they are not critical.
-			lk.Unlock(absFilename)
-			lk.Save()
-		}
+	// Stop the language servers, if any
+	lspSyncTimer.Stop()
+	close(lspQuit)
+	StopLSP()
+
+	if canUseLocks {
+		// Unlock the current file. If the lock has been taken over by another instance
+		// of the editor in the mean time (with "o -f"), it is left alone. Ignore errors because they are not critical.
+		lk.Unlock(absFilename)
 	}
 
 	// Save the current location in the location history and write it to file
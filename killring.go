@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/env"
+	"github.com/xyproto/vt100"
+)
+
+const killRingFilename = "killring.gob" // in the cache directory
+
+var (
+	// How many cuts and copies to remember
+	killRingSize = env.Int("O_KILL_RING_SIZE", 30)
+
+	// Keep the kill ring across sessions, if O_KILL_RING_PERSIST is set
+	persistKillRing = env.Bool("O_KILL_RING_PERSIST")
+)
+
+// KillRing remembers the last cut, copied or killed texts, newest first
+type KillRing struct {
+	entries  [][]string
+	maxLen   int
+	filename string // the file to save the kill ring to, or blank if it should not be saved
+}
+
+// NewKillRing creates a new KillRing that can hold maxLen entries.
+// If a filename is given, the kill ring is saved to that file every time it changes.
+func NewKillRing(maxLen int, filename string) *KillRing {
+	return &KillRing{make([][]string, 0, maxLen), maxLen, filename}
+}
+
+// Len returns the number of entries in the kill ring
+func (kr *KillRing) Len() int {
+	return len(kr.entries)
+}
+
+// Get returns the entry with the given index, where 0 is the newest
+func (kr *KillRing) Get(index int) []string {
+	if index < 0 || index >= len(kr.entries) {
+		return nil
+	}
+	return kr.entries[index]
+}
+
+// Index returns the index of the given lines in the kill ring, or -1
+func (kr *KillRing) Index(lines []string) int {
+	for i, entry := range kr.entries {
+		if equalStringSlices(entry, lines) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Push adds the given lines as the newest entry. Blank texts are skipped. If the lines are
+// already in the kill ring, they are moved to the front. If the newest entry is the start
+// of the given lines (like when ctrl-x is pressed twice), the newest entry is replaced.
+func (kr *KillRing) Push(lines []string) {
+	if kr.maxLen <= 0 || strings.TrimSpace(strings.Join(lines, "\n")) == "" {
+		return
+	}
+	entry := make([]string, len(lines))
+	copy(entry, lines)
+	if i := kr.Index(entry); i != -1 {
+		kr.entries = append(kr.entries[:i], kr.entries[i+1:]...)
+	} else if len(kr.entries) > 0 && len(kr.entries[0]) < len(entry) && equalStringSlices(kr.entries[0], entry[:len(kr.entries[0])]) {
+		kr.entries = kr.entries[1:]
+	}
+	kr.entries = append([][]string{entry}, kr.entries...)
+	if len(kr.entries) > kr.maxLen {
+		kr.entries = kr.entries[:kr.maxLen]
+	}
+	if kr.filename != "" {
+		// Ignore errors, the kill ring is not important enough to bother the user with
+		kr.Save()
+	}
+}
+
+// Load reads the kill ring from the kill ring file
+func (kr *KillRing) Load() error {
+	f, err := os.Open(kr.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var entries [][]string
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return err
+	}
+	if len(entries) > kr.maxLen {
+		entries = entries[:kr.maxLen]
+	}
+	kr.entries = entries
+	return nil
+}
+
+// Save writes the kill ring to the kill ring file. Only the current user can read it.
+func (kr *KillRing) Save() error {
+	if err := os.MkdirAll(filepath.Dir(kr.filename), 0700); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(kr.entries); err != nil {
+		return err
+	}
+	return writeFileAtomic(kr.filename, buf.Bytes(), 0600, -1, -1)
+}
+
+// Preview returns a one-line description of the entry with the given index, for use in menus
+func (kr *KillRing) Preview(index int, maxWidth int) string {
+	entry := kr.Get(index)
+	if len(entry) == 0 {
+		return ""
+	}
+	var first string
+	for _, line := range entry {
+		// Use the first line that is not blank
+		if first = strings.TrimSpace(line); first != "" {
+			break
+		}
+	}
+	suffix := ""
+	if len(entry) > 1 {
+		suffix = fmt.Sprintf(" (%d lines)", len(entry))
+	}
+	if maxWidth > len(suffix)+4 && len([]rune(first))+len(suffix) > maxWidth {
+		first = string([]rune(first)[:maxWidth-len(suffix)-3]) + "..."
+	}
+	return first + suffix
+}
+
+// KillRingMenu lets the user select an entry from the kill ring by looking at a preview of each entry.
+// Returns the selected entry, or nil.
+func (e *Editor) KillRingMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, kr *KillRing) []string {
+	if kr.Len() == 0 {
+		return nil
+	}
+	maxWidth := 60
+	if c != nil && int(c.Width()) > 20 {
+		maxWidth = int(c.Width()) - 20
+	}
+	choices := make([]string, kr.Len())
+	for i := range choices {
+		choices[i] = kr.Preview(i, maxWidth)
+	}
+	selected := e.Menu(status, tty, "Paste from the clipboard history", choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+	e.redraw = true
+	e.redrawCursor = true
+	return kr.Get(selected)
+}
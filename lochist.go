@@ -12,7 +12,7 @@ import (
 )
 
 const (
-	locationHistoryFilename      = "~/.cache/o/locations.txt" // TODO: Use XDG_CACHE_HOME
+	locationHistoryFilename      = "locations.txt" // in the cache directory
 	vimLocationHistoryFilename   = "~/.viminfo"
 	emacsLocationHistoryFilename = "~/.emacs.d/places"
 	nvimLocationHistoryFilename  = "~/.local/share/nvim/shada/main.shada" // TODO: Use XDG_DATA_HOME
@@ -423,6 +423,10 @@ func (e *Editor) SaveLocation(absFilename string, locationHistory map[string]Lin
 	}
 	// Save the current line location
 	locationHistory[absFilename] = e.LineNumber()
+	// Save the bookmarks for this file, ignore errors
+	SaveBookmarks(absFilename, e.bookmarks)
+	// Save the frequently visited lines for this file, ignore errors
+	SaveHotSpots(absFilename, e.hotSpots)
 	// Save the location history and return the error, if any
-	return SaveLocationHistory(locationHistory, expandUser(locationHistoryFilename))
+	return SaveLocationHistory(locationHistory, cachePath(locationHistoryFilename))
 }
@@ -1,123 +1,310 @@
 package main
 
 import (
-	"encoding/gob"
+	"bufio"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/xyproto/vt100"
 )
 
-const defaultLockFile = "~/.cache/o/lockfile.txt"
+const defaultLockDirectory = "locks" // in the cache directory
 
-// LockKeeper keeps track of which files are currently being edited by o
-type LockKeeper struct {
-	lockedFiles  map[string]time.Time // from filename to lockfilestamp
-	mut          *sync.RWMutex
-	lockFilename string
+// FileLock is the contents of a lock file, which says which editor process is editing a file
+type FileLock struct {
+	filename string // the absolute filename of the file that is being edited
+	pid      int
+	hostname string
+	started  time.Time
 }
 
-// NewLockKeeper takes an expanded path (not containing ~) to a lock file
-// and creates a new LockKeeper struct, without loading the given lock file.
-func NewLockKeeper(lockFilename string) *LockKeeper {
-	lockMap := make(map[string]time.Time)
-	return &LockKeeper{lockMap, &sync.RWMutex{}, lockFilename}
+// LockedError is returned when a file is already locked by a running instance of the editor
+type LockedError struct {
+	lock *FileLock
 }
 
-// Load loads the contents of the main lockfile
-func (lk *LockKeeper) Load() error {
-	f, err := os.Open(lk.lockFilename)
-	if err != nil {
-		return err
+func (le *LockedError) Error() string {
+	return le.lock.filename + " is locked by " + le.lock.String()
+}
+
+// String returns a short description of who holds the lock
+func (fl *FileLock) String() string {
+	return fmt.Sprintf("PID %d on %s, since %s", fl.pid, fl.hostname, fl.started.Format("2006-01-02 15:04"))
+}
+
+// Ours checks if the lock is held by this process
+func (fl *FileLock) Ours() bool {
+	return fl.pid == os.Getpid() && fl.hostname == hostname()
+}
+
+// Stale checks if the process that holds the lock is gone. Locks held by processes on
+// other hosts (when the home directory is shared) can not be checked, and are never stale.
+func (fl *FileLock) Stale() bool {
+	if fl.hostname != hostname() {
+		return false
 	}
-	defer f.Close()
+	// The PID may have been reused since the last boot
+	if bootTime, err := bootTime(); err == nil && fl.started.Before(bootTime) {
+		return true
+	}
+	return !processAlive(fl.pid)
+}
 
-	f.Sync()
+// LockKeeper keeps track of which files are currently being edited by o,
+// by using one lock file per edited file in a lock directory
+type LockKeeper struct {
+	lockDirectory string
+}
 
-	dec := gob.NewDecoder(f)
-	lockMap := make(map[string]time.Time)
-	err = dec.Decode(&lockMap)
-	if err != nil {
-		return err
+// NewLockKeeper takes an expanded path (not containing ~) to a lock directory
+// and creates a new LockKeeper struct. The directory is created, if needed.
+func NewLockKeeper(lockDirectory string) (*LockKeeper, error) {
+	// Only the current user should be able to read the lock files
+	if err := os.MkdirAll(lockDirectory, 0700); err != nil {
+		return nil, err
 	}
-	lk.mut.Lock()
-	lk.lockedFiles = lockMap
-	lk.mut.Unlock()
-	return nil
+	return &LockKeeper{lockDirectory}, nil
 }
 
-// Save writes the contents of the main lockfile
-func (lk *LockKeeper) Save() error {
-	// First create the folder for the lock file overview, if needed
-	folderPath := filepath.Dir(lk.lockFilename)
-	os.MkdirAll(folderPath, os.ModePerm)
+// lockFilename returns the lock filename for the given absolute filename
+func (lk *LockKeeper) lockFilename(absFilename string) string {
+	return filepath.Join(lk.lockDirectory, url.QueryEscape(absFilename)+".lock")
+}
 
-	f, err := os.OpenFile(lk.lockFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+// hostname returns the hostname, or "localhost" if it can not be found
+func hostname() string {
+	if name, err := os.Hostname(); err == nil { // success
+		return name
 	}
-	defer f.Close()
+	return "localhost"
+}
 
-	enc := gob.NewEncoder(f)
+// processAlive checks if a process with the given PID is running on this host
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// Sending signal 0 checks if the process exists, without sending a signal.
+	// EPERM means that the process exists, but belongs to another user.
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
 
-	lk.mut.RLock()
-	err = enc.Encode(lk.lockedFiles)
-	lk.mut.RUnlock()
+// bootTime returns the time when the system was booted, by reading /proc/stat
+func bootTime() (time.Time, error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("could not find the boot time in /proc/stat")
+}
 
-	f.Sync()
+// readLock reads and parses the given lock file
+func readLock(lockFilename string) (*FileLock, error) {
+	f, err := os.Open(lockFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 4 {
+		return nil, errors.New("invalid lock file: " + lockFilename)
+	}
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	started, err := time.Parse(time.RFC3339, lines[2])
+	if err != nil {
+		return nil, err
+	}
+	return &FileLock{lines[3], pid, lines[1], started}, nil
+}
 
+// create atomically creates a lock file for the given absolute filename, owned by this process.
+// Fails if the lock file already exists.
+func (lk *LockKeeper) create(absFilename string) error {
+	f, err := os.OpenFile(lk.lockFilename(absFilename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d\n%s\n%s\n%s\n", os.Getpid(), hostname(), time.Now().Format(time.RFC3339), absFilename)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
 	return err
 }
 
-// Lock marks the given absolute filename as locked.
-// If the file is already locked, an error is returned.
-func (lk *LockKeeper) Lock(filename string) error {
-	var has bool
-	lk.mut.RLock()
-	_, has = lk.lockedFiles[filename]
-	lk.mut.RUnlock()
+// Same checks if two locks were taken by the same process at the same time
+func (fl *FileLock) Same(other *FileLock) bool {
+	return fl.pid == other.pid && fl.hostname == other.hostname && fl.started.Equal(other.started) && fl.filename == other.filename
+}
 
-	if has {
-		return errors.New("already locked: " + filename)
+// takeOver removes the given lock file, if it holds the given stale lock (or is unreadable,
+// if stale is nil), but only if the lock file still is the one that was found to be stale.
+// The lock file is first renamed to a name that is unique to this process, so that two instances
+// can not both find the same stale lock, and then one of them remove the fresh lock of the other.
+func takeOver(lockFilename string, stale *FileLock) {
+	staleFilename := fmt.Sprintf("%s.%d.stale", lockFilename, os.Getpid())
+	if err := os.Rename(lockFilename, staleFilename); err != nil {
+		// Already taken over by another process
+		return
 	}
+	lock, err := readLock(staleFilename)
+	if stale == nil && err != nil {
+		// Still unreadable, but it may have been created by another instance just now
+		if fileInfo, err := os.Stat(staleFilename); err == nil && time.Since(fileInfo.ModTime()) >= time.Second {
+			os.Remove(staleFilename)
+			return
+		}
+	} else if stale != nil && err == nil && lock.Same(stale) {
+		os.Remove(staleFilename)
+		return
+	}
+	// Another instance created a fresh lock in the meantime, so put it back.
+	// Link fails, instead of overwriting, if yet another lock file has been created since then.
+	os.Link(staleFilename, lockFilename)
+	os.Remove(staleFilename)
+}
 
-	// Add the file to the map
-	lk.mut.Lock()
-	lk.lockedFiles[filename] = time.Now()
-	lk.mut.Unlock()
-
-	return nil
+// Lock marks the given absolute filename as locked by this process.
+// If the file is locked by a process that is no longer running, the stale lock is taken over.
+// If the file is locked by a running process, a *LockedError is returned.
+func (lk *LockKeeper) Lock(absFilename string) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		err := lk.create(absFilename)
+		if err == nil || !os.IsExist(err) {
+			return err
+		}
+		lock, err := readLock(lk.lockFilename(absFilename))
+		if err == nil && lock.Ours() {
+			return nil
+		}
+		if err == nil && !lock.Stale() {
+			return &LockedError{lock}
+		}
+		if err != nil {
+			// Another instance may be writing the lock file right now
+			if fileInfo, err := os.Stat(lk.lockFilename(absFilename)); err == nil && time.Since(fileInfo.ModTime()) < time.Second {
+				return errors.New("could not lock " + absFilename + ", it is being locked by another process")
+			}
+			lock = nil
+		}
+		// The lock file is stale or unreadable, take it over and try again
+		takeOver(lk.lockFilename(absFilename), lock)
+	}
+	return errors.New("could not lock " + absFilename)
 }
 
-// Unlock marks the given absolute filename as unlocked.
-// If the file is already unlocked, an error is returned.
-func (lk *LockKeeper) Unlock(filename string) error {
-	var has bool
-	lk.mut.RLock()
-	_, has = lk.lockedFiles[filename]
-	lk.mut.RUnlock()
+// ForceLock marks the given absolute filename as locked by this process,
+// regardless of any other process holding the lock
+func (lk *LockKeeper) ForceLock(absFilename string) error {
+	os.Remove(lk.lockFilename(absFilename))
+	return lk.Lock(absFilename)
+}
 
-	if !has {
+// Unlock removes the lock for the given absolute filename, but only if it is held by this process.
+// If the lock was taken over by another process (with "o -f"), it is left alone.
+func (lk *LockKeeper) Unlock(absFilename string) error {
+	lock, err := readLock(lk.lockFilename(absFilename))
+	if err != nil {
 		// Caller can ignore this error if they want
-		return errors.New("already unlocked: " + filename)
+		return err
 	}
-
-	// Remove the file from the map
-	lk.mut.Lock()
-	delete(lk.lockedFiles, filename)
-	lk.mut.Unlock()
-
-	return nil
+	if !lock.Ours() {
+		return errors.New("locked by another process: " + absFilename)
+	}
+	return os.Remove(lk.lockFilename(absFilename))
 }
 
-// GetTimestamp assumes that the file is locked. A blank timestamp may be returned if not.
-func (lk *LockKeeper) GetTimestamp(filename string) time.Time {
-	var timestamp time.Time
-
-	lk.mut.RLock()
-	timestamp = lk.lockedFiles[filename]
-	lk.mut.RUnlock()
+// Locks returns all current locks, sorted by filename. Stale locks are removed.
+func (lk *LockKeeper) Locks() ([]*FileLock, error) {
+	lockFilenames, err := filepath.Glob(filepath.Join(lk.lockDirectory, "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+	var locks []*FileLock
+	for _, lockFilename := range lockFilenames {
+		lock, err := readLock(lockFilename)
+		if err != nil {
+			takeOver(lockFilename, nil)
+			continue
+		}
+		if lock.Stale() {
+			takeOver(lockFilename, lock)
+			continue
+		}
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].filename < locks[j].filename
+	})
+	return locks, nil
+}
 
-	return timestamp
+// LocksMenu lists all files that are currently locked by running instances of the editor.
+// Selecting a lock removes it, after asking, since the editor that holds it is still running.
+// Returns a status message (possibly empty).
+func (e *Editor) LocksMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, lk *LockKeeper) string {
+	locks, err := lk.Locks()
+	if err != nil {
+		return err.Error()
+	}
+	if len(locks) == 0 {
+		return "No files are locked"
+	}
+	choices := make([]string, len(locks))
+	for i, lock := range locks {
+		choices[i] = lock.filename + " (" + lock.String() + ")"
+		if lock.Ours() {
+			choices[i] = lock.filename + " (this editor)"
+		}
+	}
+	selected := e.Menu(status, tty, "Select a lock to remove it", choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+	e.redraw = true
+	e.redrawCursor = true
+	if selected < 0 {
+		return ""
+	}
+	lock := locks[selected]
+	// Stale locks have already been removed by lk.Locks, so this lock is held by a running editor
+	holder := "the editor with " + lock.String()
+	if lock.Ours() {
+		holder = "this editor"
+	}
+	confirmChoices := []string{
+		"Keep the lock",
+		"Remove the lock, even if the file can then be edited by two editors at the same time",
+	}
+	if e.Menu(status, tty, filepath.Base(lock.filename)+" is being edited by "+holder, confirmChoices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false) != 1 {
+		return "Kept the lock for " + lock.filename
+	}
+	// Only remove the lock file if it still holds the same lock
+	takeOver(lk.lockFilename(lock.filename), lock)
+	if current, err := readLock(lk.lockFilename(lock.filename)); err == nil && !current.Same(lock) {
+		return lock.filename + " has been locked again by " + current.String()
+	}
+	return "Removed the lock for " + lock.filename
 }
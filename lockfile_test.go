@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockKeeper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lk, err := NewLockKeeper(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const filename = "/tmp/some/file.txt"
+	if err := lk.Lock(filename); err != nil {
+		t.Fatal(err)
+	}
+	// Locking a file that is already locked by this process is fine
+	if err := lk.Lock(filename); err != nil {
+		t.Error(err)
+	}
+	if err := lk.Unlock(filename); err != nil {
+		t.Error(err)
+	}
+
+	// A lock held by a running process
+	writeLock := func(pid int) {
+		data := fmt.Sprintf("%d\n%s\n%s\n%s\n", pid, hostname(), time.Now().Format(time.RFC3339), filename)
+		if err := ioutil.WriteFile(lk.lockFilename(filename), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeLock(1)
+	if _, ok := lk.Lock(filename).(*LockedError); !ok {
+		t.Error("expected the file to be locked by PID 1")
+	}
+	if err := lk.Unlock(filename); err == nil {
+		t.Error("the lock held by PID 1 should not be removed by Unlock")
+	}
+	if locks, err := lk.Locks(); err != nil || len(locks) != 1 || locks[0].filename != filename {
+		t.Errorf("expected one lock for %s, got %v (%v)", filename, locks, err)
+	}
+
+	// A lock held by a process that has exited is stale, and should be taken over
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("could not run true")
+	}
+	writeLock(cmd.Process.Pid)
+	if err := lk.Lock(filename); err != nil {
+		t.Errorf("expected the stale lock to be removed, got: %v", err)
+	}
+	if lock, err := readLock(lk.lockFilename(filename)); err != nil || !lock.Ours() {
+		t.Error("expected the lock to be held by this process")
+	}
+	lk.Unlock(filename)
+
+	// A stale lock that has been replaced by a fresh lock from another instance is not taken over
+	writeLock(cmd.Process.Pid)
+	stale, err := readLock(lk.lockFilename(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLock(1)
+	takeOver(lk.lockFilename(filename), stale)
+	if lock, err := readLock(lk.lockFilename(filename)); err != nil || lock.pid != 1 {
+		t.Errorf("expected the fresh lock held by PID 1 to be kept, got %v (%v)", lock, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.stale")); len(leftovers) != 0 {
+		t.Errorf("expected no renamed lock files to be left behind, got %v", leftovers)
+	}
+}
@@ -0,0 +1,855 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+
+	"github.com/xyproto/env"
+	"github.com/xyproto/vt100"
+)
+
+const (
+	// How long to wait for the language server to start up
+	lspStartTimeout = 10 * time.Second
+
+	// How long to wait for a reply to a request, like completion or hover
+	lspRequestTimeout = 3 * time.Second
+
+	// How long to wait after the last keypress before sending the changes to the language server
+	lspSyncDelay = 300 * time.Millisecond
+)
+
+var (
+	// Which language server to use: "auto" for the default one for the current mode, "off", or a command
+	lspSetting = env.Str("O_LSP", "auto")
+
+	// The colors of the diagnostics that are shown after the lines
+	lspErrorColor   = vt100.LightRed
+	lspWarningColor = vt100.Yellow
+
+	errLSPNotRunning = errors.New("no language server is running for this file")
+)
+
+// lspServer is a language server command and the LSP language identifier it handles
+type lspServer struct {
+	command    []string
+	languageID string
+}
+
+// The default language servers for each mode, used if they are installed
+var lspServers = map[Mode]lspServer{
+	modeGo:      {[]string{"gopls"}, "go"},
+	modeC:       {[]string{"clangd"}, "c"},
+	modeCpp:     {[]string{"clangd"}, "cpp"},
+	modeRust:    {[]string{"rust-analyzer"}, "rust"},
+	modePython:  {[]string{"pylsp"}, "python"},
+	modeZig:     {[]string{"zls"}, "zig"},
+	modeHaskell: {[]string{"haskell-language-server-wrapper", "--lsp"}, "haskell"},
+	modeLua:     {[]string{"lua-language-server"}, "lua"},
+	modeCrystal: {[]string{"crystalline"}, "crystal"},
+	modeOdin:    {[]string{"ols"}, "odin"},
+}
+
+// The language servers that are running, per mode
+var (
+	lspClients    = make(map[Mode]*LSPClient)
+	lspClientsMut = &sync.RWMutex{}
+
+	// Signalled when new diagnostics arrive, for redrawing the editor.
+	// The reader goroutines must never wait for the editor, so a signal is dropped if one is already pending.
+	lspDiagnosticsArrived = make(chan struct{}, 1)
+)
+
+// lspPosition is a position in a text document, where character counts UTF-16 code units
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// LSPLocation is a range in a file, as returned by definition and references requests
+type LSPLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+
+	// For when the server replies with LocationLink instead of Location
+	TargetURI            string   `json:"targetUri,omitempty"`
+	TargetSelectionRange lspRange `json:"targetSelectionRange,omitempty"`
+}
+
+// LSPDiagnostic is an error or warning reported by the language server
+type LSPDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"` // 1 is error, 2 is warning, 3 is information and 4 is hint
+	Source   string   `json:"source,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// LSPCompletionItem is a completion suggestion from the language server
+type LSPCompletionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insertText,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// lspMessage is a JSON-RPC request, response or notification
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *lspError       `json:"error,omitempty"`
+}
+
+// LSPClient talks to a language server over stdin and stdout
+type LSPClient struct {
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	writeMut    *sync.Mutex
+	nextID      int64
+	pending     map[int64]chan lspMessage
+	pendingMut  *sync.Mutex
+	diagnostics map[string][]LSPDiagnostic // per URI
+	documents   map[string]string          // the last text sent to the server, per URI
+	versions    map[string]int             // per URI
+	docMut      *sync.RWMutex
+	rootDir     string
+	ready       chan struct{} // closed when the server has been initialized
+	done        chan struct{} // closed when the server has stopped
+}
+
+// lspURI converts an absolute filename to a file:// URI
+func lspURI(absFilename string) string {
+	return (&url.URL{Scheme: "file", Path: absFilename}).String()
+}
+
+// lspFilename converts a file:// URI to an absolute filename
+func lspFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return strings.TrimPrefix(uri, "file://")
+	}
+	return u.Path
+}
+
+// lspCharacter converts a rune index in the given line to a number of UTF-16 code units
+func lspCharacter(line []rune, runeIndex int) int {
+	if runeIndex > len(line) {
+		runeIndex = len(line)
+	}
+	return len(utf16.Encode(line[:runeIndex]))
+}
+
+// lspRuneIndex converts a number of UTF-16 code units in the given line to a rune index
+func lspRuneIndex(line []rune, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
+
+// lspRootDir finds the project directory for the given file, by looking for
+// version control directories or project files in the directory of the file and upwards
+func lspRootDir(absFilename string) string {
+	start := filepath.Dir(absFilename)
+	for dir := start; ; dir = filepath.Dir(dir) {
+		for _, marker := range []string{"go.mod", "Cargo.toml", "compile_commands.json", "build.zig", ".git"} {
+			if exists(filepath.Join(dir, marker)) {
+				return dir
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return start
+		}
+	}
+}
+
+// writeLSPMessage writes a JSON-RPC message, with a Content-Length header
+func writeLSPMessage(w io.Writer, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readLSPMessage reads a JSON-RPC message, with a Content-Length header
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	contentLength := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if fields := strings.SplitN(line, ":", 2); len(fields) == 2 && strings.EqualFold(fields[0], "Content-Length") {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(fields[1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	data := make([]byte, contentLength)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// StartLSPClient starts the given language server command and begins reading its replies.
+// The server must be initialized with Initialize before use.
+func StartLSPClient(cmd *exec.Cmd, rootDir string) (*LSPClient, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// The language server may be chatty on stderr, and that should not end up in the terminal
+	cmd.Stderr = nil
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	lc := &LSPClient{
+		cmd:         cmd,
+		stdin:       stdin,
+		writeMut:    &sync.Mutex{},
+		pending:     make(map[int64]chan lspMessage),
+		pendingMut:  &sync.Mutex{},
+		diagnostics: make(map[string][]LSPDiagnostic),
+		documents:   make(map[string]string),
+		versions:    make(map[string]int),
+		docMut:      &sync.RWMutex{},
+		rootDir:     rootDir,
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go lc.readLoop(bufio.NewReader(stdout))
+	return lc, nil
+}
+
+// readLoop handles replies, notifications and requests from the language server, until it stops
+func (lc *LSPClient) readLoop(r *bufio.Reader) {
+	defer close(lc.done)
+	for {
+		data, err := readLSPMessage(r)
+		if err != nil {
+			return
+		}
+		var msg lspMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch {
+		case msg.Method == "textDocument/publishDiagnostics":
+			var params struct {
+				URI         string          `json:"uri"`
+				Diagnostics []LSPDiagnostic `json:"diagnostics"`
+			}
+			if json.Unmarshal(msg.Params, &params) == nil {
+				lc.docMut.Lock()
+				lc.diagnostics[params.URI] = params.Diagnostics
+				lc.docMut.Unlock()
+				select {
+				case lspDiagnosticsArrived <- struct{}{}:
+				default:
+				}
+			}
+		case msg.Method != "" && len(msg.ID) > 0:
+			// A request from the server, like workspace/configuration. Reply with empty results.
+			var result interface{}
+			var params struct {
+				Items []interface{} `json:"items"`
+			}
+			if msg.Method == "workspace/configuration" && json.Unmarshal(msg.Params, &params) == nil {
+				result = make([]interface{}, len(params.Items))
+			}
+			lc.write(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+		case msg.Method == "" && len(msg.ID) > 0:
+			// A reply to one of our requests
+			id, err := strconv.ParseInt(string(msg.ID), 10, 64)
+			if err != nil {
+				continue
+			}
+			lc.pendingMut.Lock()
+			ch, found := lc.pending[id]
+			delete(lc.pending, id)
+			lc.pendingMut.Unlock()
+			if found {
+				ch <- msg
+			}
+		}
+	}
+}
+
+// write sends a message to the language server
+func (lc *LSPClient) write(msg interface{}) error {
+	lc.writeMut.Lock()
+	defer lc.writeMut.Unlock()
+	return writeLSPMessage(lc.stdin, msg)
+}
+
+// notify sends a notification to the language server
+func (lc *LSPClient) notify(method string, params interface{}) error {
+	return lc.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// request sends a request to the language server and waits for the reply, which is stored in result
+func (lc *LSPClient) request(method string, params, result interface{}, timeout time.Duration) error {
+	id := atomic.AddInt64(&lc.nextID, 1)
+	ch := make(chan lspMessage, 1)
+	lc.pendingMut.Lock()
+	lc.pending[id] = ch
+	lc.pendingMut.Unlock()
+	defer func() {
+		lc.pendingMut.Lock()
+		delete(lc.pending, id)
+		lc.pendingMut.Unlock()
+	}()
+	if err := lc.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		return err
+	}
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %s", method, msg.Error.Message)
+		}
+		if result == nil || len(msg.Result) == 0 || string(msg.Result) == "null" {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	case <-lc.done:
+		return errors.New("the language server has stopped")
+	case <-time.After(timeout):
+		return fmt.Errorf("%s: the language server did not reply in time", method)
+	}
+}
+
+// Initialize performs the initialize handshake with the language server
+func (lc *LSPClient) Initialize() error {
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   lspURI(lc.rootDir),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":    map[string]interface{}{"didSave": true},
+				"completion":         map[string]interface{}{"completionItem": map[string]interface{}{"snippetSupport": false}},
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext"}},
+				"definition":         map[string]interface{}{},
+				"references":         map[string]interface{}{},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+		},
+	}
+	if err := lc.request("initialize", params, nil, lspStartTimeout); err != nil {
+		return err
+	}
+	if err := lc.notify("initialized", map[string]interface{}{}); err != nil {
+		return err
+	}
+	close(lc.ready)
+	return nil
+}
+
+// Ready returns true if the language server has been initialized and is still running
+func (lc *LSPClient) Ready() bool {
+	select {
+	case <-lc.done:
+		return false
+	case <-lc.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// DidOpen tells the language server that a document has been opened
+func (lc *LSPClient) DidOpen(absFilename, languageID, text string) error {
+	uri := lspURI(absFilename)
+	lc.docMut.Lock()
+	lc.documents[uri] = text
+	lc.versions[uri] = 1
+	lc.docMut.Unlock()
+	return lc.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": languageID, "version": 1, "text": text},
+	})
+}
+
+// DidChange sends the new contents of a document to the language server, if it has changed
+func (lc *LSPClient) DidChange(absFilename, text string) error {
+	uri := lspURI(absFilename)
+	lc.docMut.Lock()
+	if lc.documents[uri] == text {
+		lc.docMut.Unlock()
+		return nil
+	}
+	lc.documents[uri] = text
+	lc.versions[uri]++
+	version := lc.versions[uri]
+	lc.docMut.Unlock()
+	return lc.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": version},
+		"contentChanges": []interface{}{map[string]interface{}{"text": text}},
+	})
+}
+
+// DidSave tells the language server that a document has been saved
+func (lc *LSPClient) DidSave(absFilename string) error {
+	return lc.notify("textDocument/didSave", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": lspURI(absFilename)},
+	})
+}
+
+// DidClose tells the language server that a document is no longer being edited
+func (lc *LSPClient) DidClose(absFilename string) error {
+	uri := lspURI(absFilename)
+	lc.docMut.Lock()
+	delete(lc.documents, uri)
+	delete(lc.versions, uri)
+	delete(lc.diagnostics, uri)
+	lc.docMut.Unlock()
+	return lc.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+	})
+}
+
+// Diagnostics returns the latest diagnostics for the given file
+func (lc *LSPClient) Diagnostics(absFilename string) []LSPDiagnostic {
+	lc.docMut.RLock()
+	defer lc.docMut.RUnlock()
+	return lc.diagnostics[lspURI(absFilename)]
+}
+
+// textDocumentPosition returns the parameters for requests about a position in a document
+func textDocumentPosition(absFilename string, pos lspPosition) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": lspURI(absFilename)},
+		"position":     pos,
+	}
+}
+
+// Completion asks the language server for completions at the given position
+func (lc *LSPClient) Completion(absFilename string, pos lspPosition) ([]LSPCompletionItem, error) {
+	var raw json.RawMessage
+	if err := lc.request("textDocument/completion", textDocumentPosition(absFilename, pos), &raw, lspRequestTimeout); err != nil {
+		return nil, err
+	}
+	// The reply is either a CompletionList or a list of CompletionItems
+	var list struct {
+		Items []LSPCompletionItem `json:"items"`
+	}
+	if json.Unmarshal(raw, &list) == nil {
+		return list.Items, nil
+	}
+	var items []LSPCompletionItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Hover asks the language server for information about the symbol at the given position
+func (lc *LSPClient) Hover(absFilename string, pos lspPosition) (string, error) {
+	var hover struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := lc.request("textDocument/hover", textDocumentPosition(absFilename, pos), &hover, lspRequestTimeout); err != nil {
+		return "", err
+	}
+	return hoverText(hover.Contents), nil
+}
+
+// hoverText extracts the text from the contents of a hover reply, which is either
+// a string, a MarkupContent, a MarkedString or a list of strings or MarkedStrings
+func hoverText(contents json.RawMessage) string {
+	var s string
+	if json.Unmarshal(contents, &s) == nil {
+		return s
+	}
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(contents, &markup) == nil && markup.Value != "" {
+		return markup.Value
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		texts := make([]string, 0, len(list))
+		for _, item := range list {
+			if text := hoverText(item); text != "" {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// locations asks the language server for a list of locations, and handles
+// replies that are a single Location, a list of Locations or a list of LocationLinks
+func (lc *LSPClient) locations(method string, params interface{}) ([]LSPLocation, error) {
+	var raw json.RawMessage
+	if err := lc.request(method, params, &raw, lspRequestTimeout); err != nil {
+		return nil, err
+	}
+	var locations []LSPLocation
+	if len(raw) > 0 && raw[0] == '{' {
+		var location LSPLocation
+		if err := json.Unmarshal(raw, &location); err != nil {
+			return nil, err
+		}
+		locations = []LSPLocation{location}
+	} else if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &locations); err != nil {
+			return nil, err
+		}
+	}
+	for i, location := range locations {
+		if location.URI == "" && location.TargetURI != "" {
+			locations[i].URI = location.TargetURI
+			locations[i].Range = location.TargetSelectionRange
+		}
+	}
+	return locations, nil
+}
+
+// Definition asks the language server where the symbol at the given position is defined
+func (lc *LSPClient) Definition(absFilename string, pos lspPosition) ([]LSPLocation, error) {
+	return lc.locations("textDocument/definition", textDocumentPosition(absFilename, pos))
+}
+
+// References asks the language server where the symbol at the given position is used
+func (lc *LSPClient) References(absFilename string, pos lspPosition) ([]LSPLocation, error) {
+	params := textDocumentPosition(absFilename, pos)
+	params["context"] = map[string]interface{}{"includeDeclaration": true}
+	return lc.locations("textDocument/references", params)
+}
+
+// Shutdown asks the language server to shut down and exit, and kills it if it does not
+func (lc *LSPClient) Shutdown() {
+	if lc.Ready() {
+		lc.request("shutdown", nil, nil, lspRequestTimeout)
+		lc.notify("exit", nil)
+	}
+	lc.stdin.Close()
+	select {
+	case <-lc.done:
+	case <-time.After(lspRequestTimeout):
+		lc.cmd.Process.Kill()
+	}
+	lc.cmd.Wait()
+}
+
+// lspServerFor returns the language server command for the given mode, if it is configured and installed
+func lspServerFor(mode Mode) (lspServer, bool) {
+	server, found := lspServers[mode]
+	switch strings.ToLower(lspSetting) {
+	case "off", "none", "0", "false":
+		return server, false
+	case "auto", "":
+	default:
+		// A language server command was given with O_LSP
+		server.command = strings.Fields(lspSetting)
+		found = true
+	}
+	if !found || len(server.command) == 0 || which(server.command[0]) == "" {
+		return server, false
+	}
+	return server, true
+}
+
+// LSP returns the language server client for the current mode, or nil if none is ready
+func (e *Editor) LSP() *LSPClient {
+	lspClientsMut.RLock()
+	lc := lspClients[e.mode]
+	lspClientsMut.RUnlock()
+	if lc == nil || !lc.Ready() {
+		return nil
+	}
+	return lc
+}
+
+// StartLSP starts a language server for the current mode in the background, if one is installed,
+// and opens the current file with it. If one is already running for this mode, it is reused.
+func (e *Editor) StartLSP() {
+	server, ok := lspServerFor(e.mode)
+	if !ok {
+		return
+	}
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return
+	}
+	text := e.String()
+	lspClientsMut.Lock()
+	defer lspClientsMut.Unlock()
+	if lc, found := lspClients[e.mode]; found {
+		go func() {
+			select {
+			case <-lc.ready:
+				lc.DidOpen(absFilename, server.languageID, text)
+			case <-lc.done:
+			}
+		}()
+		return
+	}
+	lc, err := StartLSPClient(exec.Command(server.command[0], server.command[1:]...), lspRootDir(absFilename))
+	if err != nil {
+		return
+	}
+	lspClients[e.mode] = lc
+	go func() {
+		if err := lc.Initialize(); err != nil {
+			lc.Shutdown()
+			lspClientsMut.Lock()
+			delete(lspClients, e.mode)
+			lspClientsMut.Unlock()
+			return
+		}
+		lc.DidOpen(absFilename, server.languageID, text)
+	}()
+}
+
+// WatchLSPDiagnostics starts a goroutine that redraws the editor when new diagnostics arrive,
+// until quit is closed. The given lock is held while drawing, so that the editor is only redrawn
+// while the main loop is waiting for a keypress.
+func WatchLSPDiagnostics(c *vt100.Canvas, e *Editor, lock sync.Locker, quit chan bool) {
+	go func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case <-lspDiagnosticsArrived:
+			}
+			lock.Lock()
+			e.DrawLines(c, true, false)
+			vt100.SetXY(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()))
+			lock.Unlock()
+		}
+	}()
+}
+
+// StopLSP shuts down all running language servers
+func StopLSP() {
+	lspClientsMut.Lock()
+	defer lspClientsMut.Unlock()
+	for mode, lc := range lspClients {
+		lc.Shutdown()
+		delete(lspClients, mode)
+	}
+}
+
+// LSPSync sends the current contents of the editor to the language server, if it has changed
+func (e *Editor) LSPSync() {
+	lc := e.LSP()
+	if lc == nil {
+		return
+	}
+	if absFilename, err := e.AbsFilename(); err == nil {
+		lc.DidChange(absFilename, e.String())
+	}
+}
+
+// LSPDidSave tells the language server that the current file has been saved
+func (e *Editor) LSPDidSave() {
+	lc := e.LSP()
+	if lc == nil {
+		return
+	}
+	if absFilename, err := e.AbsFilename(); err == nil {
+		lc.DidChange(absFilename, e.String())
+		lc.DidSave(absFilename)
+	}
+}
+
+// LSPClose tells the language server that the current file is no longer being edited
+func (e *Editor) LSPClose() {
+	lc := e.LSP()
+	if lc == nil {
+		return
+	}
+	if absFilename, err := e.AbsFilename(); err == nil {
+		lc.DidClose(absFilename)
+	}
+}
+
+// LSPDiagnostics returns the most severe diagnostic for each line of the current file
+func (e *Editor) LSPDiagnostics() map[LineIndex]LSPDiagnostic {
+	lc := e.LSP()
+	if lc == nil {
+		return nil
+	}
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return nil
+	}
+	diagnostics := make(map[LineIndex]LSPDiagnostic)
+	for _, d := range lc.Diagnostics(absFilename) {
+		y := LineIndex(d.Range.Start.Line)
+		// A lower severity number is more severe, and 0 means that it is not given
+		if prev, found := diagnostics[y]; !found || (d.Severity != 0 && (prev.Severity == 0 || d.Severity < prev.Severity)) {
+			diagnostics[y] = d
+		}
+	}
+	return diagnostics
+}
+
+// lspPosition returns the current cursor position, as an LSP position
+func (e *Editor) lspPosition() lspPosition {
+	y := e.DataY()
+	x, err := e.DataX()
+	if err != nil {
+		x = len(e.lines[int(y)])
+	}
+	return lspPosition{int(y), lspCharacter(e.lines[int(y)], x)}
+}
+
+// lspRequestSetup syncs the current contents and returns the client and absolute filename
+func (e *Editor) lspRequestSetup() (*LSPClient, string, error) {
+	lc := e.LSP()
+	if lc == nil {
+		return nil, "", errLSPNotRunning
+	}
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return nil, "", err
+	}
+	lc.DidChange(absFilename, e.String())
+	return lc, absFilename, nil
+}
+
+// LSPCompletion returns the completions the language server suggests at the cursor
+func (e *Editor) LSPCompletion() ([]LSPCompletionItem, error) {
+	lc, absFilename, err := e.lspRequestSetup()
+	if err != nil {
+		return nil, err
+	}
+	return lc.Completion(absFilename, e.lspPosition())
+}
+
+// LSPHover returns information about the symbol under the cursor
+func (e *Editor) LSPHover() (string, error) {
+	lc, absFilename, err := e.lspRequestSetup()
+	if err != nil {
+		return "", err
+	}
+	return lc.Hover(absFilename, e.lspPosition())
+}
+
+// goToLSPLocation jumps to the given location, switching files if needed
+func (e *Editor) goToLSPLocation(l LSPLocation, tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) error {
+	jumpList.Record(e.jumpAt(e.DataY()))
+	if err := e.jumpTo(Jump{lspFilename(l.URI), LineIndex(l.Range.Start.Line)}, tty, c, status, lk); err != nil {
+		return err
+	}
+	y := e.DataY()
+	runes := e.lines[int(y)]
+	x := lspRuneIndex(runes, l.Range.Start.Character)
+	tabs := strings.Count(string(runes[:x]), "\t")
+	e.pos.sx = x + (tabs * (e.tabs.spacesPerTab - 1))
+	e.HorizontalScrollIfNeeded(c)
+	return nil
+}
+
+// LSPLocationMenu lets the user select one of the given locations and jumps to it.
+// Returns the selected location and true, or false if the menu was cancelled.
+func (e *Editor) LSPLocationMenu(title string, locations []LSPLocation, tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) (LSPLocation, bool, error) {
+	selected := 0
+	if len(locations) > 1 {
+		wd, _ := os.Getwd()
+		choices := make([]string, len(locations))
+		for i, l := range locations {
+			filename := lspFilename(l.URI)
+			if rel, err := filepath.Rel(wd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+				filename = rel
+			}
+			choices[i] = fmt.Sprintf("%s:%d", filename, l.Range.Start.Line+1)
+		}
+		selected = e.Menu(status, tty, title, choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+		e.redraw = true
+		e.redrawCursor = true
+		if selected < 0 {
+			return LSPLocation{}, false, nil
+		}
+	}
+	return locations[selected], true, e.goToLSPLocation(locations[selected], tty, c, status, lk)
+}
+
+// LSPDefinition jumps to the definition of the symbol under the cursor. Returns a status message.
+func (e *Editor) LSPDefinition(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) (string, error) {
+	lc, absFilename, err := e.lspRequestSetup()
+	if err != nil {
+		return "", err
+	}
+	name := e.IdentifierAtCursor()
+	locations, err := lc.Definition(absFilename, e.lspPosition())
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return "", fmt.Errorf("could not find the definition of %s", name)
+	}
+	if _, ok, err := e.LSPLocationMenu("Definitions of "+name, locations, tty, c, status, lk); err != nil || !ok {
+		return "", err
+	}
+	return "Jumped to the definition of " + name, nil
+}
+
+// LSPReferences shows a menu of the places where the symbol under the cursor is used,
+// and jumps to the selected one. Returns a status message.
+func (e *Editor) LSPReferences(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) (string, error) {
+	lc, absFilename, err := e.lspRequestSetup()
+	if err != nil {
+		return "", err
+	}
+	name := e.IdentifierAtCursor()
+	locations, err := lc.References(absFilename, e.lspPosition())
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return "", fmt.Errorf("could not find any references to %s", name)
+	}
+	l, ok, err := e.LSPLocationMenu("References to "+name, locations, tty, c, status, lk)
+	if err != nil || !ok {
+		return "", err
+	}
+	return fmt.Sprintf("Jumped to a reference to %s at line %d", name, l.Range.Start.Line+1), nil
+}
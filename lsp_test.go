@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFakeLSPServer is not a real test. It is a small language server that is started
+// by TestLSPClient, by running the test binary with O_TEST_LSP_SERVER set.
+func TestFakeLSPServer(t *testing.T) {
+	if os.Getenv("O_TEST_LSP_SERVER") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	r := bufio.NewReader(os.Stdin)
+	reply := func(id json.RawMessage, result interface{}) {
+		writeLSPMessage(os.Stdout, map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result})
+	}
+	location := func(uri string, line int) map[string]interface{} {
+		pos := map[string]int{"line": line, "character": 5}
+		return map[string]interface{}{"uri": uri, "range": map[string]interface{}{"start": pos, "end": pos}}
+	}
+	for {
+		data, err := readLSPMessage(r)
+		if err != nil {
+			return
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		uri := params.TextDocument.URI
+		switch msg.Method {
+		case "initialize":
+			reply(msg.ID, map[string]interface{}{"capabilities": map[string]interface{}{}})
+		case "textDocument/didOpen":
+			// Report an error for the first line that contains "oops"
+			for i, line := range strings.Split(params.TextDocument.Text, "\n") {
+				if strings.Contains(line, "oops") {
+					pos := map[string]int{"line": i, "character": 0}
+					writeLSPMessage(os.Stdout, map[string]interface{}{
+						"jsonrpc": "2.0",
+						"method":  "textDocument/publishDiagnostics",
+						"params": map[string]interface{}{
+							"uri": uri,
+							"diagnostics": []interface{}{map[string]interface{}{
+								"range":    map[string]interface{}{"start": pos, "end": pos},
+								"severity": 1,
+								"message":  "undefined: oops",
+							}},
+						},
+					})
+					break
+				}
+			}
+		case "textDocument/completion":
+			reply(msg.ID, map[string]interface{}{"isIncomplete": false, "items": []interface{}{
+				map[string]interface{}{"label": "Println"},
+				map[string]interface{}{"label": "Printf"},
+			}})
+		case "textDocument/hover":
+			reply(msg.ID, map[string]interface{}{"contents": map[string]interface{}{"kind": "plaintext", "value": "func main()"}})
+		case "textDocument/definition":
+			reply(msg.ID, location(uri, 2))
+		case "textDocument/references":
+			reply(msg.ID, []interface{}{location(uri, 2), location(uri, 7)})
+		case "shutdown":
+			reply(msg.ID, nil)
+		case "exit":
+			return
+		}
+	}
+}
+
+func TestLSPClient(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestFakeLSPServer")
+	cmd.Env = append(os.Environ(), "O_TEST_LSP_SERVER=1")
+	lc, err := StartLSPClient(cmd, "/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Shutdown()
+
+	if err := lc.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if !lc.Ready() {
+		t.Fatal("the language server should be ready")
+	}
+
+	const filename = "/tmp/main.go"
+	if err := lc.DidOpen(filename, "go", "package main\n\nfunc main() {\n\toops\n}\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lspDiagnosticsArrived:
+	case <-time.After(lspRequestTimeout):
+		t.Fatal("no diagnostics from the language server")
+	}
+	if d := lc.Diagnostics(filename); len(d) != 1 || d[0].Range.Start.Line != 3 || d[0].Message != "undefined: oops" {
+		t.Errorf("unexpected diagnostics: %v", d)
+	}
+
+	items, err := lc.Completion(filename, lspPosition{3, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Label != "Println" {
+		t.Errorf("unexpected completion items: %v", items)
+	}
+
+	text, err := lc.Hover(filename, lspPosition{2, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "func main()" {
+		t.Errorf("unexpected hover text: %q", text)
+	}
+
+	locations, err := lc.Definition(filename, lspPosition{3, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 1 || lspFilename(locations[0].URI) != filename || locations[0].Range.Start.Line != 2 {
+		t.Errorf("unexpected definition: %v", locations)
+	}
+
+	locations, err = lc.References(filename, lspPosition{3, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 2 || locations[1].Range.Start.Line != 7 {
+		t.Errorf("unexpected references: %v", locations)
+	}
+}
+
+func TestLSPCharacter(t *testing.T) {
+	line := []rune("a😀b")
+	if n := lspCharacter(line, 2); n != 3 {
+		t.Errorf("expected 3 UTF-16 code units, got %d", n)
+	}
+	if i := lspRuneIndex(line, 3); i != 2 {
+		t.Errorf("expected rune index 2, got %d", i)
+	}
+}
@@ -70,13 +70,19 @@ ctrl-t     to render the current document to PDF
 ctrl-c     to copy the current line, press twice to copy the current block
 ctrl-v     to paste one line, press twice to paste the rest
 ctrl-x     to cut the current line, press twice to cut the current block
-ctrl-b     to toggle a bookmark for the current line, or jump to a bookmark
+ctrl-_     right after pasting, replace the pasted text with older cuts and copies
+           otherwise, select text to paste from the clipboard history
+ctrl-b     to toggle a bookmark for the current line, or select a bookmark
 ctrl-j     to join lines
 ctrl-u     to undo (ctrl-z is also possible, but may background the application)
-ctrl-l     to jump to a specific line (press return to jump to the top or bottom)
+ctrl-l     to jump to a specific line (press return to jump to the top or bottom,
+           the left or right arrow to go back or forward to earlier jumps,
+           or the down or up arrow to go to the next or previous build error)
 ctrl-f     to find a string
 ctrl-\     to toggle single-line comments for a block of code
 ctrl-~     to jump to matching parenthesis
+ctrl-]     to jump to the definition of the identifier under the cursor
+           (needs a language server or a tags file)
 esc        to redraw the screen and clear the last search
 
 See the man page for more information.
@@ -202,3 +202,34 @@ func (e *Editor) Menu(status *StatusBar, tty *vt100.TTY, title string, choices [
 
 	return menu.Selected()
 }
+
+// UserInput asks the user to type in a line of text in the status bar.
+// Returns the text and true, or false if esc or ctrl-q was pressed.
+func (e *Editor) UserInput(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, prompt string) (string, bool) {
+	status.ClearAll(c)
+	status.SetMessage(prompt + " ")
+	status.ShowNoTimeout(c, e)
+	var entered []rune
+	for {
+		key := tty.String()
+		switch key {
+		case "c:8", "c:127": // ctrl-h or backspace
+			if len(entered) > 0 {
+				entered = entered[:len(entered)-1]
+			}
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return "", false
+		case "c:13": // return
+			status.ClearAll(c)
+			return string(entered), true
+		case "←", "→", "↑", "↓": // arrow keys
+		default:
+			if runes := []rune(key); len(runes) == 1 && unicode.IsPrint(runes[0]) {
+				entered = append(entered, runes[0])
+			}
+		}
+		status.SetMessage(prompt + " " + string(entered))
+		status.ShowNoTimeout(c, e)
+	}
+}
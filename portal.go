@@ -3,27 +3,47 @@ package main
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/xyproto/env"
+	"github.com/xyproto/vt100"
 )
 
+// In shared portal files, the text comes after this line
+const portalTextMarker = "---"
+
 var (
-	portalFilename = func() string {
-		tmpdir := env.Str("TMPDIR", "/tmp")
-		filename := env.Str("LOGNAME", "o") + "_portal.txt"
-		return filepath.Join(tmpdir, filename)
-	}()
+	portalDirectory = env.Str("TMPDIR", "/tmp")
+	portalUser      = env.Str("LOGNAME", "o")
+
+	// The default portal, which is opened with ctrl-r
+	portalFilename = filepath.Join(portalDirectory, portalUser+"_portal.txt")
+
+	// Portals that are shared with other local users are placed in this directory,
+	// which is created with the same permissions as /tmp, so that users can only remove their own portals.
+	sharedPortalDirectory = filepath.Join(portalDirectory, "o_shared_portals")
+
+	// If O_PORTAL_GROUP is set, shared portals can only be read by members of that group
+	sharedPortalGroup = env.Str("O_PORTAL_GROUP")
 )
 
-// Portal is a filename and a line number, for pulling text from
+// Portal is a filename and a range of lines, for pulling text from.
+// Shared portals also carry the text itself, since other users may not be able to read the file.
 type Portal struct {
+	name        string // blank for the default portal
 	absFilename string
-	lineNumber  LineNumber
+	lineNumber  LineNumber // the first line in the range
+	lineCount   int        // the number of lines in the range
+	owner       string     // the user that opened the portal, only set for shared portals
+	lines       []string   // the text, only set for shared portals
 }
 
 // NewPortal returns a new portal to this filename and line number,
@@ -33,31 +53,64 @@ func (e *Editor) NewPortal() (*Portal, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Portal{absFilename, e.LineNumber()}, nil
+	return &Portal{"", absFilename, e.LineNumber(), 1, "", nil}, nil
+}
+
+// namedPortalFilename returns the filename for a portal with the given name, for the current user
+func namedPortalFilename(name string) string {
+	if name == "" {
+		return portalFilename
+	}
+	return filepath.Join(portalDirectory, portalUser+"_portal_"+name+".txt")
+}
+
+// sharedPortalFilename returns the filename for a shared portal with the given name, for the current user
+func sharedPortalFilename(name string) string {
+	if name == "" {
+		name = "portal"
+	}
+	return filepath.Join(sharedPortalDirectory, portalUser+"_"+name+".txt")
 }
 
-// ClosePortal will clear the portal by removing the portal file
+// ClosePortal will clear the default portal by removing the portal file
 func ClosePortal() error {
 	return os.Remove(expandUser(portalFilename))
 }
 
-// HasPortal checks if a portal is currently active
+// CloseAllPortals removes the default portal, all named portals and all portals shared by the current user
+func CloseAllPortals() {
+	ClosePortal()
+	namedFilenames, _ := filepath.Glob(filepath.Join(portalDirectory, portalUser+"_portal_*.txt"))
+	sharedFilenames, _ := filepath.Glob(filepath.Join(sharedPortalDirectory, portalUser+"_*.txt"))
+	for _, filename := range append(namedFilenames, sharedFilenames...) {
+		os.Remove(filename)
+	}
+}
+
+// HasPortal checks if the default portal is currently active
 func HasPortal() bool {
 	return exists(expandUser(portalFilename))
 }
 
-// LoadPortal will load a filename + line number from the portal.txt file
+// LoadPortal will load the default portal
 func LoadPortal() (*Portal, error) {
-	data, err := ioutil.ReadFile(expandUser(portalFilename))
+	return loadPortalFile(expandUser(portalFilename), "")
+}
+
+// loadPortalFile will load a filename, line number and line count from the given portal file,
+// followed by the text, for shared portals. Portal files from older versions only have the
+// filename and line number.
+func loadPortalFile(filename, name string) (*Portal, error) {
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 	if !bytes.Contains(data, []byte{'\n'}) {
-		return nil, errors.New(portalFilename + " does not have a newline, it's not a portal file")
+		return nil, errors.New(filename + " does not have a newline, it's not a portal file")
 	}
 	lines := strings.Split(string(data), "\n")
 	if len(lines) < 2 {
-		return nil, errors.New(portalFilename + " contains too few lines")
+		return nil, errors.New(filename + " contains too few lines")
 	}
 	absFilename, err := filepath.Abs(lines[0])
 	if err != nil {
@@ -67,23 +120,214 @@ func LoadPortal() (*Portal, error) {
 	if err != nil {
 		return nil, err
 	}
-	lineNumber := LineNumber(lineInt)
-	return &Portal{absFilename, lineNumber}, nil
+	lineCount := 1
+	if len(lines) > 2 && lines[2] != "" {
+		if lineCount, err = strconv.Atoi(lines[2]); err != nil || lineCount < 1 {
+			return nil, errors.New(filename + " has an invalid line count")
+		}
+	}
+	p := &Portal{name, absFilename, LineNumber(lineInt), lineCount, "", nil}
+	if len(lines) > 4 && lines[3] == portalTextMarker {
+		p.lines = lines[4:]
+		if len(p.lines) > lineCount {
+			p.lines = p.lines[:lineCount]
+		}
+	}
+	return p, nil
+}
+
+// encode returns the contents of a portal file. The text is only included if withText is true.
+func (p *Portal) encode(withText bool) ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteString(p.absFilename + "\n" + p.lineNumber.String() + "\n" + strconv.Itoa(p.lineCount) + "\n")
+	if withText {
+		lines, err := p.Lines()
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(portalTextMarker + "\n" + strings.Join(lines, "\n"))
+	}
+	return []byte(sb.String()), nil
 }
 
-// Save will save the portal
+// Save will save the portal. Only the current user can read it.
 func (p *Portal) Save() error {
-	s := p.absFilename + "\n" + p.lineNumber.String() + "\n"
-	// Anyone can read this file
-	if err := ioutil.WriteFile(expandUser(portalFilename), []byte(s), 0600); err != nil {
+	data, err := p.encode(false)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(expandUser(namedPortalFilename(p.name)), data, 0600, -1, -1)
+}
+
+// SaveAs saves a copy of the portal with the given name
+func (p *Portal) SaveAs(name string) error {
+	p2 := *p
+	p2.name = name
+	return p2.Save()
+}
+
+// prepareSharedPortalDirectory creates the directory for shared portals, if needed.
+// Like /tmp, it is writable by everyone, but has the sticky bit set,
+// so that files can only be removed or renamed by the user that owns them.
+func prepareSharedPortalDirectory() error {
+	fileInfo, err := os.Lstat(sharedPortalDirectory)
+	if os.IsNotExist(err) {
+		if err := os.Mkdir(sharedPortalDirectory, 0700); err != nil {
+			return err
+		}
+		// Set the permissions explicitly, since Mkdir is affected by umask
+		return os.Chmod(sharedPortalDirectory, os.ModeSticky|0777)
+	} else if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() || fileInfo.Mode()&os.ModeSticky == 0 {
+		return errors.New(sharedPortalDirectory + " is not a directory with the sticky bit set, refusing to use it")
+	}
+	// The owner of the directory can remove and replace the files of other users, regardless of the sticky bit
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); !ok || (stat.Uid != 0 && int(stat.Uid) != os.Getuid()) {
+		return errors.New(sharedPortalDirectory + " is owned by " + fileOwnerName(fileInfo) + ", refusing to use it")
+	}
+	return nil
+}
+
+// Share saves a copy of the portal, including the text, to the directory for shared portals.
+// The copy can be read by all local users, or only by the members of the group in O_PORTAL_GROUP, if set.
+// Other users can paste from it, but not modify or remove it.
+func (p *Portal) Share() error {
+	if err := prepareSharedPortalDirectory(); err != nil {
+		return err
+	}
+	data, err := p.encode(true)
+	if err != nil {
+		return err
+	}
+	var (
+		perm os.FileMode = 0644
+		gid              = -1
+	)
+	if sharedPortalGroup != "" {
+		group, err := user.LookupGroup(sharedPortalGroup)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return err
+		}
+		perm = 0640
+	}
+	if err := writeFileAtomic(sharedPortalFilename(p.name), data, perm, -1, gid); err != nil {
 		return err
 	}
-	return os.Chmod(expandUser(portalFilename), 0666)
+	if gid != -1 {
+		// Make sure that the group was set, or else the portal would not be readable by the group
+		if _, _, fileGid, _ := fileModeAndOwner(sharedPortalFilename(p.name)); fileGid != gid {
+			os.Remove(sharedPortalFilename(p.name))
+			return errors.New("could not give the group " + sharedPortalGroup + " access to the shared portal")
+		}
+	}
+	return nil
+}
+
+// fileOwnerName returns the name of the user that owns the given file
+func fileOwnerName(fileInfo os.FileInfo) string {
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		uid := strconv.Itoa(int(stat.Uid))
+		if u, err := user.LookupId(uid); err == nil { // success
+			return u.Username
+		}
+		return uid
+	}
+	return "?"
 }
 
-// String returns the current portal (filename + line number) as a colon separated string
+// Portals returns the named portals of the current user and all shared portals
+// that the current user is allowed to read, sorted by owner and name
+func Portals() []*Portal {
+	var portals []*Portal
+	namedFilenames, _ := filepath.Glob(filepath.Join(portalDirectory, portalUser+"_portal_*.txt"))
+	for _, filename := range namedFilenames {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(filename), portalUser+"_portal_"), ".txt")
+		if p, err := loadPortalFile(filename, name); err == nil { // success
+			portals = append(portals, p)
+		}
+	}
+	sharedFilenames, _ := filepath.Glob(filepath.Join(sharedPortalDirectory, "*.txt"))
+	for _, filename := range sharedFilenames {
+		fileInfo, err := os.Lstat(filename)
+		if err != nil || !fileInfo.Mode().IsRegular() {
+			continue
+		}
+		// The owner is the user that owns the file, not the user given in the filename
+		owner := fileOwnerName(fileInfo)
+		name := strings.TrimSuffix(filepath.Base(filename), ".txt")
+		if i := strings.Index(name, "_"); i >= 0 {
+			name = name[i+1:]
+		}
+		if p, err := loadPortalFile(filename, name); err == nil && p.lines != nil {
+			p.owner = owner
+			portals = append(portals, p)
+		}
+	}
+	sort.SliceStable(portals, func(i, j int) bool {
+		if portals[i].owner != portals[j].owner {
+			return portals[i].owner < portals[j].owner
+		}
+		return portals[i].name < portals[j].name
+	})
+	return portals
+}
+
+// String returns the current portal (filename + line number or range) as a colon separated string
 func (p *Portal) String() string {
-	return filepath.Base(p.absFilename) + ":" + p.lineNumber.String()
+	s := filepath.Base(p.absFilename) + ":" + p.lineNumber.String()
+	if p.lineCount > 1 {
+		s += "-" + (p.lineNumber + LineNumber(p.lineCount-1)).String()
+	}
+	return s
+}
+
+// Title returns a description of the portal, for use in menus
+func (p *Portal) Title() string {
+	s := p.String()
+	if p.name != "" && p.name != "portal" {
+		s = p.name + ": " + s
+	}
+	if p.owner != "" {
+		s += " (shared by " + p.owner + ")"
+	}
+	return s
+}
+
+// LastLineNumber returns the line number of the last line in the portal range
+func (p *Portal) LastLineNumber() LineNumber {
+	return p.lineNumber + LineNumber(p.lineCount-1)
+}
+
+// Extend makes the portal range end at the given line number, if it is after the first line
+func (p *Portal) Extend(lineNumber LineNumber) bool {
+	if lineNumber <= p.lineNumber {
+		return false
+	}
+	p.lineCount = int(lineNumber-p.lineNumber) + 1
+	return true
+}
+
+// Lines returns all lines in the portal range. Shared portals carry their own copy
+// of the text, for other portals the text is read from the file.
+func (p *Portal) Lines() ([]string, error) {
+	if p.lines != nil {
+		return p.lines, nil
+	}
+	data, err := ioutil.ReadFile(p.absFilename)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	first := int(p.lineNumber.LineIndex())
+	if first < 0 || first+p.lineCount > len(lines) {
+		return nil, errors.New("Could not find the lines at " + p.String())
+	}
+	return lines[first : first+p.lineCount], nil
 }
 
 // PopLine removes (!) a line from the portal file, then removes that line
@@ -132,3 +376,42 @@ func (p *Portal) PopLine(removeLine bool) (string, error) {
 	}
 	return foundLine, nil
 }
+
+// PasteLines inserts the given lines, untrimmed, starting at the current line if it is
+// empty, or below the current line if not. The cursor ends up on the last inserted line.
+func (e *Editor) PasteLines(c *vt100.Canvas, lines []string) {
+	for i, line := range lines {
+		if i > 0 || !e.EmptyRightTrimmedLine() {
+			e.InsertLineBelow()
+			e.Down(c, nil) // no status message if the end of document is reached, there should always be a new line
+		}
+		e.SetCurrentLine(line)
+	}
+	e.End(c)
+}
+
+// PortalsMenu lets the user pick one of the named or shared portals, and pastes all lines from it.
+// Returns a status message.
+func (e *Editor) PortalsMenu(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY) string {
+	portals := Portals()
+	if len(portals) == 0 {
+		return "There are no named or shared portals"
+	}
+	choices := make([]string, len(portals))
+	for i, p := range portals {
+		choices[i] = p.Title()
+	}
+	selected := e.Menu(status, tty, "Paste from a portal", choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+	e.redraw = true
+	e.redrawCursor = true
+	if selected < 0 {
+		return ""
+	}
+	lines, err := portals[selected].Lines()
+	if err != nil {
+		return err.Error()
+	}
+	undo.Snapshot(e)
+	e.PasteLines(c, lines)
+	return fmt.Sprintf("Pasted %d lines from %s", len(lines), portals[selected].Title())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPortalRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	textFilename := filepath.Join(dir, "text.txt")
+	if err := ioutil.WriteFile(textFilename, []byte("one\ntwo\nthree\nfour\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Portal files from older versions only have a filename and a line number
+	oldFilename := filepath.Join(dir, "old_portal.txt")
+	if err := ioutil.WriteFile(oldFilename, []byte(textFilename+"\n2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPortalFile(oldFilename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.lineCount != 1 || p.String() != "text.txt:2" {
+		t.Errorf("unexpected portal: %s (%d lines)", p, p.lineCount)
+	}
+
+	if p.Extend(1) {
+		t.Error("a portal can not be extended upwards")
+	}
+	if !p.Extend(3) || p.String() != "text.txt:2-3" {
+		t.Errorf("expected the portal to be extended to line 3, got %s", p)
+	}
+	lines, err := p.Lines()
+	if err != nil || strings.Join(lines, ",") != "two,three" {
+		t.Errorf("unexpected lines: %v (%v)", lines, err)
+	}
+
+	// A shared portal carries the text, so that the file does not have to be readable
+	data, err := p.encode(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedFilename := filepath.Join(dir, "shared.txt")
+	if err := ioutil.WriteFile(sharedFilename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(textFilename)
+	p, err = loadPortalFile(sharedFilename, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err = p.Lines()
+	if err != nil || strings.Join(lines, ",") != "two,three" {
+		t.Errorf("unexpected lines from the shared portal: %v (%v)", lines, err)
+	}
+}
+
+func TestPrepareSharedPortalDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { sharedPortalDirectory = s }(sharedPortalDirectory)
+	sharedPortalDirectory = filepath.Join(dir, "o_shared_portals")
+
+	if err := prepareSharedPortalDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo, err := os.Stat(sharedPortalDirectory); err != nil || fileInfo.Mode()&os.ModeSticky == 0 || fileInfo.Mode().Perm() != 0777 {
+		t.Errorf("expected a sticky directory that is writable by everyone, got %v (%v)", fileInfo.Mode(), err)
+	}
+	// An existing directory is fine, as long as it's owned by root or the current user
+	if err := prepareSharedPortalDirectory(); err != nil {
+		t.Error(err)
+	}
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of the directory requires root")
+	}
+	if err := os.Chown(sharedPortalDirectory, 12345, 12345); err != nil {
+		t.Skip(err)
+	}
+	if err := prepareSharedPortalDirectory(); err == nil {
+		t.Error("expected a directory owned by another user to be refused")
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/xyproto/vt100"
+)
+
+// projectConfigFilename is the name of the file at the root of a project that can declare
+// commands for building, running, testing and linting the project, like this:
+//
+//	build = make
+//	run = ./$exe
+//
+//	[go]
+//	test = go test -race ./...
+//
+// Commands in a section named after the mode, like [go], [c] or [rust], are used for files in that mode.
+// Commands before the first section are used for all modes.
+// Since the commands are run by the editor, the file is only used after the user has trusted it.
+const projectConfigFilename = ".o.conf"
+
+// The project configuration files that the user has trusted, with a hash of their contents
+const trustedProjectsFilename = "trusted_projects.txt" // in the cache directory
+
+// Files and directories that mark the root of a project, for version control systems and then for modules
+var (
+	vcsRootMarkers    = []string{".git", ".hg", ".svn", ".bzr", ".fossil"}
+	moduleRootMarkers = []string{"go.mod", "Cargo.toml", "build.zig", "package.json", "pyproject.toml", "CMakeLists.txt"}
+)
+
+// The kinds of commands that can be declared in the project configuration file
+const (
+	projectBuild = "build"
+	projectRun   = "run"
+	projectTest  = "test"
+	projectLint  = "lint"
+)
+
+var errNoProjectCommand = errors.New("no such command in " + projectConfigFilename)
+
+// defaultProjectCommands are used when there is no command of that kind in the project configuration file
+var defaultProjectCommands = map[Mode]map[string]string{
+	modeGo: {projectLint: "go vet -json ./..."},
+}
+
+// ProjectConfig is the configuration for a project
+type ProjectConfig struct {
+	root     string                       // the directory where the configuration file was found
+	commands map[string]map[string]string // commands per section, where "" is for all modes
+	trusted  bool                         // has the user trusted this version of the configuration file?
+}
+
+// parseProjectConfig parses the contents of a project configuration file
+func parseProjectConfig(root, text string) *ProjectConfig {
+	pc := &ProjectConfig{root: root, commands: make(map[string]map[string]string)}
+	section := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(fields[0])), strings.TrimSpace(fields[1])
+		if pc.commands[section] == nil {
+			pc.commands[section] = make(map[string]string)
+		}
+		pc.commands[section][key] = value
+	}
+	return pc
+}
+
+// projectRootDir returns the root directory of the version control repository that the given
+// directory is in, or else the root of the module it is in. Returns the given directory if neither is found.
+func projectRootDir(dir string) string {
+	for _, markers := range [][]string{vcsRootMarkers, moduleRootMarkers} {
+		for root := dir; ; root = filepath.Dir(root) {
+			for _, marker := range markers {
+				if exists(filepath.Join(root, marker)) {
+					return root
+				}
+			}
+			if filepath.Dir(root) == root {
+				break
+			}
+		}
+	}
+	return dir
+}
+
+// checkProjectConfigOwner returns an error if the given project configuration file is not owned
+// by the current user, or if it can be changed by other users
+func checkProjectConfigOwner(filename string) error {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return errors.New("ignoring " + filename + ", since it is not owned by you")
+	}
+	if fileInfo.Mode().Perm()&0022 != 0 {
+		return errors.New("ignoring " + filename + ", since it can be changed by other users")
+	}
+	return nil
+}
+
+// projectConfigHash returns a hash of the given project configuration file, for remembering that it is trusted
+func projectConfigHash(filename string, data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(append([]byte(filename+"\n"), data...)))
+}
+
+// projectConfigTrusted checks if the user has trusted the given project configuration file, with these contents
+func projectConfigTrusted(filename string, data []byte) bool {
+	trusted, err := ioutil.ReadFile(cachePath(trustedProjectsFilename))
+	if err != nil {
+		return false
+	}
+	hash := projectConfigHash(filename, data)
+	for _, line := range strings.Split(string(trusted), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustProjectConfig remembers that the user trusts the commands in the given project configuration file,
+// as long as the contents stay the same. Earlier versions of the same file are forgotten.
+func TrustProjectConfig(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	trustedFilename := cachePath(trustedProjectsFilename)
+	var lines []string
+	if trusted, err := ioutil.ReadFile(trustedFilename); err == nil { // success
+		for _, line := range strings.Split(string(trusted), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[1] != filename {
+				lines = append(lines, line)
+			}
+		}
+	}
+	lines = append(lines, projectConfigHash(filename, data)+" "+filename)
+	// Only the current user should be able to read and change which projects are trusted
+	if err := os.MkdirAll(filepath.Dir(trustedFilename), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(trustedFilename, []byte(strings.Join(lines, "\n")+"\n"), 0600, -1, -1)
+}
+
+// FindProjectConfig searches the given directory and the directories above, up to the root of the
+// project, for a project configuration file, and parses it. Files that are not owned by the current
+// user, or that can be changed by other users, are refused.
+func FindProjectConfig(dir string) (*ProjectConfig, string, error) {
+	root := projectRootDir(dir)
+	for {
+		filename := filepath.Join(dir, projectConfigFilename)
+		data, err := ioutil.ReadFile(filename)
+		if err == nil {
+			if err := checkProjectConfigOwner(filename); err != nil {
+				return nil, filename, err
+			}
+			pc := parseProjectConfig(dir, string(data))
+			pc.trusted = projectConfigTrusted(filename, data)
+			return pc, filename, nil
+		}
+		parent := filepath.Dir(dir)
+		if dir == root || parent == dir {
+			return nil, "", os.ErrNotExist
+		}
+		dir = parent
+	}
+}
+
+// Command returns the command of the given kind for the given mode, or "" if there is none
+func (pc *ProjectConfig) Command(kind string, mode Mode) string {
+	if command, found := pc.commands[mode.Name()][kind]; found {
+		return command
+	}
+	return pc.commands[""][kind]
+}
+
+// shellQuote quotes the given string for sh, if needed
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_./-+=:,") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// expandProjectCommand replaces $file, $dir, $name, $exe and $root in the given command.
+// Other variables are left for the shell.
+func expandProjectCommand(command, root, absFilename string) string {
+	dir := filepath.Dir(absFilename)
+	vars := map[string]string{
+		"file": absFilename,
+		"dir":  dir,
+		"name": strings.TrimSuffix(filepath.Base(absFilename), filepath.Ext(absFilename)),
+		"exe":  filepath.Base(dir),
+		"root": root,
+	}
+	return os.Expand(command, func(name string) string {
+		if value, found := vars[name]; found {
+			return shellQuote(value)
+		}
+		// Let the shell expand it
+		return "${" + name + "}"
+	})
+}
+
+// ProjectCommand returns the command of the given kind for the given file, from the project
+// configuration file or the defaults for the mode, ready to be run with sh in the root directory
+// of the project
+func (e *Editor) ProjectCommand(kind, filename string) (*exec.Cmd, error) {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	root, command := filepath.Dir(absFilename), ""
+	if pc, _, err := FindProjectConfig(root); err == nil && pc.trusted {
+		root, command = pc.root, pc.Command(kind, e.mode)
+	}
+	if command == "" {
+		command = defaultProjectCommands[e.mode][kind]
+	}
+	if command == "" {
+		return nil, errNoProjectCommand
+	}
+	cmd := exec.Command("sh", "-c", expandProjectCommand(command, root, absFilename))
+	cmd.Dir = root
+	return cmd, nil
+}
+
+// AskToTrustProjectConfig asks the user if the commands in the project configuration file for the
+// current file can be run, if there is one that has not been trusted yet. Changed files are asked
+// about again. Returns a status message (possibly empty).
+func (e *Editor) AskToTrustProjectConfig(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY) string {
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return ""
+	}
+	pc, configFilename, err := FindProjectConfig(filepath.Dir(absFilename))
+	if err != nil {
+		if configFilename != "" {
+			return err.Error()
+		}
+		return ""
+	}
+	if pc.trusted {
+		return ""
+	}
+	choices := []string{
+		"Trust the commands in " + configFilename,
+		"Ignore the project configuration for now",
+	}
+	title := "Found a new or changed " + projectConfigFilename + " file"
+	selected := e.Menu(status, tty, title, choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 1, false)
+	e.redraw = true
+	e.redrawCursor = true
+	if selected != 0 {
+		return "Ignoring the commands in " + configFilename
+	}
+	if err := TrustProjectConfig(configFilename); err != nil {
+		return err.Error()
+	}
+	return "Trusted the commands in " + configFilename
+}
+
+// RunProjectCommand runs the command of the given kind from the project configuration file
+// and returns the last line of the output. For tests and linters, the first problem is returned
+// as an error, and all of them can be stepped through afterwards.
+func (e *Editor) RunProjectCommand(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, kind string) (string, error) {
+	cmd, err := e.ProjectCommand(kind, e.filename)
+	if err != nil {
+		return "", err
+	}
+
+	// Save the command in a temporary file
+	saveCommand(cmd)
+
+	output, err := e.RunBuildCommand(c, tty, status, cmd, "Running the "+kind+" command")
+	if err == errBuildCancelled {
+		return "", errors.New("The " + kind + " command was " + err.Error())
+	}
+
+	// Collect the test failures and the findings of linters, so that they can be stepped through
+	if kind == projectTest || kind == projectLint {
+		quickfix.Set(ParseDiagnostics(string(output), cmd.Dir, e.mode))
+		if entries := quickfix.Entries(); len(entries) > 0 {
+			msg := entries[0].String()
+			if len(entries) > 1 {
+				msg = fmt.Sprintf("1/%d: %s", len(entries), msg)
+			}
+			return "", errors.New(msg)
+		}
+	}
+
+	lines := strings.Split(string(bytes.TrimSpace(output)), "\n")
+	lastLine := strings.TrimSpace(lines[len(lines)-1])
+	if err != nil {
+		if lastLine != "" {
+			return "", errors.New(lastLine)
+		}
+		return "", err
+	}
+	if lastLine == "" {
+		lastLine = "Success"
+	}
+	return lastLine, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectConfig(t *testing.T) {
+	pc := parseProjectConfig("/src/p", "# comment\nbuild = make\nrun = ./$exe\n\n[go]\nbuild = go build -race\n")
+	if cmd := pc.Command(projectBuild, modeGo); cmd != "go build -race" {
+		t.Errorf("expected the build command for Go, got %q", cmd)
+	}
+	if cmd := pc.Command(projectBuild, modeC); cmd != "make" {
+		t.Errorf("expected the build command for all modes, got %q", cmd)
+	}
+	if cmd := pc.Command(projectRun, modeGo); cmd != "./$exe" {
+		t.Errorf("expected the run command for all modes, got %q", cmd)
+	}
+	if cmd := pc.Command(projectLint, modeGo); cmd != "" {
+		t.Errorf("expected no lint command, got %q", cmd)
+	}
+	if s := expandProjectCommand("cc $file -o ${exe} && ./$exe $HOME", "/src/p", "/src/p/my dir/main.c"); s != "cc '/src/p/my dir/main.c' -o 'my dir' && ./'my dir' ${HOME}" {
+		t.Errorf("unexpected expanded command: %q", s)
+	}
+}
+
+func TestProjectCommand(t *testing.T) {
+	root, err := ioutil.TempDir("", "o_project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(root, "cache"))
+
+	// The project is a git repository, in a directory that also has a configuration file
+	project := filepath.Join(root, "project")
+	dir := filepath.Join(project, "sub")
+	os.MkdirAll(dir, 0755)
+	os.Mkdir(filepath.Join(project, ".git"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(root, projectConfigFilename), []byte("build = echo outside\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e := NewSimpleEditor(80)
+	if _, err := e.ProjectCommand(projectBuild, filepath.Join(dir, "hello.txt")); err != errNoProjectCommand {
+		t.Errorf("expected the configuration file above the project root to be ignored, got %v", err)
+	}
+
+	configFilename := filepath.Join(project, projectConfigFilename)
+	if err := ioutil.WriteFile(configFilename, []byte("build = echo $name\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The configuration file is only used after it has been trusted
+	if _, err := e.ProjectCommand(projectBuild, filepath.Join(dir, "hello.txt")); err != errNoProjectCommand {
+		t.Errorf("expected the untrusted configuration file to be ignored, got %v", err)
+	}
+	if err := TrustProjectConfig(configFilename); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := e.ProjectCommand(projectBuild, filepath.Join(dir, "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Dir != project {
+		t.Errorf("expected the command to run in %s, got %s", project, cmd.Dir)
+	}
+	output, err := cmd.Output()
+	if err != nil || string(output) != "hello\n" {
+		t.Errorf("unexpected output: %q %v", output, err)
+	}
+	if _, err := e.ProjectCommand(projectTest, filepath.Join(dir, "hello.txt")); err != errNoProjectCommand {
+		t.Errorf("expected errNoProjectCommand, got %v", err)
+	}
+
+	// A configuration file that can be changed by other users is refused
+	os.Chmod(configFilename, 0620)
+	if _, _, err := FindProjectConfig(dir); err == nil {
+		t.Error("expected a group-writable configuration file to be refused")
+	}
+	os.Chmod(configFilename, 0600)
+
+	// A changed configuration file must be trusted again
+	if err := ioutil.WriteFile(configFilename, []byte("build = echo changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if pc, _, err := FindProjectConfig(dir); err != nil || pc.trusted {
+		t.Errorf("expected the changed configuration file to not be trusted, got %v", err)
+	}
+}
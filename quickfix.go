@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xyproto/vt100"
+)
+
+// QuickfixEntry is a single error or warning from the output of a build
+type QuickfixEntry struct {
+	filename string // an absolute path
+	line     int    // 1-based, or 0 if not known
+	col      int    // 1-based, or 0 if not known
+	severity string // "error", "warning" or "note"
+	message  string
+}
+
+// String returns the entry as filename:line:col: severity: message, with a relative filename if possible
+func (qe QuickfixEntry) String() string {
+	if qe.filename == "" {
+		return qe.severity + ": " + qe.message
+	}
+	filename := qe.filename
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+			filename = rel
+		}
+	}
+	location := filename
+	if qe.line > 0 {
+		location += ":" + strconv.Itoa(qe.line)
+		if qe.col > 0 {
+			location += ":" + strconv.Itoa(qe.col)
+		}
+	}
+	return location + ": " + qe.severity + ": " + qe.message
+}
+
+// Quickfix is the list of errors and warnings from the last build, and the current position in that list
+type Quickfix struct {
+	entries []QuickfixEntry
+	pos     int
+	mut     *sync.RWMutex
+}
+
+// quickfix is the list of errors and warnings from the last build
+var quickfix = NewQuickfix()
+
+// NewQuickfix creates a new and empty quickfix list
+func NewQuickfix() *Quickfix {
+	return &Quickfix{pos: -1, mut: &sync.RWMutex{}}
+}
+
+// Set replaces the entries in the quickfix list and moves to the start of the list.
+// Entries without a filename are left out, since there is nowhere to go.
+func (q *Quickfix) Set(entries []QuickfixEntry) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	q.entries = nil
+	for _, entry := range entries {
+		if entry.filename != "" {
+			q.entries = append(q.entries, entry)
+		}
+	}
+	q.pos = -1
+}
+
+// Index returns the index of the given entry, or -1
+func (q *Quickfix) Index(entry QuickfixEntry) int {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+	for i, qe := range q.entries {
+		if qe == entry {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pos returns the index of the current entry, or -1 if no entry has been selected yet
+func (q *Quickfix) Pos() int {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+	return q.pos
+}
+
+// Len returns the number of entries in the quickfix list
+func (q *Quickfix) Len() int {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+	return len(q.entries)
+}
+
+// Entries returns a copy of the entries
+func (q *Quickfix) Entries() []QuickfixEntry {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+	return append([]QuickfixEntry{}, q.entries...)
+}
+
+// Select moves to the entry with the given index and returns it
+func (q *Quickfix) Select(index int) (QuickfixEntry, bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if index < 0 || index >= len(q.entries) {
+		return QuickfixEntry{}, false
+	}
+	q.pos = index
+	return q.entries[index], true
+}
+
+// Next moves to the next entry, wrapping around at the end, and returns it
+func (q *Quickfix) Next() (QuickfixEntry, bool) {
+	q.mut.RLock()
+	next, l := q.pos+1, len(q.entries)
+	q.mut.RUnlock()
+	if l == 0 {
+		return QuickfixEntry{}, false
+	}
+	return q.Select(next % l)
+}
+
+// Prev moves to the previous entry, wrapping around at the start, and returns it
+func (q *Quickfix) Prev() (QuickfixEntry, bool) {
+	q.mut.RLock()
+	prev, l := q.pos-1, len(q.entries)
+	q.mut.RUnlock()
+	if l == 0 {
+		return QuickfixEntry{}, false
+	}
+	if prev < 0 {
+		prev = l - 1
+	}
+	return q.Select(prev)
+}
+
+// Status returns a status message for the current entry, like "error 2/7: undefined: x"
+func (q *Quickfix) Status() string {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+	if q.pos < 0 || q.pos >= len(q.entries) {
+		return ""
+	}
+	entry := q.entries[q.pos]
+	msg := fmt.Sprintf("%s %d/%d: %s", entry.severity, q.pos+1, len(q.entries), entry.message)
+	if entry.line > 0 {
+		msg = fmt.Sprintf("%s %d/%d: %s:%d: %s", entry.severity, q.pos+1, len(q.entries), filepath.Base(entry.filename), entry.line, entry.message)
+	}
+	return msg
+}
+
+// goToQuickfixEntry opens the file of the given entry, if needed, and moves to the line and column
+func (e *Editor) goToQuickfixEntry(entry QuickfixEntry, tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) error {
+	jumpList.Record(e.jumpAt(e.DataY()))
+	index := LineIndex(0)
+	if entry.line > 0 {
+		index = LineNumber(entry.line).LineIndex()
+	}
+	if err := e.jumpTo(Jump{entry.filename, index}, tty, c, status, lk); err != nil {
+		return err
+	}
+	if entry.col > 0 {
+		e.goToColumn(c, entry.col)
+	}
+	return nil
+}
+
+// goToColumn moves to the given 1-based column of the current line, where a tab counts as one column
+func (e *Editor) goToColumn(c *vt100.Canvas, col int) {
+	runes := e.lines[int(e.DataY())]
+	x := col - 1
+	if x > len(runes) {
+		x = len(runes)
+	}
+	tabs := strings.Count(string(runes[:x]), "\t")
+	e.pos.sx = x + (tabs * (e.tabs.spacesPerTab - 1))
+	e.HorizontalScrollIfNeeded(c)
+}
+
+// QuickfixNext goes to the next error or warning from the last build. Returns a status message.
+func (e *Editor) QuickfixNext(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) string {
+	entry, ok := quickfix.Next()
+	if !ok {
+		return "No build errors"
+	}
+	if err := e.goToQuickfixEntry(entry, tty, c, status, lk); err != nil {
+		return err.Error()
+	}
+	return quickfix.Status()
+}
+
+// QuickfixPrev goes to the previous error or warning from the last build. Returns a status message.
+func (e *Editor) QuickfixPrev(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) string {
+	entry, ok := quickfix.Prev()
+	if !ok {
+		return "No build errors"
+	}
+	if err := e.goToQuickfixEntry(entry, tty, c, status, lk); err != nil {
+		return err.Error()
+	}
+	return quickfix.Status()
+}
+
+// QuickfixMenu lists all errors and warnings from the last build, and goes to the selected one.
+// Returns a status message.
+func (e *Editor) QuickfixMenu(tty *vt100.TTY, c *vt100.Canvas, status *StatusBar, lk *LockKeeper) string {
+	entries := quickfix.Entries()
+	if len(entries) == 0 {
+		return "No build errors"
+	}
+	choices := make([]string, len(entries))
+	for i, entry := range entries {
+		choices[i] = entry.String()
+	}
+	selected := e.Menu(status, tty, "Build errors", choices, menuTitleColor, menuArrowColor, menuTextColor, menuHighlightColor, menuSelectedColor, 0, false)
+	e.redraw = true
+	e.redrawCursor = true
+	entry, ok := quickfix.Select(selected)
+	if !ok {
+		return ""
+	}
+	if err := e.goToQuickfixEntry(entry, tty, c, status, lk); err != nil {
+		return err.Error()
+	}
+	return quickfix.Status()
+}
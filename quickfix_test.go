@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestQuickfixNavigation(t *testing.T) {
+	q := NewQuickfix()
+	if _, ok := q.Next(); ok {
+		t.Error("an empty list should have no next entry")
+	}
+	q.Set([]QuickfixEntry{{"/a.go", 1, 0, "error", "one"}, {"/b.go", 2, 0, "error", "two"}})
+	if entry, _ := q.Next(); entry.message != "one" {
+		t.Errorf("expected the first entry, got %v", entry)
+	}
+	if entry, _ := q.Next(); entry.message != "two" || q.Status() != "error 2/2: b.go:2: two" {
+		t.Errorf("expected the second entry, got %v and %q", entry, q.Status())
+	}
+	if entry, _ := q.Next(); entry.message != "one" {
+		t.Errorf("expected to wrap around to the first entry, got %v", entry)
+	}
+	if entry, _ := q.Prev(); entry.message != "two" {
+		t.Errorf("expected to wrap around to the last entry, got %v", entry)
+	}
+}
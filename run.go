@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+var errNothingToRun = errors.New("found nothing to run, build it first")
+
+// isExecutable checks if the given path is a regular file that can be executed
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0
+}
+
+// goExecutableName returns the name that "go build" gives the executable for the package in the given directory
+func goExecutableName(dir string) string {
+	root, modulePath := goModule(dir)
+	if modulePath == "" {
+		return filepath.Base(dir)
+	}
+	importPath := modulePath
+	if rel, err := filepath.Rel(root, dir); err == nil && rel != "." {
+		importPath += "/" + filepath.ToSlash(rel)
+	}
+	elements := strings.Split(importPath, "/")
+	name := elements[len(elements)-1]
+	// A major version suffix, like "v2", is skipped
+	if len(elements) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elements[len(elements)-2]
+	}
+	return name
+}
+
+// RunCommand returns a command for running the program that was built from the given file, or for
+// running the file with an interpreter, together with a short description. A run command in the
+// project configuration file takes precedence. The command runs in the current directory, where
+// ctrl-space places the executables.
+func (e *Editor) RunCommand(filename string) (*exec.Cmd, string, error) {
+	if strings.HasSuffix(filename, "_test.go") {
+		return nil, "", errNothingToRun
+	}
+	if cmd, err := e.ProjectCommand(projectRun, filename); err == nil {
+		return cmd, cmd.Args[len(cmd.Args)-1], nil
+	}
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	curdir, err := os.Getwd()
+	if err != nil {
+		return nil, "", err
+	}
+	base := filepath.Base(absFilename)
+
+	var cmd *exec.Cmd
+	switch e.mode {
+	case modePython:
+		cmd = exec.Command("python", filename)
+	case modeLua:
+		cmd = exec.Command("lua", filename)
+	case modeShell:
+		cmd = exec.Command("sh", filename)
+	case modeRust:
+		// Let cargo find the executable, it is not rebuilt if it is up to date
+		root, found := findUpwards(filepath.Dir(absFilename), "Cargo.toml")
+		if !found {
+			return nil, "", errNothingToRun
+		}
+		cmd = exec.Command("cargo", "run", "--quiet")
+		cmd.Dir = root
+		return cmd, "cargo run", nil
+	case modeJava, modeKotlin, modeScala:
+		for _, jarFilename := range []string{filepath.Base(curdir) + ".jar", "main.jar"} {
+			if exists(jarFilename) {
+				cmd = exec.Command("java", "-jar", jarFilename)
+				break
+			}
+		}
+		if cmd == nil && exists("run_with_scala.jar") {
+			cmd = exec.Command("scala", "run_with_scala.jar")
+		}
+	case modeC, modeCpp, modeGo, modeZig, modeOdin, modeHaskell, modeOCaml, modeNim, modeCrystal, modeObjectPascal:
+		// Look for the executable that the build command has produced
+		candidates := []string{filepath.Base(curdir), strings.TrimSuffix(base, filepath.Ext(base)), "main"}
+		switch e.mode {
+		case modeGo:
+			// go build builds the package in the current directory
+			candidates = []string{goExecutableName(curdir)}
+		case modeZig:
+			candidates = append([]string{filepath.Join("zig-out", "bin", filepath.Base(curdir))}, candidates...)
+		}
+		for _, candidate := range candidates {
+			if isExecutable(candidate) {
+				cmd = exec.Command("./" + candidate)
+				break
+			}
+		}
+	}
+	if cmd == nil {
+		return nil, "", errNothingToRun
+	}
+	return cmd, strings.Join(cmd.Args, " "), nil
+}
+
+// RunProgram runs the program that was built from the current file, or the current script, and then
+// shows the output in a read-only pane. The locations in stack traces, like from Go panics, Python
+// tracebacks and Rust backtraces, are collected in the quickfix list, so that they can be stepped through.
+// Returns a status message, or an error if the program failed.
+func (e *Editor) RunProgram(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar) (string, error) {
+	cmd, name, err := e.RunCommand(e.filename)
+	if err != nil {
+		return "", err
+	}
+	if e.changed {
+		if err := e.Save(c); err != nil {
+			return "", err
+		}
+	}
+	cmd.Env = append(os.Environ(), "RUST_BACKTRACE=1")
+
+	// Save the command in a temporary file
+	saveCommand(cmd)
+
+	output, err := e.RunBuildCommand(c, tty, status, cmd, "Running "+name)
+	if err == errBuildCancelled {
+		return "", errors.New("Running " + name + " was " + err.Error())
+	}
+
+	// Collect the locations in stack traces, but skip the ones in files that are not here, like
+	// the standard library of Rust
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	var entries []QuickfixEntry
+	for _, entry := range ParseErrors(string(output), dir, e.mode) {
+		if entry.filename != "" && exists(entry.filename) {
+			entries = append(entries, entry)
+		}
+	}
+	quickfix.Set(entries)
+
+	if tty != nil && len(output) > 0 {
+		builder.ShowOutput("Output of " + name)
+	}
+
+	absFilename, _ := e.AbsFilename()
+	if firstError, found := FirstError(entries, absFilename); found && err != nil {
+		return "", errors.New(e.goToError(c, status, firstError, absFilename))
+	}
+	if err != nil {
+		return "", errors.New(name + ": " + err.Error())
+	}
+	return "Ran " + name, nil
+}
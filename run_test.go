@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoExecutableName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/tool/v2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "cmd", "hello"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if name := goExecutableName(dir); name != "tool" {
+		t.Errorf("expected the major version to be skipped, got %s", name)
+	}
+	if name := goExecutableName(filepath.Join(dir, "cmd", "hello")); name != "hello" {
+		t.Errorf("expected hello, got %s", name)
+	}
+}
+
+func TestRunProgram(t *testing.T) {
+	if which("python") == "" {
+		t.Skip("python is not installed")
+	}
+	dir, err := ioutil.TempDir("", "o_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := "def f(x):\n    return 1 / x\n\nf(0)\n"
+	filename := filepath.Join(dir, "main.py")
+	if err := ioutil.WriteFile(filename, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := NewSimpleEditor(80)
+	e.InsertStringAndMove(nil, source)
+	e.filename, e.mode, e.changed = filename, modePython, false
+
+	_, err = e.RunProgram(nil, nil, nil)
+	if err == nil || err.Error() != "division by zero" {
+		t.Errorf("expected the error from the traceback, got %v", err)
+	}
+	var found []string
+	for _, entry := range quickfix.Entries() {
+		found = append(found, entry.String())
+	}
+	expected := []string{filename + ":4: error: division by zero", filename + ":2: error: division by zero"}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, found)
+	}
+	if int(e.DataY()) != 3 {
+		t.Errorf("expected the cursor to be at the first frame, at line 4, got %d", e.LineNumber())
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	// An executable named after the directory, like the ones that ctrl-space builds
+	if err := ioutil.WriteFile(filepath.Base(dir), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewSimpleEditor(80)
+	e.mode = modeC
+	if _, name, err := e.RunCommand("main.c"); err != nil || name != "./"+filepath.Base(dir) {
+		t.Errorf("expected the executable to be run, got %q (%v)", name, err)
+	}
+	// Exporting a document does not produce anything to run
+	e.mode = modeMarkdown
+	if _, name, err := e.RunCommand("README.md"); err != errNothingToRun {
+		t.Errorf("expected nothing to run for a Markdown file, got %q (%v)", name, err)
+	}
+}
@@ -11,7 +11,7 @@ import (
 )
 
 var (
-	searchHistoryFilename = "~/.cache/o/search.txt" // TODO: Use XDG_CACHE_HOME
+	searchHistoryFilename = "search.txt" // in the cache directory
 	searchHistory         = []string{}
 	errNoSearchMatch      = errors.New("no search match")
 )
@@ -203,7 +203,8 @@ func (e *Editor) GoToNextMatch(c *vt100.Canvas, status *StatusBar, wrap, forward
 		return errNoSearchMatch
 	}
 
-	// Go to the found match
+	// Go to the found match, and make it possible to jump back with ctrl-l and the left arrow
+	e.RecordJump(e.DataY(), foundY)
 	e.redraw = e.GoTo(foundY, c, status)
 	if foundX != -1 {
 		tabs := strings.Count(e.Line(foundY), "\t")
@@ -312,10 +313,13 @@ func (e *Editor) SearchMode(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY,
 		if len(trimmedSearchString) > 0 {
 			searchHistory = append(searchHistory, trimmedSearchString)
 			// ignore errors saving the search history, since it's not critical
-			SaveSearchHistory(expandUser(searchHistoryFilename), searchHistory)
+			SaveSearchHistory(cachePath(searchHistoryFilename), searchHistory)
 		} else if len(searchHistory) > 0 {
 			s = searchHistory[searchHistoryIndex]
 			e.SetSearchTerm(c, status, s)
+		} else if e.GoToHottestSpot(c, status) {
+			// Nothing to search for, so jump to the line that has been visited the most lately instead
+			return
 		}
 	}
 
@@ -21,8 +21,8 @@ func (e *Editor) SetUpTerminateHandler(c *vt100.Canvas, status *StatusBar, tty *
 			// Block until the signal is received
 			<-sigChan
 
-			// Quickly save the file
-			e.UserSave(c, status)
+			// Quickly save the file, but don't ask any questions if it has been changed on disk
+			e.UserSave(c, nil, status)
 
 			status.SetMessage("ctrl-c")
 			status.Show(c, e)
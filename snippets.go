@@ -0,0 +1,352 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/vt100"
+)
+
+// snippetDir is where snippets for each mode can be placed, in files like "go.snippets"
+const snippetDir = "~/.config/o/snippets"
+
+// sourceSnippet is shown next to snippet names in the completion popup
+const sourceSnippet = "snippet"
+
+// Snippet is a named piece of text that can be expanded by pressing tab after the name.
+// The body can contain placeholders like ${1:name}, $1 or ${0}.
+type Snippet struct {
+	name        string
+	description string
+	body        string
+}
+
+// snippetPart is either a piece of text, or a numbered field, if field is 0 or larger
+type snippetPart struct {
+	text  string
+	field int
+}
+
+// The snippets that have been loaded so far, per mode
+var loadedSnippets = make(map[Mode]map[string]Snippet)
+
+// parseSnippets parses snippets in the same format as snipMate:
+//
+//	# a comment
+//	snippet name an optional description
+//		the body, indented with one tab
+func parseSnippets(text string) map[string]Snippet {
+	snippets := make(map[string]Snippet)
+	var (
+		current *Snippet
+		body    []string
+	)
+	done := func() {
+		if current != nil {
+			// Remove trailing blank lines
+			for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+				body = body[:len(body)-1]
+			}
+			current.body = strings.Join(body, "\n")
+			snippets[current.name] = *current
+		}
+		current, body = nil, nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "snippet "):
+			done()
+			fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "snippet ")), " ", 2)
+			current = &Snippet{name: fields[0]}
+			if len(fields) > 1 {
+				current.description = strings.TrimSpace(fields[1])
+			}
+		case current != nil && strings.HasPrefix(line, "\t"):
+			body = append(body, line[1:])
+		case current != nil && strings.TrimSpace(line) == "":
+			body = append(body, "")
+		default:
+			done()
+		}
+	}
+	done()
+	return snippets
+}
+
+// parseSnippetBody splits the body of a snippet into text and fields.
+// The default values of the fields are returned in a map.
+func parseSnippetBody(body string) ([]snippetPart, map[int]string) {
+	var (
+		parts    []snippetPart
+		defaults = make(map[int]string)
+		text     []rune
+		runes    = []rune(body)
+	)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\\' && i+1 < len(runes) && (runes[i+1] == '$' || runes[i+1] == '}' || runes[i+1] == '\\') {
+			i++
+			text = append(text, runes[i])
+			continue
+		}
+		if r != '$' || i+1 >= len(runes) {
+			text = append(text, r)
+			continue
+		}
+		// Find the number, and the default value, if there is one
+		j := i + 1
+		braces := runes[j] == '{'
+		if braces {
+			j++
+		}
+		start := j
+		for j < len(runes) && unicode.IsDigit(runes[j]) {
+			j++
+		}
+		if j == start {
+			// Not a placeholder
+			text = append(text, r)
+			continue
+		}
+		n, _ := strconv.Atoi(string(runes[start:j]))
+		if braces {
+			var value []rune
+			if j < len(runes) && runes[j] == ':' {
+				for j++; j < len(runes) && runes[j] != '}'; j++ {
+					if runes[j] == '\\' && j+1 < len(runes) {
+						j++
+					}
+					value = append(value, runes[j])
+				}
+			}
+			if j >= len(runes) || runes[j] != '}' {
+				// Not a complete placeholder
+				text = append(text, r)
+				continue
+			}
+			if _, found := defaults[n]; !found || len(value) > 0 {
+				defaults[n] = string(value)
+			}
+			j++
+		} else if _, found := defaults[n]; !found {
+			defaults[n] = ""
+		}
+		if len(text) > 0 {
+			parts = append(parts, snippetPart{text: string(text), field: -1})
+			text = nil
+		}
+		parts = append(parts, snippetPart{field: n})
+		i = j - 1
+	}
+	if len(text) > 0 {
+		parts = append(parts, snippetPart{text: string(text), field: -1})
+	}
+	return parts, defaults
+}
+
+// renderSnippet returns the text of the snippet, with the given values filled in,
+// and the position in the text (as a rune index) at the end of the first occurrence of each field
+func renderSnippet(parts []snippetPart, values map[int]string) (string, map[int]int) {
+	var sb strings.Builder
+	ends := make(map[int]int)
+	length := 0
+	for _, part := range parts {
+		s := part.text
+		if part.field >= 0 {
+			s = values[part.field]
+		}
+		sb.WriteString(s)
+		length += len([]rune(s))
+		if _, found := ends[part.field]; part.field >= 0 && !found {
+			ends[part.field] = length
+		}
+	}
+	return sb.String(), ends
+}
+
+// Snippets returns the snippets for the current mode. The bundled snippets are
+// loaded first, then the snippets in the configuration directory, which can replace them.
+func (e *Editor) Snippets() map[string]Snippet {
+	if snippets, found := loadedSnippets[e.mode]; found {
+		return snippets
+	}
+	snippets := parseSnippets(defaultSnippets[e.mode])
+	if data, err := ioutil.ReadFile(filepath.Join(expandUser(snippetDir), e.mode.Name()+".snippets")); err == nil {
+		for name, snippet := range parseSnippets(string(data)) {
+			snippets[name] = snippet
+		}
+	}
+	loadedSnippets[e.mode] = snippets
+	return snippets
+}
+
+// SnippetCompletions returns the names of the snippets that start with the given word
+func (e *Editor) SnippetCompletions(word string) []Completion {
+	var completions []Completion
+	for name, snippet := range e.Snippets() {
+		if strings.HasPrefix(name, word) {
+			completions = append(completions, Completion{name, sourceSnippet, snippet.description})
+		}
+	}
+	sort.Slice(completions, func(i, j int) bool {
+		return completions[i].text < completions[j].text
+	})
+	return completions
+}
+
+// replaceLines replaces the given number of lines, starting at y, with the given lines
+func (e *Editor) replaceLines(y LineIndex, oldCount int, newLines []string) {
+	if delta := len(newLines) - oldCount; delta != 0 {
+		lines := make(map[int][]rune, len(e.lines)+delta)
+		for k, v := range e.lines {
+			if k < int(y) {
+				lines[k] = v
+			} else if k >= int(y)+oldCount {
+				lines[k+delta] = v
+			}
+		}
+		e.lines = lines
+		e.bookmarks = e.bookmarks.Shift(y+LineIndex(oldCount), delta)
+	}
+	for i, line := range newLines {
+		e.lines[int(y)+i] = []rune(line)
+	}
+	e.changed = true
+}
+
+// goToDataPosition moves the cursor to the given line index and rune index
+func (e *Editor) goToDataPosition(c *vt100.Canvas, status *StatusBar, y LineIndex, x int) {
+	jumpList.Pause()
+	e.redraw = e.GoTo(y, c, status)
+	jumpList.Resume()
+	runes := e.lines[int(y)]
+	if x > len(runes) {
+		x = len(runes)
+	}
+	tabs := strings.Count(string(runes[:x]), "\t")
+	e.pos.sx = x + (tabs * (e.tabs.spacesPerTab - 1))
+	e.HorizontalScrollIfNeeded(c)
+	e.redrawCursor = true
+}
+
+// ExpandSnippet replaces the given word before the cursor with the given snippet.
+// Then tab jumps from field to field, in the order of their numbers, ending at ${0} or at the end
+// of the snippet. Typing replaces the contents of the current field, and every other
+// occurrence of the same field is updated at the same time. Esc or return stops.
+func (e *Editor) ExpandSnippet(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, word string, snippet Snippet) {
+	y := e.DataY()
+	line := e.lines[int(y)]
+	x, err := e.DataX()
+	if err != nil || x > len(line) {
+		x = len(line)
+	}
+	x0 := x - len([]rune(word))
+	if x0 < 0 {
+		x0 = 0
+	}
+	prefix, suffix := string(line[:x0]), string(line[x:])
+
+	// Indent the lines in the body like the current line, and use the configured indentation
+	indentation := e.LeadingWhitespace()
+	bodyLines := strings.Split(snippet.body, "\n")
+	for i, bodyLine := range bodyLines {
+		trimmed := strings.TrimLeft(bodyLine, "\t")
+		bodyLine = strings.Repeat(e.tabs.String(), len(bodyLine)-len(trimmed)) + trimmed
+		if i > 0 && bodyLine != "" {
+			bodyLine = indentation + bodyLine
+		}
+		bodyLines[i] = bodyLine
+	}
+	parts, values := parseSnippetBody(strings.Join(bodyLines, "\n"))
+
+	// The fields that tab jumps through, with ${0} last
+	var fields []int
+	for n := range values {
+		if n > 0 {
+			fields = append(fields, n)
+		}
+	}
+	sort.Ints(fields)
+	if _, found := values[0]; found {
+		fields = append(fields, 0)
+	}
+
+	lineCount := 1
+	// render replaces the lines of the snippet and moves the cursor to the end of the given field.
+	// If the field is not found, the cursor is placed at the end of the snippet.
+	render := func(field int) {
+		text, ends := renderSnippet(parts, values)
+		newLines := strings.Split(prefix+text+suffix, "\n")
+		e.replaceLines(y, lineCount, newLines)
+		lineCount = len(newLines)
+		end, found := ends[field]
+		if !found {
+			end = len([]rune(text))
+		}
+		// Find the line and the rune index of the end of the field
+		before := strings.Split(prefix+string([]rune(text)[:end]), "\n")
+		e.goToDataPosition(c, status, y+LineIndex(len(before)-1), len([]rune(before[len(before)-1])))
+	}
+
+	if len(fields) == 0 {
+		render(-1)
+		return
+	}
+	for _, field := range fields {
+		render(field)
+		if field == 0 {
+			// ${0} is where the cursor ends up when the snippet is done
+			status.ClearAll(c)
+			e.redraw = true
+			return
+		}
+		// Typing replaces the default value, until the field has been edited
+		fresh := true
+		for {
+			e.DrawLines(c, true, false)
+			status.ClearAll(c)
+			status.SetMessage("Snippet " + snippet.name + ": tab for the next field, esc to stop")
+			status.ShowNoTimeout(c, e)
+			vt100.SetXY(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()))
+
+			key := tty.String()
+			if key == "c:9" { // tab
+				break
+			}
+			switch key {
+			case "c:27", "c:17", "c:13": // esc, ctrl-q or return
+				status.ClearAll(c)
+				e.redraw = true
+				return
+			case "c:8", "c:127": // ctrl-h or backspace
+				if fresh {
+					values[field] = ""
+				} else if runes := []rune(values[field]); len(runes) > 0 {
+					values[field] = string(runes[:len(runes)-1])
+				}
+			default:
+				runes := []rune(key)
+				if len(runes) != 1 || strings.HasPrefix(key, "c:") || !unicode.IsPrint(runes[0]) {
+					// Not a key that can be typed into a field
+					continue
+				}
+				if fresh {
+					values[field] = key
+				} else {
+					values[field] += key
+				}
+			}
+			fresh = false
+			render(field)
+		}
+	}
+	// Tab was pressed at the last field, go to the end of the snippet
+	render(-1)
+	status.ClearAll(c)
+	e.redraw = true
+}
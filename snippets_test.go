@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSnippets(t *testing.T) {
+	snippets := parseSnippets("# a comment\nsnippet iferr check the error\n\tif err != nil {\n\t\treturn ${1:err}\n\t}\n\nsnippet x\n\t$0\n")
+	if len(snippets) != 2 {
+		t.Fatalf("expected 2 snippets, got %d", len(snippets))
+	}
+	iferr := snippets["iferr"]
+	if iferr.description != "check the error" {
+		t.Errorf("unexpected description: %q", iferr.description)
+	}
+	if iferr.body != "if err != nil {\n\treturn ${1:err}\n}" {
+		t.Errorf("unexpected body: %q", iferr.body)
+	}
+	for mode, text := range defaultSnippets {
+		if len(parseSnippets(text)) == 0 {
+			t.Errorf("no default snippets could be parsed for %s", mode.Name())
+		}
+	}
+}
+
+func TestRenderSnippet(t *testing.T) {
+	parts, values := parseSnippetBody(`for ${1:i} := 0; $1 < ${2:n}; $1++ { ${0} } \$3`)
+	if len(values) != 3 || values[1] != "i" || values[2] != "n" || values[0] != "" {
+		t.Fatalf("unexpected default values: %v", values)
+	}
+	text, ends := renderSnippet(parts, values)
+	if text != "for i := 0; i < n; i++ {  } $3" {
+		t.Errorf("unexpected text: %q", text)
+	}
+	if ends[1] != 5 || ends[0] != 25 {
+		t.Errorf("unexpected field positions: %v", ends)
+	}
+	// Mirrored fields are updated together
+	values[1] = "j"
+	if text, _ := renderSnippet(parts, values); text != "for j := 0; j < n; j++ {  } $3" {
+		t.Errorf("unexpected text: %q", text)
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
-
-	"github.com/xyproto/vt100"
 )
 
 // corpus will grep all files matching the glob for "searchword.*" and return a list of what matched "*".
@@ -59,54 +57,3 @@ func corpus(searchword, glob string) []string {
 
 	return sl
 }
-
-// SuggestMode lets the user tab through the suggested words
-func (e *Editor) SuggestMode(c *vt100.Canvas, status *StatusBar, tty *vt100.TTY, suggestions []string) string {
-	if len(suggestions) == 0 {
-		return ""
-	}
-
-	suggestIndex := 0
-	s := suggestions[suggestIndex]
-
-	status.ClearAll(c)
-	status.SetMessage("Suggest: " + s)
-	status.ShowNoTimeout(c, e)
-
-	var doneChoosing bool
-	for !doneChoosing {
-		key := tty.String()
-		switch key {
-		case "c:9", "↓", "→": // tab, down arrow or right arrow
-			// Cycle suggested words
-			suggestIndex++
-			if suggestIndex == len(suggestions) {
-				suggestIndex = 0
-			}
-			s = suggestions[suggestIndex]
-			status.ClearAll(c)
-			status.SetMessage("Suggest: " + s)
-			status.ShowNoTimeout(c, e)
-		case "↑", "←": // up arrow or left arrow
-			// Cycle suggested words (one back)
-			suggestIndex--
-			if suggestIndex < 0 {
-				suggestIndex = len(suggestions) - 1
-			}
-			s = suggestions[suggestIndex]
-			status.ClearAll(c)
-			status.SetMessage("Suggest: " + s)
-			status.ShowNoTimeout(c, e)
-		case "c:8", "c:127": // ctrl-h or backspace
-			fallthrough
-		case "c:27", "c:17": // esc, ctrl-q or backspace
-			s = ""
-			fallthrough
-		case "c:13", "c:32": // return or space
-			doneChoosing = true
-		}
-	}
-	status.ClearAll(c)
-	// The chosen word
-	return s
-}
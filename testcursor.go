@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+var (
+	errNoTestUnderCursor = errors.New("no test under the cursor")
+
+	goTestFuncRegexp    = regexp.MustCompile(`^func ((Test|Benchmark|Example|Fuzz)\w*)\(`)
+	goSubtestRegexp     = regexp.MustCompile(`^(\s*)\w+\.Run\(\s*(.*)`)
+	goStringLiteral     = regexp.MustCompile(`^"(?:[^"\\]|\\.)*"`)
+	rustFuncRegexp      = regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?fn\s+(\w+)`)
+	rustTestAttrRegexp  = regexp.MustCompile(`^\s*#\[(?:\w+::)*test\b`)
+	rustModRegexp       = regexp.MustCompile(`^(\s*)(?:pub(?:\([^)]*\))?\s+)?mod\s+(\w+)\s*\{`)
+	pythonFuncRegexp    = regexp.MustCompile(`^(\s*)(?:async\s+)?def\s+(test\w*)\s*\(`)
+	pythonAnyFuncRegexp = regexp.MustCompile(`^(\s*)(?:async\s+)?def\s+\w+`)
+	pythonClassRegexp   = regexp.MustCompile(`^(\s*)class\s+(\w+)`)
+)
+
+// leadingWhitespaceLen returns the number of spaces and tabs at the start of the given line
+func leadingWhitespaceLen(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// findGoTest finds the Go test, benchmark, example or fuzz function around the given line,
+// and the names of the subtests, from t.Run("name", ...), that the line is within
+func findGoTest(lines []string, y int) (name string, subtests []string, ok bool) {
+	start := -1
+	for i := y; i >= 0 && i < len(lines); i-- {
+		if strings.HasPrefix(lines[i], "}") && i < y {
+			// The end of a function that the line is not within
+			return "", nil, false
+		}
+		if strings.HasPrefix(lines[i], "func ") {
+			if match := goTestFuncRegexp.FindStringSubmatch(lines[i]); match != nil {
+				name, start = match[1], i
+			}
+			break
+		}
+	}
+	if start < 0 {
+		return "", nil, false
+	}
+	// Find the subtests that are open at the given line
+	type subtest struct {
+		indent  int
+		name    string
+		literal bool
+	}
+	var stack []subtest
+	for i := start + 1; i < y; i++ {
+		line := lines[i]
+		indent := leadingWhitespaceLen(line)
+		if strings.HasPrefix(strings.TrimSpace(line), "}") {
+			for len(stack) > 0 && indent <= stack[len(stack)-1].indent {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if match := goSubtestRegexp.FindStringSubmatch(line); match != nil {
+			st := subtest{indent: indent}
+			if literal := goStringLiteral.FindString(match[2]); literal != "" {
+				st.name, _ = strconv.Unquote(literal)
+				st.literal = true
+			}
+			stack = append(stack, st)
+		}
+	}
+	for _, st := range stack {
+		if !st.literal {
+			// The name is only known when the test runs, so run all the subtests from here
+			break
+		}
+		subtests = append(subtests, st.name)
+	}
+	return name, subtests, true
+}
+
+// goTestArgs returns the arguments to go test for running only the given test and subtests
+func goTestArgs(name string, subtests []string) []string {
+	if strings.HasPrefix(name, "Benchmark") {
+		return []string{"test", "-json", "-run", "^$", "-bench", "^" + name + "$"}
+	}
+	pattern := "^" + regexp.QuoteMeta(name) + "$"
+	for _, subtest := range subtests {
+		// go test replaces spaces in the names of subtests with underscores
+		pattern += "/^" + regexp.QuoteMeta(strings.Replace(subtest, " ", "_", -1)) + "$"
+	}
+	return []string{"test", "-json", "-failfast", "-run", pattern}
+}
+
+// findRustTest finds the Rust function with a #[test] attribute around the given line,
+// and the names of the modules it is within, in the same file
+func findRustTest(lines []string, y int) (name string, modules []string, ok bool) {
+	start, indent := -1, 0
+	for i := y; i >= 0 && i < len(lines); i-- {
+		if match := rustFuncRegexp.FindStringSubmatch(lines[i]); match != nil {
+			name, start, indent = match[2], i, len(match[1])
+			break
+		}
+	}
+	if start < 0 {
+		return "", nil, false
+	}
+	// Check that the line is within the function
+	for i := start + 1; i < y; i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "}") && leadingWhitespaceLen(lines[i]) <= indent {
+			return "", nil, false
+		}
+	}
+	// Check that there is a test attribute, like #[test] or #[tokio::test], above the function
+	isTest := false
+	for i := start - 1; i >= 0; i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if rustTestAttrRegexp.MatchString(lines[i]) {
+			isTest = true
+			break
+		}
+		if !strings.HasPrefix(trimmed, "#[") && !strings.HasPrefix(trimmed, "//") && trimmed != "" {
+			break
+		}
+	}
+	if !isTest {
+		return "", nil, false
+	}
+	// Find the modules the function is within, like "mod tests {"
+	for i := start - 1; i >= 0; i-- {
+		if match := rustModRegexp.FindStringSubmatch(lines[i]); match != nil && len(match[1]) < indent {
+			modules = append([]string{match[2]}, modules...)
+			indent = len(match[1])
+		}
+	}
+	return name, modules, true
+}
+
+// rustModulePath returns the module path of the given source file, relative to the root of the crate,
+// and the name of the integration test, if the file is in the tests directory
+func rustModulePath(root, absFilename string) (modules []string, integrationTest string) {
+	rel, err := filepath.Rel(root, absFilename)
+	if err != nil {
+		return nil, ""
+	}
+	parts := strings.Split(strings.TrimSuffix(filepath.ToSlash(rel), ".rs"), "/")
+	if len(parts) == 2 && parts[0] == "tests" {
+		return nil, parts[1]
+	}
+	if len(parts) == 0 || parts[0] != "src" {
+		return nil, ""
+	}
+	parts = parts[1:]
+	if len(parts) == 1 && (parts[0] == "lib" || parts[0] == "main") {
+		return nil, ""
+	}
+	if len(parts) > 0 && parts[len(parts)-1] == "mod" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts, ""
+}
+
+// findPythonTest finds the Python test function or method around the given line,
+// and the name of the class it is in, if any
+func findPythonTest(lines []string, y int) (class, name string, ok bool) {
+	start, indent := -1, 0
+	for i := y; i >= 0 && i < len(lines); i-- {
+		if match := pythonAnyFuncRegexp.FindStringSubmatch(lines[i]); match != nil {
+			if match := pythonFuncRegexp.FindStringSubmatch(lines[i]); match != nil {
+				name, start, indent = match[2], i, len(match[1])
+			}
+			break
+		}
+	}
+	if start < 0 {
+		return "", "", false
+	}
+	// Check that the line is within the function
+	for i := start + 1; i <= y && i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") && leadingWhitespaceLen(lines[i]) <= indent {
+			return "", "", false
+		}
+	}
+	if indent == 0 {
+		return "", name, true
+	}
+	for i := start - 1; i >= 0; i-- {
+		if match := pythonClassRegexp.FindStringSubmatch(lines[i]); match != nil && len(match[1]) < indent {
+			return match[2], name, true
+		}
+	}
+	return "", name, true
+}
+
+// findUpwards searches the given directory and the directories above for the given filename,
+// and returns the directory where it was found
+func findUpwards(dir, filename string) (string, bool) {
+	for {
+		if exists(filepath.Join(dir, filename)) {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// TestCommandUnderCursor returns a command for running only the test that the cursor is in,
+// together with the name of the test. Go, Rust with cargo and Python with pytest or unittest are supported.
+func (e *Editor) TestCommandUnderCursor() (*exec.Cmd, string, error) {
+	absFilename, err := e.AbsFilename()
+	if err != nil {
+		return nil, "", err
+	}
+	dir := filepath.Dir(absFilename)
+	lines := strings.Split(e.String(), "\n")
+	y := int(e.DataY())
+
+	var cmd *exec.Cmd
+	switch e.mode {
+	case modeGo:
+		if !strings.HasSuffix(absFilename, "_test.go") {
+			return nil, "", errNoTestUnderCursor
+		}
+		name, subtests, ok := findGoTest(lines, y)
+		if !ok {
+			return nil, "", errNoTestUnderCursor
+		}
+		cmd = exec.Command("go", goTestArgs(name, subtests)...)
+		cmd.Dir = dir
+		return cmd, strings.Join(append([]string{name}, subtests...), "/"), nil
+	case modeRust:
+		name, modules, ok := findRustTest(lines, y)
+		if !ok {
+			return nil, "", errNoTestUnderCursor
+		}
+		root, found := findUpwards(dir, "Cargo.toml")
+		if !found {
+			return nil, "", errors.New("running a single test needs a Cargo.toml file")
+		}
+		filePath, integrationTest := rustModulePath(root, absFilename)
+		path := strings.Join(append(append(filePath, modules...), name), "::")
+		args := []string{"test", "--message-format=json"}
+		if integrationTest != "" {
+			args = append(args, "--test", integrationTest)
+		}
+		cmd = exec.Command("cargo", append(args, "--", "--exact", path)...)
+		cmd.Dir = root
+		return cmd, path, nil
+	case modePython:
+		class, name, ok := findPythonTest(lines, y)
+		if !ok {
+			return nil, "", errNoTestUnderCursor
+		}
+		fullName := name
+		if class != "" {
+			fullName = class + "." + name
+		}
+		if which("pytest") != "" {
+			cmd = exec.Command("pytest", "-q", filepath.Base(absFilename)+"::"+strings.Replace(fullName, ".", "::", -1))
+		} else if class != "" {
+			module := strings.TrimSuffix(filepath.Base(absFilename), ".py")
+			cmd = exec.Command("python", "-m", "unittest", module+"."+fullName)
+		} else {
+			return nil, "", errors.New("running a test function that is not in a class needs pytest")
+		}
+		cmd.Dir = dir
+		return cmd, fullName, nil
+	}
+	return nil, "", errNoTestUnderCursor
+}
+
+// RunTestUnderCursor saves the file and runs only the test that the cursor is in.
+// The failures are collected in the quickfix list, and the cursor is moved to the first one,
+// if it is in this file. Returns a status message, or an error if the test failed.
+func (e *Editor) RunTestUnderCursor(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar) (string, error) {
+	cmd, name, err := e.TestCommandUnderCursor()
+	if err != nil {
+		return "", err
+	}
+	if e.changed {
+		if err := e.Save(c); err != nil {
+			return "", err
+		}
+	}
+
+	// Save the command in a temporary file
+	saveCommand(cmd)
+
+	// The coverage is not refreshed here, since running a single test would replace the coverage
+	// of the package with the coverage of that test
+	output, err := e.RunBuildCommand(c, tty, status, cmd, "Testing "+name)
+	if err == errBuildCancelled {
+		return "", errors.New("Testing " + name + " was " + err.Error())
+	}
+	entries := ParseDiagnostics(string(output), cmd.Dir, e.mode)
+	quickfix.Set(entries)
+	absFilename, _ := e.AbsFilename()
+	if firstError, found := FirstError(entries, absFilename); found {
+		return "", errors.New("Test failed: " + e.goToError(c, status, firstError, absFilename))
+	}
+	if err != nil {
+		return "", errors.New("Test failed: " + name)
+	}
+	return "Test passed: " + name, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindGoTest(t *testing.T) {
+	lines := strings.Split(`package main
+
+func TestAdd(t *testing.T) {
+	t.Run("small numbers", func(t *testing.T) {
+		if add(1, 2) != 3 {
+			t.Fail()
+		}
+	})
+	t.Run("big", func(t *testing.T) {
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				t.Fail()
+			})
+		}
+	})
+}
+
+func helper() {
+}`, "\n")
+	if name, subtests, ok := findGoTest(lines, 5); !ok || name != "TestAdd" || strings.Join(subtests, "/") != "small numbers" {
+		t.Errorf("expected TestAdd/small numbers, got %s %v %v", name, subtests, ok)
+	}
+	if name, subtests, ok := findGoTest(lines, 8); !ok || name != "TestAdd" || len(subtests) != 0 {
+		t.Errorf("expected TestAdd without subtests, got %s %v %v", name, subtests, ok)
+	}
+	if _, subtests, _ := findGoTest(lines, 11); strings.Join(subtests, "/") != "big" {
+		t.Errorf("expected only the subtest with a literal name, got %v", subtests)
+	}
+	if _, _, ok := findGoTest(lines, 17); ok {
+		t.Error("expected no test in a helper function")
+	}
+	if args := goTestArgs("TestAdd", []string{"small numbers"}); args[len(args)-1] != "^TestAdd$/^small_numbers$" {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+	if args := goTestArgs("BenchmarkAdd", nil); strings.Join(args, " ") != "test -json -run ^$ -bench ^BenchmarkAdd$" {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+}
+
+func TestFindRustTest(t *testing.T) {
+	lines := strings.Split(`fn add(a: i32, b: i32) -> i32 {
+    a + b
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn it_adds() {
+        assert_eq!(add(1, 2), 3);
+    }
+}`, "\n")
+	if name, modules, ok := findRustTest(lines, 10); !ok || name != "it_adds" || strings.Join(modules, "::") != "tests" {
+		t.Errorf("expected tests::it_adds, got %v %s %v", modules, name, ok)
+	}
+	if _, _, ok := findRustTest(lines, 1); ok {
+		t.Error("expected no test in a function without #[test]")
+	}
+	if modules, test := rustModulePath("/src/p", "/src/p/src/math/mod.rs"); strings.Join(modules, "::") != "math" || test != "" {
+		t.Errorf("unexpected module path: %v %q", modules, test)
+	}
+	if modules, test := rustModulePath("/src/p", "/src/p/tests/api.rs"); len(modules) != 0 || test != "api" {
+		t.Errorf("expected an integration test, got %v %q", modules, test)
+	}
+}
+
+func TestFindPythonTest(t *testing.T) {
+	lines := strings.Split(`import unittest
+
+def test_add():
+    assert 1 + 2 == 3
+
+class TestMath(unittest.TestCase):
+    def setUp(self):
+        pass
+
+    def test_sub(self):
+        self.assertEqual(3 - 2, 1)
+`, "\n")
+	if class, name, ok := findPythonTest(lines, 3); !ok || class != "" || name != "test_add" {
+		t.Errorf("expected test_add, got %q %q %v", class, name, ok)
+	}
+	if class, name, ok := findPythonTest(lines, 10); !ok || class != "TestMath" || name != "test_sub" {
+		t.Errorf("expected TestMath.test_sub, got %q %q %v", class, name, ok)
+	}
+	if _, _, ok := findPythonTest(lines, 7); ok {
+		t.Error("expected no test in setUp")
+	}
+}
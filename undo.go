@@ -65,6 +65,25 @@ func (u *Undo) Restore(e *Editor) error {
 	return errors.New("no undo state at this index")
 }
 
+// Lines returns the lines of the most recent snapshot, or nil if there is none
+func (u *Undo) Lines() []string {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+	index := u.index - 1
+	if index < 0 {
+		index = u.size - 1
+	}
+	if !u.hasSomething[index] {
+		return nil
+	}
+	m := u.editorLineCopies[index]
+	lines := make([]string, len(m))
+	for i := range lines {
+		lines[i] = string(m[i])
+	}
+	return lines
+}
+
 // Index will return the current undo index, in the undo buffers
 func (u *Undo) Index() int {
 	return u.index
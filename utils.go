@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -43,6 +44,64 @@ func expandUser(path string) string {
 	return path
 }
 
+// cacheDirectory returns the directory where o keeps the files it writes between sessions,
+// which is $XDG_CACHE_HOME/o, or ~/.cache/o if XDG_CACHE_HOME is not set to an absolute path
+func cacheDirectory() string {
+	if xdgCacheHome := os.Getenv("XDG_CACHE_HOME"); filepath.IsAbs(xdgCacheHome) {
+		return filepath.Join(xdgCacheHome, "o")
+	}
+	return expandUser("~/.cache/o")
+}
+
+// cachePath returns the path to the given file or directory within the cache directory
+func cachePath(name string) string {
+	return filepath.Join(cacheDirectory(), name)
+}
+
+// writeFileAtomic writes the given data to a temporary file in the same directory
+// as filename, syncs it to disk and then renames it over filename. This way, the
+// file on disk is either the old version or the new version, never half written.
+// The owner and group are set to uid and gid, unless they are -1.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode, uid, gid int) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	tempFilename := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tempFilename)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tempFilename)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	if err := os.Chmod(tempFilename, perm); err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		// Only root can give away files, so ignore errors
+		os.Chown(tempFilename, uid, gid)
+	}
+	if err := os.Rename(tempFilename, filename); err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	// Sync the directory as well, so that the rename is on disk. Ignore errors.
+	if d, err := os.Open(filepath.Dir(filename)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
 // hasAnyPrefixWord checks if the given line is prefixed with any one of the given words
 func hasAnyPrefixWord(line string, wordList []string) bool {
 	for _, word := range wordList {
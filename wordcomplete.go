@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// Where a completion came from, for words in open buffers and in nearby files
+	sourceBuffer = "buffer"
+	sourceFile   = "file"
+
+	// In modes without keywords, like plain text, only complete words that are at least this long
+	minTextCompletionLength = 6
+
+	// Only look at this many files in the same directory, and only if they are not too large
+	maxNearbyFiles    = 64
+	maxNearbyFileSize = 1024 * 1024
+)
+
+// The words found in nearby files, cached by filename and modification time,
+// so that files are not read again every time tab is pressed
+var (
+	nearbyWordCache    = make(map[string]nearbyWords)
+	nearbyWordCacheMut = &sync.RWMutex{}
+)
+
+type nearbyWords struct {
+	modTime time.Time
+	counts  map[string]int
+}
+
+// isIdentifierRune returns true if the given rune can be part of an identifier
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// identifiers returns all identifiers and numbers in the given text
+func identifiers(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !isIdentifierRune(r)
+	})
+}
+
+// countIdentifiers counts the identifiers in the given text that start with a letter or an underscore
+func countIdentifiers(text string, counts map[string]int) {
+	for _, word := range identifiers(text) {
+		if r := []rune(word)[0]; unicode.IsLetter(r) || r == '_' {
+			counts[word]++
+		}
+	}
+}
+
+// wordsInFile returns the number of times each identifier is used in the given file
+func wordsInFile(filename string, fi os.FileInfo) map[string]int {
+	nearbyWordCacheMut.RLock()
+	cached, found := nearbyWordCache[filename]
+	nearbyWordCacheMut.RUnlock()
+	if found && cached.modTime.Equal(fi.ModTime()) {
+		return cached.counts
+	}
+	counts := make(map[string]int)
+	data, err := ioutil.ReadFile(filename)
+	// Skip files that look like binary files
+	if err == nil && !bytes.Contains(data, []byte{0}) {
+		countIdentifiers(string(data), counts)
+	}
+	nearbyWordCacheMut.Lock()
+	nearbyWordCache[filename] = nearbyWords{fi.ModTime(), counts}
+	nearbyWordCacheMut.Unlock()
+	return counts
+}
+
+// IdentifierBeforeCursor returns the letters, digits and underscores before the cursor
+func (e *Editor) IdentifierBeforeCursor() string {
+	x, err := e.DataX()
+	if err != nil {
+		x = len(e.lines[int(e.DataY())])
+	}
+	return e.IdentifierBefore(e.DataY(), x)
+}
+
+// IdentifierBefore returns the letters, digits and underscores before the given position, on the given line
+func (e *Editor) IdentifierBefore(y LineIndex, x int) string {
+	runes := e.lines[int(y)]
+	if x > len(runes) {
+		x = len(runes)
+	}
+	start := x
+	for start > 0 && isIdentifierRune(runes[start-1]) {
+		start--
+	}
+	return string(runes[start:x])
+}
+
+// WordCompletions returns the identifiers that start with the given word, from the current buffer,
+// the buffer that was switched from and the files in the same directory with the same extension.
+// Words that are used often and close to the cursor come first.
+func (e *Editor) WordCompletions(word string) []Completion {
+	if word == "" {
+		return nil
+	}
+	scores := make(map[string]float64)
+	sources := make(map[string]string)
+	add := func(counts map[string]int, weight float64, source string) {
+		for w, count := range counts {
+			if w == word || !strings.HasPrefix(w, word) {
+				continue
+			}
+			scores[w] += float64(count) * weight
+			if _, found := sources[w]; !found || source == sourceBuffer {
+				sources[w] = source
+			}
+		}
+	}
+
+	// Words in the current buffer count more the closer they are to the cursor
+	cursorY := int(e.DataY())
+	l := e.Len()
+	for y := 0; y < l; y++ {
+		counts := make(map[string]int)
+		countIdentifiers(e.Line(LineIndex(y)), counts)
+		distance := y - cursorY
+		if distance < 0 {
+			distance = -distance
+		}
+		add(counts, 1+10/float64(1+distance), sourceBuffer)
+	}
+
+	// Words in the buffer that was switched from
+	if lines := switchBuffer.Lines(); len(lines) > 0 {
+		counts := make(map[string]int)
+		countIdentifiers(strings.Join(lines, "\n"), counts)
+		add(counts, 0.5, sourceBuffer)
+	}
+
+	// Words in files in the same directory, with the same extension
+	if absFilename, err := e.AbsFilename(); err == nil && filepath.Ext(absFilename) != "" {
+		ext := filepath.Ext(absFilename)
+		filenames, _ := filepath.Glob(filepath.Join(filepath.Dir(absFilename), "*"+ext))
+		if len(filenames) > maxNearbyFiles {
+			filenames = filenames[:maxNearbyFiles]
+		}
+		for _, filename := range filenames {
+			if filename == absFilename {
+				continue
+			}
+			if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() && fi.Size() <= maxNearbyFileSize {
+				add(wordsInFile(filename, fi), 0.25, sourceFile)
+			}
+		}
+	}
+
+	words := make([]string, 0, len(scores))
+	for w, score := range scores {
+		if score > 0 {
+			words = append(words, w)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if scores[words[i]] == scores[words[j]] {
+			return words[i] < words[j]
+		}
+		return scores[words[i]] > scores[words[j]]
+	})
+	completions := make([]Completion, len(words))
+	for i, w := range words {
+		completions[i] = Completion{w, sources[w], ""}
+	}
+	return completions
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// completionTexts returns the texts of the given completions, separated by spaces
+func completionTexts(completions []Completion) string {
+	var texts []string
+	for _, completion := range completions {
+		texts = append(texts, completion.text)
+	}
+	return strings.Join(texts, " ")
+}
+
+func TestWordCompletionsOrder(t *testing.T) {
+	defer func(u *Undo) { switchBuffer = u }(switchBuffer)
+	switchBuffer = NewUndo(1)
+
+	e := NewSimpleEditor(80)
+	// The cursor is on the first line. The weight of each use is 1+10/(1+distance), so the uses on
+	// line 1 count 6 each, the ones on line 4 count 3 each and the ones on line 9 count 2 each.
+	e.LoadBytes([]byte("al\nalpha\n\n\nalps alps allow\n\n\n\n\nalien alien alien\n"))
+	e.GoTo(0, nil, nil)
+	e.End(nil)
+
+	// alpha, alps and alien all score 6, so they are sorted alphabetically, while allow only scores 3.
+	// The word being completed is left out.
+	expected := "alien alpha alps allow"
+	if got := completionTexts(e.WordCompletions("al")); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	for _, completion := range e.WordCompletions("al") {
+		if completion.source != sourceBuffer {
+			t.Errorf("expected %s to come from the buffer, got %s", completion.text, completion.source)
+		}
+	}
+	if completions := e.WordCompletions(""); len(completions) != 0 {
+		t.Errorf("expected no completions for an empty word, got %v", completions)
+	}
+}
+
+func TestWordCompletionsSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_wordcomplete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A nearby file with the same extension counts 0.25 per use,
+	// and files with other extensions are not used
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.go"), []byte(strings.Repeat("betaFile ", 8)+strings.Repeat("betaMore ", 12)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "c.txt"), []byte(strings.Repeat("betaText ", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The buffer that was switched from counts 0.5 per use
+	defer func(u *Undo) { switchBuffer = u }(switchBuffer)
+	switchBuffer = NewUndo(1)
+	other := NewSimpleEditor(80)
+	other.LoadBytes([]byte(strings.Repeat("betaSwitch ", 4)))
+	switchBuffer.Snapshot(other)
+
+	// A use 9 lines from the cursor counts 2
+	e := NewSimpleEditor(80)
+	e.filename = filepath.Join(dir, "a.go")
+	e.LoadBytes([]byte("beta\n\n\n\n\n\n\n\n\nbetaBuf\n"))
+	e.GoTo(0, nil, nil)
+	e.End(nil)
+
+	completions := e.WordCompletions("beta")
+	expected := "betaMore betaBuf betaFile betaSwitch"
+	if got := completionTexts(completions); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	for i, source := range []string{sourceFile, sourceBuffer, sourceFile, sourceBuffer} {
+		if completions[i].source != source {
+			t.Errorf("expected %s to come from %s, got %s", completions[i].text, source, completions[i].source)
+		}
+	}
+}
+
+func TestWordCompletionsTextMode(t *testing.T) {
+	defer func(u *Undo) { switchBuffer = u }(switchBuffer)
+	switchBuffer = NewUndo(1)
+
+	e := NewSimpleEditor(80)
+	e.mode = modeMarkdown
+
+	// Words shorter than minTextCompletionLength are not completed in text
+	e.LoadBytes([]byte("alphabetical\nalph\n"))
+	e.GoTo(1, nil, nil)
+	e.End(nil)
+	for _, completion := range e.Completions("alph") {
+		if completion.text == "alphabetical" {
+			t.Error("expected short words not to be completed in text")
+		}
+	}
+
+	e.LoadBytes([]byte("alphabetical\nalphab\n"))
+	e.GoTo(1, nil, nil)
+	e.End(nil)
+	found := false
+	for _, completion := range e.Completions("alphab") {
+		if completion.text == "alphabetical" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected words of length %d or more to be completed in text", minTextCompletionLength)
+	}
+}
+
+func TestWordsInFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "o_wordcomplete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "words.go")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write := func(contents string, modTime time.Time) os.FileInfo {
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi
+	}
+
+	counts := wordsInFile(filename, write("first first 42", modTime))
+	if counts["first"] != 2 || len(counts) != 1 {
+		t.Errorf("expected first to be counted twice, and numbers to be skipped, got %v", counts)
+	}
+	// The file is not read again if the modification time is the same
+	counts = wordsInFile(filename, write("second", modTime))
+	if counts["first"] != 2 || counts["second"] != 0 {
+		t.Errorf("expected the cached words, got %v", counts)
+	}
+	// The file is read again when the modification time changes
+	counts = wordsInFile(filename, write("second", modTime.Add(time.Minute)))
+	if counts["first"] != 0 || counts["second"] != 1 {
+		t.Errorf("expected the new words, got %v", counts)
+	}
+}